@@ -2,94 +2,136 @@ package decisiontrees
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	"context"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"github.com/golang/glog"
+	"math/rand"
+	"sort"
 	"time"
 )
 
 type boostingTreeGenerator struct {
 	forestConfig *pb.ForestConfig
 	forest       *pb.Forest
+
+	// seed determines the random sampling in each round, so that a run
+	// resumed from a checkpoint matches an uninterrupted one
+	seed int64
+	// afterRound, if non-nil, is called with the training examples after
+	// each completed round
+	afterRound func(round int, e Examples) error
+	// pool runs the construction of each tree
+	pool *workerPool
 }
 
-func (b *boostingTreeGenerator) doInfluenceTrimming(e Examples) Examples {
-	lossFunction := b.getLossFunction()
+func newBoostingTreeGenerator(forestConfig *pb.ForestConfig) *boostingTreeGenerator {
+	seed := forestConfig.GetSeed()
+	if forestConfig.Seed == nil {
+		seed = time.Now().UnixNano()
+	}
+	return &boostingTreeGenerator{
+		forestConfig: forestConfig,
+		seed:         seed,
+		pool:         newWorkerPool(int(forestConfig.GetNumThreads())),
+	}
+}
 
-	by(func(e1, e2 *pb.Example) bool {
-		return lossFunction.GetSampleImportance(e1) < lossFunction.GetSampleImportance(e2)
-	}).Sort(e)
+// doInfluenceTrimming returns the examples ordered by importance, without
+// the least important, leaving e unmodified
+func (b *boostingTreeGenerator) doInfluenceTrimming(lossFunction LossFunction, e Examples) Examples {
+	importances := make([]float64, len(e))
+	for i, ex := range e {
+		importances[i] = lossFunction.GetSampleImportance(ex)
+	}
+	order := make([]int, len(e))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return importances[order[i]] < importances[order[j]]
+	})
 
 	// Find cutoff point
 	weightSum := 0.0
-	for _, ex := range e {
-		weightSum += lossFunction.GetSampleImportance(ex)
+	for _, importance := range importances {
+		weightSum += importance
 	}
 
 	cutoffPointSum := b.forestConfig.GetInfluenceTrimmingConfig().GetAlpha() * weightSum
 	cutoffPoint, cumulativeSum := 0, 0.0
-	for i, ex := range e {
+	for i, index := range order {
 		cutoffPoint = i
 		if cumulativeSum < cutoffPointSum {
 			break
 		}
-		cumulativeSum += lossFunction.GetSampleImportance(ex)
+		cumulativeSum += importances[index]
 	}
-	return e[cutoffPoint:]
-}
 
-func (b *boostingTreeGenerator) updateExampleWeights(e Examples) {
-	b.getLossFunction().UpdateWeightedLabels(e)
+	result := make(Examples, 0, len(e)-cutoffPoint)
+	for _, index := range order[cutoffPoint:] {
+		result = append(result, e[index])
+	}
+	return result
 }
 
-func (b *boostingTreeGenerator) constructWeakLearner(e Examples) {
+func (b *boostingTreeGenerator) constructWeakLearner(lossFunction LossFunction, e Examples, weightedLabels []float64) {
 	weakLearner := (&regressionSplitter{
-		leafWeight: func(e Examples) float64 {
-			return b.getLossFunction().GetLeafWeight(e)
-		},
+		leafWeight:           lossFunction.GetLeafWeight,
 		featureSelector:      naiveFeatureSelector{},
 		splittingConstraints: b.forestConfig.GetSplittingConstraints(),
 		shrinkageConfig:      b.forestConfig.GetShrinkageConfig(),
-	}).GenerateTree(e)
+		pool:                 b.pool,
+	}).GenerateTree(e, weightedLabels)
 
 	b.forest.Trees = append(b.forest.Trees, weakLearner)
 }
 
-func (b *boostingTreeGenerator) doBoostingRound(e Examples, round int) {
+func (b *boostingTreeGenerator) doBoostingRound(e Examples, round int) (pb.EpochResult, error) {
 	startTime := time.Now()
 	defer func() {
 		glog.Infof("Round %v, duration %v", round, time.Now().Sub(startTime))
 	}()
 
+	// The loss function evaluates the forest as of the start of the round
+	lossFunction, err := b.getLossFunction()
+	if err != nil {
+		return pb.EpochResult{}, err
+	}
+
 	if b.forestConfig.GetStochasticityConfig() != nil {
-		e = e.subsampleExamples(b.forestConfig.GetStochasticityConfig().GetPerRoundSamplingRate())
+		rng := rand.New(rand.NewSource(b.seed + int64(round)))
+		e = e.subsampleExamples(rng, b.forestConfig.GetStochasticityConfig().GetPerRoundSamplingRate())
 	}
 
 	// Trim the low-sample influencers
 	if b.forestConfig.GetInfluenceTrimmingConfig() != nil &&
 		b.forestConfig.GetInfluenceTrimmingConfig().GetWarmupRounds() < int64(round) {
-		e = b.doInfluenceTrimming(e)
+		e = b.doInfluenceTrimming(lossFunction, e)
 	}
 
-	b.updateExampleWeights(e)
-	b.constructWeakLearner(e)
+	b.constructWeakLearner(lossFunction, e, lossFunction.WeightedLabels(e))
 
-	metrics := b.computeTrainingMetrics(e)
+	metrics, err := b.computeTrainingMetrics(e)
+	if err != nil {
+		return pb.EpochResult{}, err
+	}
 	glog.Infof("Epoch: %v, Metrics: %+v", round, metrics)
+	return metrics, nil
 }
 
-func (b *boostingTreeGenerator) computeTrainingMetrics(e Examples) pb.EpochResult {
+func (b *boostingTreeGenerator) computeTrainingMetrics(e Examples) (pb.EpochResult, error) {
 	evaluator, err := NewRescaledFastForestEvaluator(b.forest)
 	if err != nil {
-		glog.Fatal(err)
+		return pb.EpochResult{}, err
 	}
 
-	return computeEpochResult(evaluator, e)
+	return computeEpochResult(evaluator, e), nil
 }
 
-func (b *boostingTreeGenerator) getLossFunction() LossFunction {
+func (b *boostingTreeGenerator) getLossFunction() (LossFunction, error) {
 	evaluator, err := newUnscaledFastForestEvaluator(b.forest)
 	if err != nil {
-		glog.Fatal(err)
+		return nil, err
 	}
 
 	return NewLossFunction(b.forestConfig.GetLossFunctionConfig(), evaluator)
@@ -102,24 +144,69 @@ func (b *boostingTreeGenerator) getRescaling() pb.Rescaling {
 	return pb.Rescaling_NONE
 }
 
-func (b *boostingTreeGenerator) initializeForest(e Examples) {
+func (b *boostingTreeGenerator) initializeForest(e Examples) error {
 	b.forest = &pb.Forest{
 		Trees:     make([]*pb.TreeNode, 0, b.forestConfig.GetNumWeakLearners()),
 		Rescaling: b.getRescaling().Enum(),
 	}
 
+	lossFunction, err := b.getLossFunction()
+	if err != nil {
+		return err
+	}
+
 	// Initial prior
 	b.forest.Trees = append(b.forest.Trees, &pb.TreeNode{
-		LeafValue: proto.Float64(b.getLossFunction().GetPrior(e)),
+		LeafValue: proto.Float64(lossFunction.GetPrior(e)),
 	})
+	return nil
 }
 
-func (b *boostingTreeGenerator) ConstructForest(e Examples) *pb.Forest {
+func (b *boostingTreeGenerator) ConstructForest(e Examples) (*pb.Forest, error) {
+	return b.ConstructForestWithContext(context.Background(), e, nil)
+}
+
+func (b *boostingTreeGenerator) ConstructForestWithContext(
+	ctx context.Context,
+	e Examples,
+	observer TrainingObserver) (*pb.Forest, error) {
+	if len(e) == 0 {
+		return nil, ErrNoExamples
+	}
+
 	glog.Infof("Initializing forest with config %+v", b.forestConfig)
-	b.initializeForest(e)
-	for i := 0; i < int(b.forestConfig.GetNumWeakLearners()); i++ {
+	if err := b.initializeForest(e); err != nil {
+		return nil, err
+	}
+	return b.runBoostingRounds(ctx, e, observer, 0)
+}
+
+// runBoostingRounds runs the boosting rounds from startRound onwards on
+// the initialized forest.  The examples are not modified, so every round
+// sees them in their original order.
+func (b *boostingTreeGenerator) runBoostingRounds(
+	ctx context.Context,
+	e Examples,
+	observer TrainingObserver,
+	startRound int) (*pb.Forest, error) {
+	for i := startRound; i < int(b.forestConfig.GetNumWeakLearners()); i++ {
+		if err := ctx.Err(); err != nil {
+			glog.Infof("Stopping after %v boosting rounds: %v", i, err)
+			return b.forest, err
+		}
 		glog.Infof("Running boosting round %v", i)
-		b.doBoostingRound(e, i)
+		metrics, err := b.doBoostingRound(e, i)
+		if err != nil {
+			return b.forest, &TrainingError{Round: i, Err: err}
+		}
+		if b.afterRound != nil {
+			if err := b.afterRound(i, e); err != nil {
+				return b.forest, &TrainingError{Round: i, Err: err}
+			}
+		}
+		if observer != nil {
+			observer.OnRound(i, snapshotForest(b.forest, b.forest.GetTrees()), metrics)
+		}
 	}
-	return b.forest
+	return b.forest, nil
 }
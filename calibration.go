@@ -0,0 +1,271 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"sort"
+)
+
+// scoredLabel is an uncalibrated prediction with its binary label
+type scoredLabel struct {
+	score float64
+	label bool
+}
+
+func validateCalibrator(c *pb.Calibrator) error {
+	if c == nil {
+		return forestErrorf("calibrated forest has no calibrator")
+	}
+	if c.GetBaseRescaling() == pb.Rescaling_CALIBRATED {
+		return forestErrorf("calibrator cannot have a calibrated base rescaling")
+	}
+
+	switch c.GetMethod() {
+	case pb.CalibrationMethod_PLATT:
+		return nil
+	case pb.CalibrationMethod_ISOTONIC:
+		thresholds, values := c.GetIsotonicThresholds(), c.GetIsotonicValues()
+		if len(thresholds) == 0 || len(thresholds) != len(values) {
+			return forestErrorf("isotonic calibrator has %v thresholds and %v values", len(thresholds), len(values))
+		}
+		if !sort.Float64sAreSorted(thresholds) {
+			return forestErrorf("isotonic calibrator thresholds are not sorted: %v", thresholds)
+		}
+		return nil
+	}
+	return forestErrorf("unknown calibration method: %v", c.GetMethod())
+}
+
+// calibrate maps an uncalibrated score to a probability.  The calibrator
+// must have been validated with validateCalibrator.
+func calibrate(c *pb.Calibrator, score float64) float64 {
+	if c.GetMethod() == pb.CalibrationMethod_PLATT {
+		return 1.0 / (1.0 + math.Exp(c.GetPlattA()*score+c.GetPlattB()))
+	}
+
+	thresholds, values := c.GetIsotonicThresholds(), c.GetIsotonicValues()
+	if score <= thresholds[0] {
+		return values[0]
+	}
+	if score >= thresholds[len(thresholds)-1] {
+		return values[len(values)-1]
+	}
+
+	// thresholds[i-1] < score <= thresholds[i]
+	i := sort.SearchFloat64s(thresholds, score)
+	fraction := (score - thresholds[i-1]) / (thresholds[i] - thresholds[i-1])
+	return values[i-1] + fraction*(values[i]-values[i-1])
+}
+
+// fitPlatt fits the parameters of a sigmoid to the scores with Newton's
+// method, following Lin, Lin and Weng, "A note on Platt's probabilistic
+// outputs for support vector machines"
+func fitPlatt(scores []scoredLabel) (a float64, b float64) {
+	const (
+		maxIterations = 100
+		minStep       = 1e-10
+		sigma         = 1e-12
+		epsilon       = 1e-5
+	)
+
+	numPositives, numNegatives := 0.0, 0.0
+	for _, s := range scores {
+		if s.label {
+			numPositives++
+		} else {
+			numNegatives++
+		}
+	}
+
+	// Regularized targets, to avoid overfitting on separable data
+	hiTarget := (numPositives + 1.0) / (numPositives + 2.0)
+	loTarget := 1.0 / (numNegatives + 2.0)
+	targets := make([]float64, len(scores))
+	for i, s := range scores {
+		if s.label {
+			targets[i] = hiTarget
+		} else {
+			targets[i] = loTarget
+		}
+	}
+
+	objective := func(a, b float64) float64 {
+		result := 0.0
+		for i, s := range scores {
+			fApB := s.score*a + b
+			if fApB >= 0 {
+				result += targets[i]*fApB + math.Log(1+math.Exp(-fApB))
+			} else {
+				result += (targets[i]-1)*fApB + math.Log(1+math.Exp(fApB))
+			}
+		}
+		return result
+	}
+
+	a, b = 0.0, math.Log((numNegatives+1.0)/(numPositives+1.0))
+	value := objective(a, b)
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		// Gradient and Hessian, with the Hessian regularized by sigma
+		h11, h22, h21, g1, g2 := sigma, sigma, 0.0, 0.0, 0.0
+		for i, s := range scores {
+			fApB := s.score*a + b
+			var p, q float64
+			if fApB >= 0 {
+				p = math.Exp(-fApB) / (1.0 + math.Exp(-fApB))
+				q = 1.0 / (1.0 + math.Exp(-fApB))
+			} else {
+				p = 1.0 / (1.0 + math.Exp(fApB))
+				q = math.Exp(fApB) / (1.0 + math.Exp(fApB))
+			}
+			d2 := p * q
+			h11 += s.score * s.score * d2
+			h22 += d2
+			h21 += s.score * d2
+			d1 := targets[i] - p
+			g1 += s.score * d1
+			g2 += d1
+		}
+
+		if math.Abs(g1) < epsilon && math.Abs(g2) < epsilon {
+			break
+		}
+
+		det := h11*h22 - h21*h21
+		dA := -(h22*g1 - h21*g2) / det
+		dB := -(-h21*g1 + h11*g2) / det
+		gd := g1*dA + g2*dB
+
+		// Backtracking line search
+		step := 1.0
+		for step >= minStep {
+			newA, newB := a+step*dA, b+step*dB
+			newValue := objective(newA, newB)
+			if newValue < value+0.0001*step*gd {
+				a, b, value = newA, newB, newValue
+				break
+			}
+			step /= 2.0
+		}
+
+		if step < minStep {
+			break
+		}
+	}
+	return a, b
+}
+
+// fitIsotonic fits a non-decreasing step function to the scores with the
+// pool adjacent violators algorithm, returning the interpolation points
+func fitIsotonic(scores []scoredLabel) (thresholds []float64, values []float64) {
+	sort.Sort(byScore(scores))
+
+	type block struct {
+		sum    float64
+		weight float64
+		min    float64
+		max    float64
+	}
+	mean := func(b block) float64 { return b.sum / b.weight }
+
+	// Equal scores must be assigned equal values, so pool them up front
+	points := make([]block, 0, len(scores))
+	for i, s := range scores {
+		y := 0.0
+		if s.label {
+			y = 1.0
+		}
+		if i > 0 && s.score == scores[i-1].score {
+			points[len(points)-1].sum += y
+			points[len(points)-1].weight++
+		} else {
+			points = append(points, block{sum: y, weight: 1, min: s.score, max: s.score})
+		}
+	}
+
+	blocks := make([]block, 0, len(points))
+	for _, p := range points {
+		blocks = append(blocks, p)
+		for len(blocks) > 1 && mean(blocks[len(blocks)-2]) >= mean(blocks[len(blocks)-1]) {
+			last := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			blocks[len(blocks)-1].sum += last.sum
+			blocks[len(blocks)-1].weight += last.weight
+			blocks[len(blocks)-1].max = last.max
+		}
+	}
+
+	for _, b := range blocks {
+		thresholds = append(thresholds, b.min)
+		values = append(values, mean(b))
+		if b.max > b.min {
+			thresholds = append(thresholds, b.max)
+			values = append(values, mean(b))
+		}
+	}
+	return thresholds, values
+}
+
+type byScore []scoredLabel
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool { return s[i].score < s[j].score }
+
+// CalibrateForest fits a calibrator on the predictions of the forest on
+// a held-out set of examples, and returns a copy of the forest with
+// CALIBRATED rescaling.  Calibrating an already calibrated forest
+// replaces its calibrator.
+func CalibrateForest(f *pb.Forest, heldOut Examples, method pb.CalibrationMethod) (*pb.Forest, error) {
+	baseRescaling := f.GetRescaling()
+	if baseRescaling == pb.Rescaling_CALIBRATED {
+		baseRescaling = f.GetCalibrator().GetBaseRescaling()
+	}
+
+	evaluator, err := NewRescaledFastForestEvaluator(&pb.Forest{
+		Trees:     f.GetTrees(),
+		Rescaling: baseRescaling.Enum(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]scoredLabel, 0, len(heldOut))
+	numPositives := 0
+	for _, ex := range heldOut {
+		s := scoredLabel{
+			score: evaluator.Evaluate(ex.GetFeatures()),
+			label: ex.GetLabel() > 0,
+		}
+		if s.label {
+			numPositives++
+		}
+		scores = append(scores, s)
+	}
+	if numPositives == 0 || numPositives == len(scores) {
+		return nil, fmt.Errorf("calibration requires both positive and negative examples, got %v of %v positive",
+			numPositives, len(scores))
+	}
+
+	calibrator := &pb.Calibrator{
+		Method:        method.Enum(),
+		BaseRescaling: baseRescaling.Enum(),
+	}
+	switch method {
+	case pb.CalibrationMethod_PLATT:
+		a, b := fitPlatt(scores)
+		calibrator.PlattA = proto.Float64(a)
+		calibrator.PlattB = proto.Float64(b)
+	case pb.CalibrationMethod_ISOTONIC:
+		calibrator.IsotonicThresholds, calibrator.IsotonicValues = fitIsotonic(scores)
+	default:
+		return nil, fmt.Errorf("unknown calibration method: %v", method)
+	}
+
+	return &pb.Forest{
+		Trees:      f.GetTrees(),
+		Rescaling:  pb.Rescaling_CALIBRATED.Enum(),
+		Calibrator: calibrator,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestFitPlatt(t *testing.T) {
+	// Labels drawn from a known sigmoid of the score
+	trueA, trueB := -3.0, 0.5
+	scores := make([]scoredLabel, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		score := rand.NormFloat64()
+		p := 1.0 / (1.0 + math.Exp(trueA*score+trueB))
+		scores = append(scores, scoredLabel{score: score, label: rand.Float64() < p})
+	}
+
+	a, b := fitPlatt(scores)
+	if math.Abs(a-trueA) > 0.2 || math.Abs(b-trueB) > 0.1 {
+		t.Fatalf("Expected (%v, %v), got (%v, %v)", trueA, trueB, a, b)
+	}
+}
+
+func TestFitIsotonic(t *testing.T) {
+	scores := make([]scoredLabel, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		score := rand.Float64()
+		// True probability is score^2, so the raw score is miscalibrated
+		scores = append(scores, scoredLabel{score: score, label: rand.Float64() < score*score})
+	}
+
+	thresholds, values := fitIsotonic(scores)
+	if !sort.Float64sAreSorted(thresholds) || !sort.Float64sAreSorted(values) {
+		t.Fatalf("Expected non-decreasing fit, got %v, %v", thresholds, values)
+	}
+
+	c := &pb.Calibrator{
+		Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+		IsotonicThresholds: thresholds,
+		IsotonicValues:     values,
+	}
+	if err := validateCalibrator(c); err != nil {
+		t.Fatal(err)
+	}
+	for _, score := range []float64{0.2, 0.5, 0.8} {
+		if math.Abs(calibrate(c, score)-score*score) > 0.1 {
+			t.Errorf("Score %v: expected %v, got %v", score, score*score, calibrate(c, score))
+		}
+	}
+	if calibrate(c, -1) != values[0] || calibrate(c, 2) != values[len(values)-1] {
+		t.Error("Expected calibration to be clamped outside the thresholds")
+	}
+}
+
+func TestCalibrateForest(t *testing.T) {
+	// A single stump averaging forest that is badly miscalibrated - it
+	// predicts 0.9 and 0.1, whereas the true rates are 0.6 and 0.3
+	forest := &pb.Forest{
+		Trees: []*pb.TreeNode{
+			{
+				Feature:    proto.Int64(0),
+				SplitValue: proto.Float64(0.5),
+				Left:       &pb.TreeNode{LeafValue: proto.Float64(0.1)},
+				Right:      &pb.TreeNode{LeafValue: proto.Float64(0.9)},
+			},
+		},
+		Rescaling: pb.Rescaling_AVERAGING.Enum(),
+	}
+
+	heldOut := make([]*pb.Example, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		feature := rand.Float64()
+		rate := 0.3
+		if feature >= 0.5 {
+			rate = 0.6
+		}
+		label := -1.0
+		if rand.Float64() < rate {
+			label = 1.0
+		}
+		heldOut = append(heldOut, &pb.Example{
+			Features: []float64{feature},
+			Label:    proto.Float64(label),
+		})
+	}
+
+	for _, method := range []pb.CalibrationMethod{pb.CalibrationMethod_PLATT, pb.CalibrationMethod_ISOTONIC} {
+		calibrated, err := CalibrateForest(forest, heldOut, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calibrated.GetCalibrator().GetBaseRescaling() != pb.Rescaling_AVERAGING {
+			t.Fatalf("Expected AVERAGING base rescaling, got %v", calibrated.GetCalibrator().GetBaseRescaling())
+		}
+
+		evaluator, err := NewRescaledFastForestEvaluator(calibrated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		low, high := evaluator.Evaluate([]float64{0.25}), evaluator.Evaluate([]float64{0.75})
+		if math.Abs(low-0.3) > 0.03 || math.Abs(high-0.6) > 0.03 {
+			t.Errorf("%v: expected (0.3, 0.6), got (%v, %v)", method, low, high)
+		}
+
+		er := computeEpochResult(evaluator, heldOut)
+		if math.Abs(er.GetCalibration()-1.0) > 0.05 {
+			t.Errorf("%v: expected calibration of 1.0, got %v", method, er.GetCalibration())
+		}
+	}
+}
+
+func TestCalibratedForestRequiresCalibrator(t *testing.T) {
+	forest := &pb.Forest{
+		Trees:     []*pb.TreeNode{{LeafValue: proto.Float64(1.0)}},
+		Rescaling: pb.Rescaling_CALIBRATED.Enum(),
+	}
+	if _, err := NewRescaledFastForestEvaluator(forest); err == nil {
+		t.Fatal("Expected an error for a calibrated forest without a calibrator")
+	}
+}
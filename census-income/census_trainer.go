@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	dt "github.com/ajtulloch/decisiontrees"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"io/ioutil"
 	"log"
@@ -36,6 +37,9 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to load config")
 	}
+	if err := dt.ValidateForestConfig(forestConfig, nil); err != nil {
+		log.Fatal(err)
+	}
 
 	log.Println(forestConfig.String())
 }
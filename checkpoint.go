@@ -0,0 +1,246 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"context"
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	checkpointPrefix = "checkpoint-"
+	checkpointSuffix = ".pb"
+	// numCheckpointsKept is the number of most recent checkpoints left in
+	// the directory after writing a new one
+	numCheckpointsKept = 2
+)
+
+// CheckpointConfig configures periodic checkpoints of a boosting run
+type CheckpointConfig struct {
+	// Directory holds the checkpoint files
+	Directory string
+	// Interval is the number of boosting rounds between checkpoints.  A
+	// checkpoint is always written after the final round.
+	Interval int
+	// Resume continues from the latest checkpoint in Directory, if there
+	// is one
+	Resume bool
+}
+
+func checkpointPath(dir string, round int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%v%010d%v", checkpointPrefix, round, checkpointSuffix))
+}
+
+// checkpointFiles returns the checkpoint files in the directory, oldest
+// first
+func checkpointFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, checkpointPrefix) && strings.HasSuffix(name, checkpointSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	// The round is zero-padded, so lexicographic order is round order
+	sort.Strings(files)
+	return files, nil
+}
+
+// writeCheckpoint atomically writes the checkpoint to the directory, and
+// removes all but the most recent checkpoints
+func writeCheckpoint(dir string, c *pb.TrainingCheckpoint) error {
+	serialized, err := proto.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "tmp-"+checkpointPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(serialized); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), checkpointPath(dir, c.GetRound())); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	glog.Infof("Wrote checkpoint after %v rounds to %v", c.GetRound(), dir)
+
+	files, err := checkpointFiles(dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files)-numCheckpointsKept; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatestCheckpoint returns the checkpoint with the most completed rounds
+// in the directory, or nil if there are none
+func LatestCheckpoint(dir string) (*pb.TrainingCheckpoint, error) {
+	files, err := checkpointFiles(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	serialized, err := ioutil.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil, err
+	}
+	c := &pb.TrainingCheckpoint{}
+	if err := proto.Unmarshal(serialized, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// trainingMargins returns the sum of the trees of the forest on each of
+// the examples
+func trainingMargins(f *pb.Forest, e Examples) ([]float64, error) {
+	evaluator, err := newUnscaledFastForestEvaluator(f)
+	if err != nil {
+		return nil, err
+	}
+	margins := make([]float64, len(e))
+	evaluator.EvaluateBatch(e.featureRows(), margins)
+	return margins, nil
+}
+
+// sameTrainingConfig returns whether the configs train the same forest,
+// ignoring the number of threads
+func sameTrainingConfig(c1, c2 *pb.ForestConfig) bool {
+	c1 = proto.Clone(c1).(*pb.ForestConfig)
+	c2 = proto.Clone(c2).(*pb.ForestConfig)
+	c1.NumThreads, c2.NumThreads = nil, nil
+	return proto.Equal(c1, c2)
+}
+
+// resumeFromCheckpoint restores the generator to the state in the
+// checkpoint, checking that the checkpoint was taken training the same
+// config on the same examples
+func (b *boostingTreeGenerator) resumeFromCheckpoint(c *pb.TrainingCheckpoint, e Examples) error {
+	if !sameTrainingConfig(c.GetForestConfig(), b.forestConfig) {
+		return fmt.Errorf("checkpoint config %v differs from %v", c.GetForestConfig(), b.forestConfig)
+	}
+
+	forest := proto.Clone(c.GetForest()).(*pb.Forest)
+	margins, err := trainingMargins(forest, e)
+	if err != nil {
+		return err
+	}
+	predictions := c.GetPredictions()
+	if len(predictions) != len(margins) {
+		return fmt.Errorf("checkpoint has predictions for %v examples, training on %v", len(predictions), len(margins))
+	}
+	for i := range margins {
+		if math.Abs(margins[i]-predictions[i]) > 1e-9*math.Max(1, math.Abs(predictions[i])) {
+			return fmt.Errorf("example %v has prediction %v, checkpoint has %v - the training examples differ",
+				i, margins[i], predictions[i])
+		}
+	}
+
+	b.forest = forest
+	b.seed = c.GetSeed()
+	return nil
+}
+
+// ConstructForestWithCheckpoints constructs a boosted forest, writing a
+// checkpoint to c.Directory every c.Interval rounds.  If c.Resume is set,
+// training continues from the latest checkpoint in the directory, and the
+// result is the same as that of an uninterrupted run.  The examples must
+// be in the same order as in the interrupted run.
+func ConstructForestWithCheckpoints(
+	ctx context.Context,
+	config *pb.ForestConfig,
+	e Examples,
+	c CheckpointConfig,
+	observer TrainingObserver) (*pb.Forest, error) {
+	if config.GetAlgorithm() != pb.Algorithm_BOOSTING {
+		return nil, configErrorf("algorithm", "checkpoints are only supported for boosting, got %v", config.GetAlgorithm())
+	}
+	if c.Interval <= 0 {
+		return nil, fmt.Errorf("checkpoint interval must be positive, got %v", c.Interval)
+	}
+	if len(e) == 0 {
+		return nil, ErrNoExamples
+	}
+
+	b := newBoostingTreeGenerator(config)
+	startRound := 0
+	var checkpoint *pb.TrainingCheckpoint
+	if c.Resume {
+		var err error
+		checkpoint, err = LatestCheckpoint(c.Directory)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if checkpoint != nil {
+		if err := b.resumeFromCheckpoint(checkpoint, e); err != nil {
+			return nil, err
+		}
+		startRound = int(checkpoint.GetRound())
+		glog.Infof("Resuming from checkpoint after %v rounds", startRound)
+	} else {
+		if err := os.MkdirAll(c.Directory, 0755); err != nil {
+			return nil, err
+		}
+		glog.Infof("Initializing forest with config %+v", config)
+		if err := b.initializeForest(e); err != nil {
+			return nil, err
+		}
+	}
+
+	numRounds := int(config.GetNumWeakLearners())
+	b.afterRound = func(round int, e Examples) error {
+		completed := round + 1
+		if completed%c.Interval != 0 && completed != numRounds {
+			return nil
+		}
+		margins, err := trainingMargins(b.forest, e)
+		if err != nil {
+			return err
+		}
+		return writeCheckpoint(c.Directory, &pb.TrainingCheckpoint{
+			ForestConfig: config,
+			Forest:       b.forest,
+			Round:        proto.Int64(int64(completed)),
+			Seed:         proto.Int64(b.seed),
+			Predictions:  margins,
+		})
+	}
+	return b.runBoostingRounds(ctx, e, observer, startRound)
+}
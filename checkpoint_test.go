@@ -0,0 +1,111 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"context"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func checkpointTestConfig() *pb.ForestConfig {
+	return &pb.ForestConfig{
+		NumWeakLearners: proto.Int64(10),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(3),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		StochasticityConfig: &pb.StochasticityConfig{
+			PerRoundSamplingRate: proto.Float64(0.7),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+		Seed:      proto.Int64(42),
+	}
+}
+
+func TestResumeMatchesUninterruptedRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	examples := constructBenchmarkExamples(300, 4, 0)
+	generator, err := NewForestGenerator(checkpointTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := generator.ConstructForest(examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupt the run after five rounds, with the last checkpoint after
+	// three
+	c := CheckpointConfig{Directory: dir, Interval: 3, Resume: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = ConstructForestWithCheckpoints(
+		ctx,
+		checkpointTestConfig(),
+		examples,
+		c,
+		TrainingObserverFunc(func(round int, f *pb.Forest, metrics pb.EpochResult) {
+			if round == 4 {
+				cancel()
+			}
+		}))
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+	checkpoint, err := LatestCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.GetRound() != 3 {
+		t.Fatalf("Expected a checkpoint after 3 rounds, got %v", checkpoint.GetRound())
+	}
+
+	resumed, err := ConstructForestWithCheckpoints(
+		context.Background(), checkpointTestConfig(), examples, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(expected, resumed) {
+		t.Fatalf("Resumed forest %v differs from %v", resumed, expected)
+	}
+
+	files, err := checkpointFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != numCheckpointsKept {
+		t.Fatalf("Expected %v checkpoints to be kept, got %v", numCheckpointsKept, files)
+	}
+	if checkpoint, _ := LatestCheckpoint(dir); checkpoint.GetRound() != 10 {
+		t.Fatalf("Expected a final checkpoint after 10 rounds, got %v", checkpoint.GetRound())
+	}
+}
+
+func TestResumeRejectsDifferentExamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := CheckpointConfig{Directory: dir, Interval: 5, Resume: true}
+	_, err = ConstructForestWithCheckpoints(
+		context.Background(), checkpointTestConfig(), constructBenchmarkExamples(100, 4, 0), c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ConstructForestWithCheckpoints(
+		context.Background(), checkpointTestConfig(), constructBenchmarkExamples(100, 4, 0), c, nil)
+	if err == nil {
+		t.Fatal("Expected an error resuming with different examples")
+	}
+}
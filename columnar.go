@@ -0,0 +1,179 @@
+package decisiontrees
+
+import (
+	"sort"
+)
+
+// nodeRange is the range of positions in every sorted column held by a
+// node of the tree being built
+type nodeRange struct {
+	begin int
+	end   int
+}
+
+func (r nodeRange) len() int {
+	return r.end - r.begin
+}
+
+// columnarExamples holds training examples by feature, with the rows of
+// every feature presorted by value.  Each node of the tree being built
+// owns the same range of every sorted column, and splitting a node stably
+// partitions its range, so that the examples are sorted once per tree
+// rather than once per feature at every node, as in SLIQ and SPRINT.
+type columnarExamples struct {
+	examples Examples
+	// columns holds the feature values, indexed by feature then row
+	columns [][]float64
+	// targets holds the weighted label of each row, and is not modified
+	targets []float64
+	// sorted holds the rows sorted by the value of each feature
+	sorted [][]int32
+	// rows holds the rows of each node, in no particular order
+	rows []int32
+}
+
+// newColumnarExamples builds the columns and sorts them, with the sorts
+// run on the pool.  The examples are not modified.
+func newColumnarExamples(e Examples, weightedLabels []float64, pool *workerPool) *columnarExamples {
+	c := &columnarExamples{
+		examples: e,
+		targets:  weightedLabels,
+		rows:     make([]int32, len(e)),
+	}
+	numFeatures := 0
+	if len(e) > 0 {
+		numFeatures = len(e[0].GetFeatures())
+	}
+	c.columns = make([][]float64, numFeatures)
+	c.sorted = make([][]int32, numFeatures)
+	for f := range c.columns {
+		c.columns[f] = make([]float64, len(e))
+	}
+	for i, ex := range e {
+		c.rows[i] = int32(i)
+		for f, value := range ex.GetFeatures() {
+			c.columns[f][i] = value
+		}
+	}
+
+	g := pool.group()
+	for f := range c.sorted {
+		f := f
+		g.run(func() {
+			column := c.columns[f]
+			sorted := make([]int32, len(e))
+			copy(sorted, c.rows)
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return column[sorted[i]] < column[sorted[j]]
+			})
+			c.sorted[f] = sorted
+		})
+	}
+	g.wait()
+	return c
+}
+
+func (c *columnarExamples) root() nodeRange {
+	return nodeRange{begin: 0, end: len(c.rows)}
+}
+
+// nodeFeatures returns the features with a non-zero value in the node
+func (c *columnarExamples) nodeFeatures(r nodeRange) []int {
+	result := make([]int, 0)
+	if r.len() == 0 {
+		return result
+	}
+	for f, sorted := range c.sorted {
+		column := c.columns[f]
+		if column[sorted[r.begin]] != 0 || column[sorted[r.end-1]] != 0 {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// nodeExamples returns the examples in the node
+func (c *columnarExamples) nodeExamples(r nodeRange) Examples {
+	result := make(Examples, 0, r.len())
+	for _, row := range c.rows[r.begin:r.end] {
+		result = append(result, c.examples[row])
+	}
+	return result
+}
+
+// bestSplit returns the split of the node on the feature with the largest
+// reduction in squared divergence of the targets, where the index is the
+// number of examples sent to the left
+func (c *columnarExamples) bestSplit(r nodeRange, feature int) split {
+	sorted := c.sorted[feature][r.begin:r.end]
+	column := c.columns[feature]
+
+	leftLoss := &lossState{}
+	rightLoss := &lossState{}
+	for _, row := range sorted {
+		rightLoss.add(c.targets[row])
+	}
+	totalSumSquaredDivergence := rightLoss.sumSquaredDivergence
+
+	bestSplit := split{feature: feature}
+	for index, row := range sorted {
+		if index > 0 && column[sorted[index-1]] != column[row] {
+			gain := totalSumSquaredDivergence -
+				leftLoss.sumSquaredDivergence -
+				rightLoss.sumSquaredDivergence
+			if gain > bestSplit.gain {
+				bestSplit.gain = gain
+				bestSplit.index = index
+			}
+		}
+		leftLoss.add(c.targets[row])
+		rightLoss.remove(c.targets[row])
+	}
+	return bestSplit
+}
+
+// splitValue returns the value halfway between the last example sent
+// left by the split and the first sent right
+func (c *columnarExamples) splitValue(r nodeRange, s split) float64 {
+	sorted := c.sorted[s.feature][r.begin:r.end]
+	column := c.columns[s.feature]
+	return 0.5 * (column[sorted[s.index-1]] + column[sorted[s.index]])
+}
+
+// partition stably moves the rows of the node with feature below value to
+// the start of its range in every column, returning the ranges of the
+// left and right children.  The columns are partitioned on the pool.
+func (c *columnarExamples) partition(r nodeRange, feature int, value float64, pool *workerPool) (nodeRange, nodeRange) {
+	column := c.columns[feature]
+	goesLeft := func(row int32) bool {
+		return column[row] < value
+	}
+
+	stablePartition := func(rows []int32) int {
+		right := make([]int32, 0, len(rows))
+		numLeft := 0
+		for _, row := range rows {
+			if goesLeft(row) {
+				rows[numLeft] = row
+				numLeft++
+			} else {
+				right = append(right, row)
+			}
+		}
+		copy(rows[numLeft:], right)
+		return numLeft
+	}
+
+	numLeft := stablePartition(c.rows[r.begin:r.end])
+	g := pool.group()
+	for f := range c.sorted {
+		f := f
+		g.run(func() {
+			stablePartition(c.sorted[f][r.begin:r.end])
+		})
+	}
+	g.wait()
+
+	return nodeRange{begin: r.begin, end: r.begin + numLeft},
+		nodeRange{begin: r.begin + numLeft, end: r.end}
+}
@@ -0,0 +1,108 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// constructWeightedExamples returns examples with integer-valued features,
+// so that there are ties, and weighted labels correlated with the first
+// feature
+func constructWeightedExamples(numExamples int, numFeatures int) Examples {
+	rng := rand.New(rand.NewSource(3))
+	result := make([]*pb.Example, 0, numExamples)
+	for i := 0; i < numExamples; i++ {
+		features := make([]float64, numFeatures)
+		for f := range features {
+			features[f] = float64(rng.Intn(10))
+		}
+		result = append(result, &pb.Example{
+			Features:      features,
+			Label:         proto.Float64(1),
+			WeightedLabel: proto.Float64(features[0] + rng.NormFloat64()),
+		})
+	}
+	return result
+}
+
+func TestPresortedBestSplitMatchesSorting(t *testing.T) {
+	examples := constructWeightedExamples(500, 4)
+	data := newColumnarExamples(examples, weightedLabels(examples), newWorkerPool(2))
+	for f := 0; f < 4; f++ {
+		expected := getBestSplit(examples, weightedLabels(examples), f)
+		actual := data.bestSplit(data.root(), f)
+		if actual.index != expected.index || math.Abs(actual.gain-expected.gain) > 1e-6*expected.gain {
+			t.Errorf("Feature %v: expected split %+v, got %+v", f, expected, actual)
+		}
+	}
+}
+
+func TestPartitionKeepsColumnsSorted(t *testing.T) {
+	examples := constructWeightedExamples(300, 3)
+	data := newColumnarExamples(examples, weightedLabels(examples), newWorkerPool(2))
+	left, right := data.partition(data.root(), 1, 4.5, newWorkerPool(2))
+	if left.len()+right.len() != len(examples) {
+		t.Fatalf("Expected %v examples, got %v and %v", len(examples), left.len(), right.len())
+	}
+
+	for _, r := range []nodeRange{left, right} {
+		for f, sorted := range data.sorted {
+			for i := r.begin; i < r.end; i++ {
+				row := sorted[i]
+				if (examples[row].Features[1] < 4.5) != (r == left) {
+					t.Fatalf("Row %v is on the wrong side of the split", row)
+				}
+				if i > r.begin {
+					previous := sorted[i-1]
+					value, previousValue := data.columns[f][row], data.columns[f][previous]
+					// Rows with equal values keep their original order
+					if previousValue > value || (previousValue == value && previous > row) {
+						t.Fatalf("Feature %v is not stably sorted at %v", f, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRegressionSplitterMatchesEvaluation(t *testing.T) {
+	examples := constructWeightedExamples(200, 2)
+	for _, ex := range examples {
+		ex.WeightedLabel = proto.Float64(-1)
+		if ex.Features[0] >= 5 {
+			ex.WeightedLabel = proto.Float64(1)
+		}
+	}
+	rs := &regressionSplitter{
+		leafWeight:      averageWeightedLabel,
+		featureSelector: naiveFeatureSelector{},
+		splittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(0),
+		},
+	}
+	tree := rs.GenerateTree(examples, weightedLabels(examples))
+	if tree.GetFeature() != 0 || tree.GetSplitValue() != 4.5 {
+		t.Fatalf("Expected a split on feature 0 at 4.5, got %+v", tree)
+	}
+
+	evaluator, err := NewRescaledFastForestEvaluator(&pb.Forest{Trees: []*pb.TreeNode{tree}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ex := range examples {
+		if prediction := evaluator.Evaluate(ex.Features); prediction != ex.GetWeightedLabel() {
+			t.Fatalf("Expected %v for %v, got %v", ex.GetWeightedLabel(), ex.Features, prediction)
+		}
+	}
+}
+
+func averageWeightedLabel(e Examples) float64 {
+	result := 0.0
+	for _, ex := range e {
+		result += ex.GetWeightedLabel()
+	}
+	return result / float64(len(e))
+}
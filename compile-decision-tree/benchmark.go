@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// benchmarkHarness evaluates the forest on random rows with
+// forest_evaluate_n, printing the time per row and the sum of the
+// predictions
+const benchmarkHarness = `#define _POSIX_C_SOURCE 199309L
+#include <stdio.h>
+#include <stdlib.h>
+#include <time.h>
+#include "forest.h"
+
+int main(int argc, char** argv) {
+  size_t n = (size_t)atol(argv[1]);
+  size_t stride = forest_num_features() > 0 ? forest_num_features() : 1;
+  double* rows = malloc(n * stride * sizeof(double));
+  double* out = malloc(n * sizeof(double));
+  unsigned long state = 42;
+  double sum = 0.0;
+  struct timespec start, end;
+  size_t i;
+  (void)argc;
+  for (i = 0; i < n * stride; i++) {
+    state = state * 6364136223846793005UL + 1442695040888963407UL;
+    rows[i] = (double)(state >> 11) / 9007199254740992.0;
+  }
+  /* Warm up */
+  forest_evaluate_n(rows, n < 1000 ? n : 1000, stride, out);
+  clock_gettime(CLOCK_MONOTONIC, &start);
+  forest_evaluate_n(rows, n, stride, out);
+  clock_gettime(CLOCK_MONOTONIC, &end);
+  for (i = 0; i < n; i++) {
+    sum += out[i];
+  }
+  printf("%.6f %.17g\n",
+         ((end.tv_sec - start.tv_sec) * 1e9 + (end.tv_nsec - start.tv_nsec)) / (double)n,
+         sum);
+  free(rows);
+  free(out);
+  return 0;
+}
+`
+
+type layoutBenchmark struct {
+	layout      treeLayout
+	nanosPerRow float64
+	// checksum is the sum of the predictions, which is the same for every
+	// layout
+	checksum string
+}
+
+// timeLayouts builds the forest with the C backend in each layout, and
+// times forest_evaluate_n on the same uniformly random rows in [0, 1)
+func timeLayouts(f *pb.Forest, numRows int) ([]layoutBenchmark, error) {
+	dir, err := ioutil.TempDir("", "codegen_benchmark")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	result := make([]layoutBenchmark, 0)
+	for _, layout := range []treeLayout{branchLayout, arrayLayout} {
+		layoutDir := filepath.Join(dir, layout.String())
+		if err := os.Mkdir(layoutDir, 0755); err != nil {
+			return nil, err
+		}
+		g := cCodeGenerator{codeGenerator: codeGenerator{forest: f, layout: layout}, name: "forest"}
+		if err := g.write(layoutDir); err != nil {
+			return nil, err
+		}
+		harness := filepath.Join(layoutDir, "harness.c")
+		if err := ioutil.WriteFile(harness, []byte(benchmarkHarness), 0644); err != nil {
+			return nil, err
+		}
+
+		binary := filepath.Join(layoutDir, "harness")
+		cmd := exec.Command(*cCompilerPath, "-std=c99", "-O3",
+			harness, filepath.Join(layoutDir, "forest.c"), "-o", binary, "-lm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%v failed: %v: %s", *cCompilerPath, err, output)
+		}
+		output, err := exec.Command(binary, fmt.Sprintf("%v", numRows)).Output()
+		if err != nil {
+			return nil, err
+		}
+
+		b := layoutBenchmark{layout: layout}
+		if _, err := fmt.Sscan(strings.TrimSpace(string(output)), &b.nanosPerRow, &b.checksum); err != nil {
+			return nil, fmt.Errorf("unexpected benchmark output %q: %v", output, err)
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	"hash/fnv"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// cCodegenVersion is the version of the generated C interface
+const cCodegenVersion = 1
+
+// cCodeGenerator emits a self-contained C99 header and source pair
+// evaluating the forest, which builds with any C compiler
+type cCodeGenerator struct {
+	codeGenerator
+	// name is the base name of the header and source files
+	name string
+}
+
+// version returns the version of the generated interface and a
+// fingerprint of the forest
+func (c *cCodeGenerator) version() (string, error) {
+	serialized, err := proto.Marshal(c.forest)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(serialized)
+	return fmt.Sprintf("%v-%016x", cCodegenVersion, h.Sum64()), nil
+}
+
+// cIdentifier replaces the characters of s that cannot appear in a C
+// identifier with underscores
+func cIdentifier(s string) string {
+	result := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+	if result == "" || (result[0] >= '0' && result[0] <= '9') {
+		return "_" + result
+	}
+	return result
+}
+
+func (c *cCodeGenerator) includeGuard() string {
+	return strings.ToUpper(cIdentifier(c.name)) + "_H"
+}
+
+// symbol returns the exported name of the function, prefixed by the name
+// of the generator so that several forests can be linked together
+func (c *cCodeGenerator) symbol(function string) string {
+	return cIdentifier(c.name) + "_" + function
+}
+
+func (c *cCodeGenerator) header() string {
+	cw := &codeWriter{}
+	cw.WriteString("/* Code generated by compile-decision-tree. DO NOT EDIT. */\n")
+	cw.WriteString(fmt.Sprintf("#ifndef %v\n", c.includeGuard()))
+	cw.WriteString(fmt.Sprintf("#define %v\n\n", c.includeGuard()))
+	cw.WriteString("#include <stddef.h>\n\n")
+	cw.WriteString("#ifdef __cplusplus\n")
+	cw.WriteString(`extern "C" {`)
+	cw.WriteString("\n#endif\n\n")
+	cw.WriteString("/* The version of the generated interface and a fingerprint of the forest */\n")
+	cw.WriteString(fmt.Sprintf("const char* %v(void);\n\n", c.symbol("version")))
+	cw.WriteString("/* The length of the feature vectors the forest expects */\n")
+	cw.WriteString(fmt.Sprintf("size_t %v(void);\n\n", c.symbol("num_features")))
+	cw.WriteString("/* The sum of the trees of the forest */\n")
+	cw.WriteString(fmt.Sprintf("double %v(const double* f);\n\n", c.symbol("evaluate_margin")))
+	cw.WriteString("/* The rescaled prediction of the forest */\n")
+	cw.WriteString(fmt.Sprintf("double %v(const double* f);\n\n", c.symbol("evaluate")))
+	cw.WriteString("/* Writes the prediction of the n rows, each stride doubles after the\n")
+	cw.WriteString(" * last, to out */\n")
+	cw.WriteString(fmt.Sprintf("void %v(const double* rows, size_t n, size_t stride, double* out);\n\n", c.symbol("evaluate_n")))
+	cw.WriteString("#ifdef __cplusplus\n")
+	cw.WriteString("}\n")
+	cw.WriteString("#endif\n\n")
+	cw.WriteString(fmt.Sprintf("#endif /* %v */\n", c.includeGuard()))
+	return cw.b.String()
+}
+
+func (c *cCodeGenerator) source() (string, error) {
+	version, err := c.version()
+	if err != nil {
+		return "", err
+	}
+	numFeatures, err := dt.NumFeatures(c.forest)
+	if err != nil {
+		return "", err
+	}
+
+	cw := &codeWriter{}
+	cw.WriteString("/* Code generated by compile-decision-tree. DO NOT EDIT. */\n")
+	cw.WriteString(fmt.Sprintf("#include \"%v.h\"\n\n", c.name))
+	if c.needsMath() {
+		cw.WriteString("#include <math.h>\n\n")
+	}
+	cw.WriteString("#if defined(__GNUC__)\n")
+	cw.WriteString("#define LIKELY(x)   (__builtin_expect((x), 1))\n")
+	cw.WriteString("#define UNLIKELY(x) (__builtin_expect((x), 0))\n")
+	cw.WriteString("#else\n")
+	cw.WriteString("#define LIKELY(x)   (x)\n")
+	cw.WriteString("#define UNLIKELY(x) (x)\n")
+	cw.WriteString("#endif\n\n")
+
+	cw.WriteString(fmt.Sprintf("const char* %v(void) {\n", c.symbol("version")))
+	cw.WriteString(fmt.Sprintf("  return %q;\n", version))
+	cw.WriteString("}\n\n")
+	cw.WriteString(fmt.Sprintf("size_t %v(void) {\n", c.symbol("num_features")))
+	cw.WriteString(fmt.Sprintf("  return %v;\n", numFeatures))
+	cw.WriteString("}\n\n")
+
+	c.generateRescaling(cw)
+	cw.WriteString("\n")
+
+	for i, t := range c.forest.GetTrees() {
+		cw.WriteString(fmt.Sprintf("static double evaluate_tree_%v(const double* f) {\n", i))
+		cw.indentLevel++
+		c.printTree(t, cw)
+		cw.indentLevel--
+		cw.WriteString("}\n\n")
+	}
+
+	cw.WriteString(fmt.Sprintf("double %v(const double* f) {\n", c.symbol("evaluate_margin")))
+	cw.indentLevel++
+	cw.WriteString("double result = 0.0;\n")
+	for i := range c.forest.GetTrees() {
+		cw.WriteString(fmt.Sprintf("result += evaluate_tree_%v(f);\n", i))
+	}
+	if len(c.forest.GetTrees()) == 0 {
+		cw.WriteString("(void)f;\n")
+	}
+	cw.WriteString("return result;\n")
+	cw.indentLevel--
+	cw.WriteString("}\n\n")
+
+	cw.WriteString(fmt.Sprintf("double %v(const double* f) {\n", c.symbol("evaluate")))
+	cw.WriteString(fmt.Sprintf("  return rescale(%v(f));\n", c.symbol("evaluate_margin")))
+	cw.WriteString("}\n\n")
+
+	cw.WriteString(fmt.Sprintf("void %v(const double* rows, size_t n, size_t stride, double* out) {\n", c.symbol("evaluate_n")))
+	cw.WriteString("  size_t i;\n")
+	cw.WriteString("  for (i = 0; i < n; i++) {\n")
+	cw.WriteString(fmt.Sprintf("    out[i] = %v(rows + i * stride);\n", c.symbol("evaluate")))
+	cw.WriteString("  }\n")
+	cw.WriteString("}\n")
+	return cw.b.String(), nil
+}
+
+// write writes the header and source to name.h and name.c in dir
+func (c *cCodeGenerator) write(dir string) error {
+	source, err := c.source()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, c.name+".h"), []byte(c.header()), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, c.name+".c"), []byte(source), 0644)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cDemo evaluates the rows on stdin with forest_evaluate_n, printing the
+// version, number of features and each prediction on its own line
+const cDemo = `#include <stdio.h>
+#include <stdlib.h>
+#include "forest.h"
+
+int main(int argc, char** argv) {
+  size_t n = (size_t)atoi(argv[1]);
+  size_t stride = (size_t)atoi(argv[2]);
+  double* rows = malloc(n * stride * sizeof(double));
+  double* out = malloc(n * sizeof(double));
+  size_t i;
+  (void)argc;
+  for (i = 0; i < n * stride; i++) {
+    if (scanf("%lf", &rows[i]) != 1) {
+      return 1;
+    }
+  }
+  forest_evaluate_n(rows, n, stride, out);
+  printf("%s\n%lu\n", forest_version(), (unsigned long)forest_num_features());
+  for (i = 0; i < n; i++) {
+    printf("%.17g\n", out[i]);
+  }
+  free(rows);
+  free(out);
+  return 0;
+}
+`
+
+func TestGeneratedCMatchesEvaluator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not on the PATH")
+	}
+
+	forests := goTestForests()
+	// Trees deeper than maxArrayDepth fall back to branches with the array
+	// layout
+	deep := makeAnnotatedForest(1, maxArrayDepth+1, 5)
+	forests = append(forests, deep)
+	for _, forest := range forests {
+		for _, layout := range []treeLayout{branchLayout, arrayLayout} {
+			checkGeneratedC(t, compiler, forest, layout)
+		}
+	}
+
+	// Infinite split values are written with math.h, which an unrescaled
+	// forest does not otherwise include
+	for _, layout := range []treeLayout{branchLayout, arrayLayout} {
+		checkGeneratedC(t, compiler, infiniteSplitForest(), layout)
+	}
+}
+
+// infiniteSplitForest returns an unrescaled forest splitting on +Inf and
+// -Inf, so that every row goes left in the first tree and right in the
+// second
+func infiniteSplitForest() *pb.Forest {
+	split := func(feature int64, v float64) *pb.TreeNode {
+		return &pb.TreeNode{
+			Feature:    proto.Int64(feature),
+			SplitValue: proto.Float64(v),
+			Left:       &pb.TreeNode{LeafValue: proto.Float64(1.0)},
+			Right:      &pb.TreeNode{LeafValue: proto.Float64(-2.0)},
+		}
+	}
+	return &pb.Forest{
+		Trees:     []*pb.TreeNode{split(0, math.Inf(1)), split(4, math.Inf(-1))},
+		Rescaling: pb.Rescaling_NONE.Enum(),
+	}
+}
+
+func checkGeneratedC(t *testing.T, compiler string, forest *pb.Forest, layout treeLayout) {
+	dir, err := ioutil.TempDir("", "codegen_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := cCodeGenerator{codeGenerator: codeGenerator{forest: forest, layout: layout}, name: "forest"}
+	if err := g.write(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.c"), []byte(cDemo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "main")
+	cmd := exec.Command(compiler, "-std=c99", "-pedantic", "-Wall", "-Wextra", "-Werror",
+		filepath.Join(dir, "main.c"), filepath.Join(dir, "forest.c"), "-o", binary, "-lm")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+
+	// Pad each row past the features the forest uses, to check the
+	// stride is respected
+	numUsed, stride := 5, 7
+	rows := make([][]float64, 0)
+	input := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		fv := make([]float64, stride)
+		for j := range fv {
+			fv[j] = rand.Float64()
+			input = append(input, strconv.FormatFloat(fv[j], 'g', -1, 64))
+		}
+		rows = append(rows, fv[:numUsed])
+	}
+
+	cmd = exec.Command(binary, strconv.Itoa(len(rows)), strconv.Itoa(stride))
+	cmd.Stdin = strings.NewReader(strings.Join(input, " "))
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != len(rows)+2 {
+		t.Fatalf("expected %v lines, got %v", len(rows)+2, lines)
+	}
+	if version, _ := g.version(); lines[0] != version || !strings.HasPrefix(version, "1-") {
+		t.Errorf("expected version %v, got %v", version, lines[0])
+	}
+	numFeatures, _ := dt.NumFeatures(forest)
+	if expected := strconv.Itoa(numFeatures); lines[1] != expected {
+		t.Errorf("expected %v features, got %v", expected, lines[1])
+	}
+
+	eval, err := dt.NewRescaledFastForestEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fv := range rows {
+		actual, err := strconv.ParseFloat(lines[i+2], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := eval.Evaluate(fv); math.Abs(expected-actual) > 1e-12 {
+			t.Errorf("%v %v row %v: expected %v, got %v", forest.GetRescaling(), layout, i, expected, actual)
+		}
+	}
+}
+
+// cTwoModels calls two forests linked into one binary
+const cTwoModels = `#include <stdio.h>
+#include "first.h"
+#include "second-model.h"
+
+int main(void) {
+  double f[5] = {0.5, 0.5, 0.5, 0.5, 0.5};
+  printf("%.17g\n%.17g\n", first_evaluate(f), second_model_evaluate(f));
+  return 0;
+}
+`
+
+func TestGeneratedCModelsLinkTogether(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not on the PATH")
+	}
+
+	dir, err := ioutil.TempDir("", "codegen_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	forests := goTestForests()[:2]
+	sources := []string{filepath.Join(dir, "main.c")}
+	for i, name := range []string{"first", "second-model"} {
+		g := cCodeGenerator{codeGenerator: codeGenerator{forest: forests[i]}, name: name}
+		if err := g.write(dir); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, filepath.Join(dir, name+".c"))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.c"), []byte(cTwoModels), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "main")
+	args := append([]string{"-std=c99", "-pedantic", "-Wall", "-Wextra", "-Werror"}, sources...)
+	cmd := exec.Command(compiler, append(args, "-o", binary, "-lm")...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	output, err := exec.Command(binary).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fv := []float64{0.5, 0.5, 0.5, 0.5, 0.5}
+	lines := strings.Fields(string(output))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 predictions, got %q", output)
+	}
+	for i, line := range lines {
+		actual, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eval, err := dt.NewRescaledFastForestEvaluator(forests[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := eval.Evaluate(fv); math.Abs(expected-actual) > 1e-12 {
+			t.Errorf("forest %v: expected %v, got %v", i, expected, actual)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"testing"
 )
@@ -22,7 +24,7 @@ double (*evaluationFunc)(const double*);
 
 int main(int argc, char** argv) {
   void* handle = dlopen(argv[1], RTLD_LOCAL | RTLD_LAZY);
-  *(void **)(&evaluationFunc) = dlsym(handle, "evaluate");
+  *(void **)(&evaluationFunc) = dlsym(handle, argv[3]);
   std::vector<double> f(1000, strtod(argv[2], NULL));
   const double result = (*evaluationFunc)(f.data());
   std::cout << result;
@@ -51,75 +53,152 @@ func makeAnnotatedTree(level int, numFeatures int) *pb.TreeNode {
 	return t
 }
 
-func generateCompiledEvaluator(t *testing.T) string {
-	cppDemoFile, _ := ioutil.TempFile("/tmp", "codegen_tree_evaluator_cpp")
-	cppExecutable, _ := ioutil.TempFile("/tmp", "codegen_tree_evaluator_binary")
+// generateCompiledEvaluator builds the demo in dir, returning the path of
+// the binary
+func generateCompiledEvaluator(t *testing.T, dir string) string {
+	sourcePath := filepath.Join(dir, "evaluator.cpp")
+	if err := ioutil.WriteFile(sourcePath, []byte(cppDemo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	binaryPath := filepath.Join(dir, "evaluator")
+	cmd := exec.Command(*compilerPath, "-O0", sourcePath, "-o", binaryPath, "-ldl")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	return binaryPath
+}
 
-	cppDemoFile.WriteString(cppDemo)
+func checkGeneratedCode(t *testing.T, forest *pb.Forest) {
+	dir, err := ioutil.TempDir("", "codegen_tree_evaluator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
-	commands := [][]string{
-		{
-			"mv",
-			cppDemoFile.Name(),
-			cppDemoFile.Name() + ".cpp",
-		},
-		{
-			"clang++",
-			"-O0",
-			cppDemoFile.Name() + ".cpp",
-			"-o",
-			cppExecutable.Name(),
-		},
+	evaluatorBinary := generateCompiledEvaluator(t, dir)
+	eval, err := dt.NewRescaledFastForestEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marginEval, err := dt.NewRescaledFastForestEvaluator(&pb.Forest{Trees: forest.GetTrees()})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, command := range commands {
-		cmd := exec.Command(command[0], command[1:]...)
-		err := cmd.Run()
+	for _, layout := range []treeLayout{branchLayout, arrayLayout} {
+		sharedLibrary, err := compileTree(forest, layout)
 		if err != nil {
-			t.Fatal(err, command)
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(filepath.Dir(sharedLibrary))
+
+		// Test a range of feature values and verify that the
+		// correct value is computed each time
+		for _, featureValue := range []float64{0.0, 0.25, 0.5, 0.75, 1.0} {
+			fv := make([]float64, 1000)
+			for i := range fv {
+				fv[i] = featureValue
+			}
+			entryPoints := map[string]dt.Evaluator{
+				"evaluate":        eval,
+				"evaluate_margin": marginEval,
+			}
+			for symbol, evaluator := range entryPoints {
+				featureValueString := strconv.FormatFloat(featureValue, 'f', -1, 64)
+				cmd := exec.Command(evaluatorBinary, sharedLibrary, featureValueString, symbol)
+				result, err := cmd.Output()
+				if err != nil {
+					t.Fatal(err)
+				}
+				evaluation, err := strconv.ParseFloat(string(result), 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				interpreted := evaluator.Evaluate(fv)
+				if math.Abs(interpreted-evaluation) > 0.001 {
+					t.Fatal(layout, symbol, interpreted, evaluation)
+				}
+				t.Log(layout, symbol, interpreted, evaluation)
+			}
 		}
 	}
-	return cppExecutable.Name()
 }
 
-func TestGeneratingCode(t *testing.T) {
-	numTrees, numLevels, numFeatures := 5, 2, 3
+func makeAnnotatedForest(numTrees, numLevels, numFeatures int) *pb.Forest {
 	forest := &pb.Forest{
 		Trees: make([]*pb.TreeNode, 0, numTrees),
 	}
 	for i := 0; i < numTrees; i++ {
 		forest.Trees = append(forest.Trees, makeAnnotatedTree(numLevels, numFeatures))
 	}
+	return forest
+}
 
-	evaluatorBinary := generateCompiledEvaluator(t)
-	sharedLibrary, err := compileTree(forest)
-	if err != nil {
-		t.Fatal(err)
+func TestGeneratingCode(t *testing.T) {
+	numTrees, numLevels, numFeatures := 5, 2, 3
+	checkGeneratedCode(t, makeAnnotatedForest(numTrees, numLevels, numFeatures))
+}
+
+func TestGeneratingRescaledCode(t *testing.T) {
+	numTrees, numLevels, numFeatures := 5, 2, 3
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_LOG_ODDS, pb.Rescaling_AVERAGING} {
+		forest := makeAnnotatedForest(numTrees, numLevels, numFeatures)
+		forest.Rescaling = rescaling.Enum()
+		checkGeneratedCode(t, forest)
 	}
+}
 
-	// Test a range of feature values and verify that the
-	// correct value is computed each time
-	for _, featureValue := range []float64{0.0, 0.25, 0.5, 0.75, 1.0} {
-		featureValueString := strconv.FormatFloat(featureValue, 'f', -1, 64)
-		cmd := exec.Command(evaluatorBinary, sharedLibrary, featureValueString)
-		result, _ := cmd.Output()
-		evaluation, err := strconv.ParseFloat(string(result), 64)
-		if err != nil {
-			t.Fatal(err)
-		}
-		eval, err := dt.NewRescaledFastForestEvaluator(forest)
-		if err != nil {
-			t.Fatal(err)
-		}
-		fv := make([]float64, 1000)
-		for i := range fv {
-			fv[i] = featureValue
-		}
+func TestGeneratingCalibratedCode(t *testing.T) {
+	numTrees, numLevels, numFeatures := 5, 2, 3
+	forest := makeAnnotatedForest(numTrees, numLevels, numFeatures)
+	forest.Rescaling = pb.Rescaling_CALIBRATED.Enum()
 
-		interpreted := eval.Evaluate(fv)
-		if math.Abs(interpreted-evaluation) > 0.001 {
-			t.Fatal(interpreted, evaluation)
+	forest.Calibrator = &pb.Calibrator{
+		Method:        pb.CalibrationMethod_PLATT.Enum(),
+		BaseRescaling: pb.Rescaling_AVERAGING.Enum(),
+		PlattA:        proto.Float64(-4.0),
+		PlattB:        proto.Float64(2.0),
+	}
+	checkGeneratedCode(t, forest)
+
+	forest.Calibrator = &pb.Calibrator{
+		Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+		BaseRescaling:      pb.Rescaling_LOG_ODDS.Enum(),
+		IsotonicThresholds: []float64{0.8, 0.9, 0.95, 0.99},
+		IsotonicValues:     []float64{0.1, 0.3, 0.3, 0.7},
+	}
+	checkGeneratedCode(t, forest)
+}
+
+func TestGeneratorsRejectNegativeFeatures(t *testing.T) {
+	forest := makeAnnotatedForest(2, 2, 3)
+	forest.Trees[1].Left.Feature = proto.Int64(-1)
+	if _, err := (&goCodeGenerator{forest: forest, packageName: "model"}).generate(); err == nil {
+		t.Error("expected an error generating Go for a negative feature")
+	}
+	c := cCodeGenerator{codeGenerator: codeGenerator{forest: forest, layout: branchLayout}, name: "model"}
+	if _, err := c.source(); err == nil {
+		t.Error("expected an error generating C for a negative feature")
+	}
+	if _, err := defaultColumns(forest); err == nil {
+		t.Error("expected an error naming the SQL columns for a negative feature")
+	}
+}
+
+func TestCFloat(t *testing.T) {
+	cases := map[float64]string{
+		0.25:         "0.25",
+		-3:           "-3",
+		math.Inf(1):  "INFINITY",
+		math.Inf(-1): "-INFINITY",
+	}
+	for v, expected := range cases {
+		if actual := cFloat(v); actual != expected {
+			t.Errorf("%v: expected %v, got %v", v, expected, actual)
 		}
-		t.Log(interpreted, evaluation)
+	}
+	if actual := cFloat(math.NaN()); actual != "NAN" {
+		t.Errorf("NaN: expected NAN, got %v", actual)
 	}
 }
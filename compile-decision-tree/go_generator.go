@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// goCodeGenerator emits a standalone Go package evaluating the forest,
+// with no dependencies outside the standard library
+type goCodeGenerator struct {
+	forest      *pb.Forest
+	packageName string
+}
+
+// goFloat returns a Go expression for the value that round-trips exactly
+func goFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "math.NaN()"
+	case math.IsInf(v, 1):
+		return "math.Inf(1)"
+	case math.IsInf(v, -1):
+		return "math.Inf(-1)"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func printGoNode(node *pb.TreeNode, c *codeWriter) {
+	if node.GetLeft() == nil && node.GetRight() == nil {
+		c.WriteString(fmt.Sprintf("return %v\n", goFloat(node.GetLeafValue())))
+		return
+	}
+
+	c.WriteString(fmt.Sprintf("if f[%v] < %v {\n", node.GetFeature(), goFloat(node.GetSplitValue())))
+	{
+		c.indentLevel++
+		printGoNode(node.GetLeft(), c)
+		c.indentLevel--
+	}
+	c.WriteString("}\n")
+	printGoNode(node.GetRight(), c)
+}
+
+// baseRescaling returns the Go expression applying the uncalibrated
+// rescaling method to the margin, matching the interpreted evaluator
+func (g *goCodeGenerator) baseRescaling(r pb.Rescaling) string {
+	switch r {
+	case pb.Rescaling_AVERAGING:
+		return fmt.Sprintf("margin / %v", goFloat(float64(len(g.forest.GetTrees()))))
+	case pb.Rescaling_LOG_ODDS:
+		return "1.0 / (1.0 + math.Exp(-2.0*margin))"
+	}
+	return "margin"
+}
+
+func (g *goCodeGenerator) generateRescaling(cw *codeWriter) {
+	cw.WriteString("// rescale maps the sum of the trees to the prediction of the forest\n")
+	cw.WriteString("func rescale(margin float64) float64 {\n")
+	cw.indentLevel++
+	defer func() {
+		cw.indentLevel--
+		cw.WriteString("}\n")
+	}()
+	if g.forest.GetRescaling() != pb.Rescaling_CALIBRATED {
+		cw.WriteString(fmt.Sprintf("return %v\n", g.baseRescaling(g.forest.GetRescaling())))
+		return
+	}
+
+	calibrator := g.forest.GetCalibrator()
+	cw.WriteString(fmt.Sprintf("score := %v\n", g.baseRescaling(calibrator.GetBaseRescaling())))
+	if calibrator.GetMethod() == pb.CalibrationMethod_PLATT {
+		cw.WriteString(fmt.Sprintf("return 1.0 / (1.0 + math.Exp(%v*score+%v))\n",
+			goFloat(calibrator.GetPlattA()), goFloat(calibrator.GetPlattB())))
+		return
+	}
+
+	formatArray := func(values []float64) string {
+		formatted := make([]string, 0, len(values))
+		for _, v := range values {
+			formatted = append(formatted, goFloat(v))
+		}
+		return strings.Join(formatted, ", ")
+	}
+	thresholds, values := calibrator.GetIsotonicThresholds(), calibrator.GetIsotonicValues()
+	cw.WriteString(fmt.Sprintf("thresholds := [...]float64{%v}\n", formatArray(thresholds)))
+	cw.WriteString(fmt.Sprintf("values := [...]float64{%v}\n", formatArray(values)))
+	cw.WriteString("if score <= thresholds[0] {\n")
+	cw.WriteString("return values[0]\n")
+	cw.WriteString("}\n")
+	cw.WriteString("if score >= thresholds[len(thresholds)-1] {\n")
+	cw.WriteString("return values[len(values)-1]\n")
+	cw.WriteString("}\n")
+	cw.WriteString("i := sort.SearchFloat64s(thresholds[:], score)\n")
+	cw.WriteString("fraction := (score - thresholds[i-1]) / (thresholds[i] - thresholds[i-1])\n")
+	cw.WriteString("return values[i-1] + fraction*(values[i]-values[i-1])\n")
+}
+
+// generate returns the gofmt'd source of the package
+func (g *goCodeGenerator) generate() (string, error) {
+	numFeatures, err := dt.NumFeatures(g.forest)
+	if err != nil {
+		return "", err
+	}
+
+	cw := &codeWriter{}
+	cw.WriteString("// NumFeatures is the length of the feature vectors the forest expects\n")
+	cw.WriteString(fmt.Sprintf("const NumFeatures = %v\n\n", numFeatures))
+
+	for i, t := range g.forest.GetTrees() {
+		cw.WriteString(fmt.Sprintf("func evaluateTree%v(f []float64) float64 {\n", i))
+		cw.indentLevel++
+		printGoNode(t, cw)
+		cw.indentLevel--
+		cw.WriteString("}\n\n")
+	}
+	g.generateRescaling(cw)
+	cw.WriteString("\n")
+
+	cw.WriteString("// EvaluateMargin returns the sum of the trees of the forest\n")
+	cw.WriteString("func EvaluateMargin(f []float64) float64 {\n")
+	cw.indentLevel++
+	cw.WriteString("result := 0.0\n")
+	for i := range g.forest.GetTrees() {
+		cw.WriteString(fmt.Sprintf("result += evaluateTree%v(f)\n", i))
+	}
+	cw.WriteString("return result\n")
+	cw.indentLevel--
+	cw.WriteString("}\n\n")
+
+	cw.WriteString("// Evaluate returns the rescaled prediction of the forest\n")
+	cw.WriteString("func Evaluate(f []float64) float64 {\n")
+	cw.WriteString("return rescale(EvaluateMargin(f))\n")
+	cw.WriteString("}\n\n")
+
+	cw.WriteString("// EvaluateBatch writes the prediction of rows[i] to predictions[i]\n")
+	cw.WriteString("func EvaluateBatch(rows [][]float64, predictions []float64) {\n")
+	cw.WriteString("for i, f := range rows {\n")
+	cw.WriteString("predictions[i] = Evaluate(f)\n")
+	cw.WriteString("}\n")
+	cw.WriteString("}\n\n")
+
+	cw.WriteString("// EvaluateMarginBatch writes the margin of rows[i] to margins[i]\n")
+	cw.WriteString("func EvaluateMarginBatch(rows [][]float64, margins []float64) {\n")
+	cw.WriteString("for i, f := range rows {\n")
+	cw.WriteString("margins[i] = EvaluateMargin(f)\n")
+	cw.WriteString("}\n")
+	cw.WriteString("}\n")
+
+	header := &codeWriter{}
+	header.WriteString("// Code generated by compile-decision-tree. DO NOT EDIT.\n\n")
+	header.WriteString(fmt.Sprintf("// Package %v evaluates a forest of %v decision trees.\n", g.packageName, len(g.forest.GetTrees())))
+	header.WriteString(fmt.Sprintf("package %v\n\n", g.packageName))
+	// Only import the packages the rescaling or leaf values use
+	for _, p := range []string{"math", "sort"} {
+		if strings.Contains(cw.b.String(), p+".") {
+			header.WriteString(fmt.Sprintf("import %q\n", p))
+		}
+	}
+	header.WriteString("\n")
+
+	formatted, err := format.Source(append(header.b.Bytes(), cw.b.Bytes()...))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
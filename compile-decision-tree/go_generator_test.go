@@ -0,0 +1,192 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// goDemo evaluates the feature vectors on stdin with each generated
+// package, writing the predictions and margins of each to stdout
+const goDemo = `package main
+
+import (
+	"encoding/json"
+	"os"
+%v)
+
+type evaluation struct {
+	Predictions []float64
+	Margins     []float64
+}
+
+func main() {
+	rows := make([][]float64, 0)
+	if err := json.NewDecoder(os.Stdin).Decode(&rows); err != nil {
+		panic(err)
+	}
+	evaluations := make([]evaluation, 0)
+	for _, f := range []struct {
+		evaluate func([][]float64, []float64)
+		margin   func([][]float64, []float64)
+	}{
+%v	} {
+		e := evaluation{make([]float64, len(rows)), make([]float64, len(rows))}
+		f.evaluate(rows, e.Predictions)
+		f.margin(rows, e.Margins)
+		evaluations = append(evaluations, e)
+	}
+	json.NewEncoder(os.Stdout).Encode(evaluations)
+}
+`
+
+func goTestForests() []*pb.Forest {
+	numTrees, numLevels, numFeatures := 10, 4, 5
+	forests := make([]*pb.Forest, 0)
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_AVERAGING, pb.Rescaling_LOG_ODDS} {
+		forest := makeAnnotatedForest(numTrees, numLevels, numFeatures)
+		forest.Rescaling = rescaling.Enum()
+		forests = append(forests, forest)
+	}
+
+	platt := makeAnnotatedForest(numTrees, numLevels, numFeatures)
+	platt.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	platt.Calibrator = &pb.Calibrator{
+		Method:        pb.CalibrationMethod_PLATT.Enum(),
+		BaseRescaling: pb.Rescaling_AVERAGING.Enum(),
+		PlattA:        proto.Float64(-4.0),
+		PlattB:        proto.Float64(2.0),
+	}
+	isotonic := makeAnnotatedForest(numTrees, numLevels, numFeatures)
+	isotonic.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	isotonic.Calibrator = &pb.Calibrator{
+		Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+		BaseRescaling:      pb.Rescaling_LOG_ODDS.Enum(),
+		IsotonicThresholds: []float64{0.8, 0.9, 0.95, 0.99},
+		IsotonicValues:     []float64{0.1, 0.3, 0.3, 0.7},
+	}
+	return append(forests, platt, isotonic)
+}
+
+func TestGeneratedGoMatchesEvaluator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not on the PATH")
+	}
+
+	dir, err := ioutil.TempDir("", "codegen_go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	forests := goTestForests()
+	imports, entryPoints := "", ""
+	for i, forest := range forests {
+		name := fmt.Sprintf("forest%v", i)
+		g := goCodeGenerator{forest: forest, packageName: name}
+		source, err := g.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, name+".go"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		imports += fmt.Sprintf("\t%q\n", "codegen/"+name)
+		entryPoints += fmt.Sprintf("\t\t{%v.EvaluateBatch, %v.EvaluateMarginBatch},\n", name, name)
+	}
+	files := map[string]string{
+		"go.mod":  "module codegen\n",
+		"main.go": fmt.Sprintf(goDemo, imports, entryPoints),
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Include values equal to thresholds, which go right
+	rows := make([][]float64, 0)
+	for i := 0; i < 200; i++ {
+		fv := make([]float64, 5)
+		for j := range fv {
+			fv[j] = rand.Float64()
+		}
+		rows = append(rows, fv)
+	}
+	for _, forest := range forests {
+		root := forest.GetTrees()[0]
+		fv := make([]float64, 5)
+		fv[root.GetFeature()] = root.GetSplitValue()
+		rows = append(rows, fv)
+	}
+	serializedRows, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goBinary, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=on", "GOPROXY=off")
+	cmd.Stdin = strings.NewReader(string(serializedRows))
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	evaluations := make([]struct {
+		Predictions []float64
+		Margins     []float64
+	}, 0)
+	if err := json.Unmarshal(output, &evaluations); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, forest := range forests {
+		eval, err := dt.NewRescaledFastForestEvaluator(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		marginEval, err := dt.NewRescaledFastForestEvaluator(&pb.Forest{Trees: forest.GetTrees()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, fv := range rows {
+			if expected, actual := eval.Evaluate(fv), evaluations[i].Predictions[j]; math.Abs(expected-actual) > 1e-12 {
+				t.Errorf("%v row %v: expected %v, got %v", forest.GetRescaling(), j, expected, actual)
+			}
+			if expected, actual := marginEval.Evaluate(fv), evaluations[i].Margins[j]; math.Abs(expected-actual) > 1e-12 {
+				t.Errorf("%v row %v: expected margin %v, got %v", forest.GetRescaling(), j, expected, actual)
+			}
+		}
+	}
+}
+
+func TestGoFloatRoundTrips(t *testing.T) {
+	for _, v := range []float64{0, 1, -2, 0.1, 1e300, 5e-324, math.Pi} {
+		s := goFloat(v)
+		if !strings.ContainsAny(s, ".e") {
+			t.Errorf("%v: expected a float literal, got %v", v, s)
+		}
+		var parsed float64
+		if _, err := fmt.Sscan(s, &parsed); err != nil || parsed != v {
+			t.Errorf("%v: %v parsed as %v, %v", v, s, parsed, err)
+		}
+	}
+}
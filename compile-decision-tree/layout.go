@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"strings"
+)
+
+// treeLayout is how the cpp and c backends emit each tree
+type treeLayout int
+
+const (
+	// branchLayout emits nested if/else statements, with LIKELY and
+	// UNLIKELY hints from the annotations of the tree
+	branchLayout treeLayout = iota
+	// arrayLayout stores each tree as a complete binary tree in arrays,
+	// with the children of node i at 2i + 1 and 2i + 2, and descends a
+	// level per iteration without branching on the features
+	arrayLayout
+)
+
+var treeLayoutNames = map[treeLayout]string{
+	branchLayout: "branches",
+	arrayLayout:  "array",
+}
+
+func (l treeLayout) String() string {
+	return treeLayoutNames[l]
+}
+
+func parseTreeLayout(s string) (treeLayout, error) {
+	for l, name := range treeLayoutNames {
+		if name == s {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tree layout: %v", s)
+}
+
+// maxArrayDepth is the depth of the deepest tree emitted with the array
+// layout, as a complete tree has 2^depth leaves.  Deeper trees fall back
+// to the branch layout.
+const maxArrayDepth = 12
+
+func treeDepth(node *pb.TreeNode) int {
+	if node.GetLeft() == nil && node.GetRight() == nil {
+		return 0
+	}
+	left, right := treeDepth(node.GetLeft()), treeDepth(node.GetRight())
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}
+
+// completeTree holds a tree padded to a complete binary tree of its
+// depth, with the internal nodes in breadth-first order.  A leaf above the
+// bottom level is padded with splits sending both ways to copies of it.
+type completeTree struct {
+	depth      int
+	features   []int64
+	thresholds []float64
+	leaves     []float64
+}
+
+func newCompleteTree(t *pb.TreeNode) *completeTree {
+	c := &completeTree{depth: treeDepth(t)}
+	numInternal := (1 << uint(c.depth)) - 1
+	c.features = make([]int64, numInternal)
+	c.thresholds = make([]float64, numInternal)
+	c.leaves = make([]float64, numInternal+1)
+	c.fill(t, 0)
+	return c
+}
+
+func (c *completeTree) fill(node *pb.TreeNode, index int) {
+	if index >= len(c.features) {
+		c.leaves[index-len(c.features)] = node.GetLeafValue()
+		return
+	}
+	if node.GetLeft() == nil && node.GetRight() == nil {
+		// Both children are copies of the leaf, so the split is arbitrary
+		c.fill(node, 2*index+1)
+		c.fill(node, 2*index+2)
+		return
+	}
+	c.features[index] = node.GetFeature()
+	c.thresholds[index] = node.GetSplitValue()
+	c.fill(node.GetLeft(), 2*index+1)
+	c.fill(node.GetRight(), 2*index+2)
+}
+
+// printArrayTree emits the body of a function evaluating the tree with
+// the array layout.  A feature vector goes right at a node unless it is
+// below the threshold, so NaN goes right as in the interpreted evaluator.
+func printArrayTree(t *pb.TreeNode, cw *codeWriter) {
+	c := newCompleteTree(t)
+	features := make([]string, 0, len(c.features))
+	for _, f := range c.features {
+		features = append(features, fmt.Sprintf("%v", f))
+	}
+	thresholds := make([]string, 0, len(c.thresholds))
+	for _, v := range c.thresholds {
+		thresholds = append(thresholds, cFloat(v))
+	}
+	leaves := make([]string, 0, len(c.leaves))
+	for _, v := range c.leaves {
+		leaves = append(leaves, cFloat(v))
+	}
+
+	cw.WriteString(fmt.Sprintf("static const int features[] = {%v};\n", strings.Join(features, ", ")))
+	cw.WriteString(fmt.Sprintf("static const double thresholds[] = {%v};\n", strings.Join(thresholds, ", ")))
+	cw.WriteString(fmt.Sprintf("static const double leaves[] = {%v};\n", strings.Join(leaves, ", ")))
+	cw.WriteString("int i = 0;\n")
+	cw.WriteString("int level;\n")
+	cw.WriteString(fmt.Sprintf("for (level = 0; level < %v; level++) {\n", c.depth))
+	cw.WriteString("  i = 2 * i + 1 + !(f[features[i]] < thresholds[i]);\n")
+	cw.WriteString("}\n")
+	cw.WriteString(fmt.Sprintf("return leaves[i - %v];\n", len(c.features)))
+}
+
+// printTree emits the body of a function evaluating the tree with the
+// layout of the generator
+func (c *codeGenerator) printTree(t *pb.TreeNode, cw *codeWriter) {
+	depth := treeDepth(t)
+	if c.layout == arrayLayout && depth > 0 && depth <= maxArrayDepth {
+		printArrayTree(t, cw)
+		return
+	}
+	printNode(t, cw)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// evaluate descends the complete tree as the generated array code does
+func (c *completeTree) evaluate(f []float64) float64 {
+	i := 0
+	for level := 0; level < c.depth; level++ {
+		i = 2*i + 1
+		if !(f[c.features[i/2]] < c.thresholds[i/2]) {
+			i++
+		}
+	}
+	return c.leaves[i-len(c.features)]
+}
+
+func TestCompleteTreePadsShallowLeaves(t *testing.T) {
+	leaf := func(v float64) *pb.TreeNode {
+		return &pb.TreeNode{LeafValue: proto.Float64(v)}
+	}
+	split := func(feature int64, value float64, left, right *pb.TreeNode) *pb.TreeNode {
+		return &pb.TreeNode{
+			Feature:    proto.Int64(feature),
+			SplitValue: proto.Float64(value),
+			Left:       left,
+			Right:      right,
+		}
+	}
+	tree := split(0, 0.5,
+		leaf(1.0),
+		split(1, 0.5, leaf(2.0), split(2, 0.5, leaf(3.0), leaf(4.0))))
+	c := newCompleteTree(tree)
+	if c.depth != 3 || len(c.features) != 7 || len(c.leaves) != 8 {
+		t.Fatalf("unexpected complete tree %+v", c)
+	}
+	for i := 0; i < 4; i++ {
+		if c.leaves[i] != 1.0 {
+			t.Errorf("expected the shallow leaf to be copied, got %v", c.leaves)
+		}
+	}
+
+	eval, err := dt.NewRescaledFastForestEvaluator(&pb.Forest{Trees: []*pb.TreeNode{tree}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		fv := []float64{rand.Float64(), rand.Float64(), rand.Float64()}
+		if i == 0 {
+			fv[1] = math.NaN()
+		}
+		if expected, actual := eval.Evaluate(fv), c.evaluate(fv); expected != actual {
+			t.Errorf("%v: expected %v, got %v", fv, expected, actual)
+		}
+	}
+}
+
+func TestArrayTreeWritesInfiniteThresholds(t *testing.T) {
+	tree := infiniteSplitForest().GetTrees()[0]
+	cw := &codeWriter{}
+	printArrayTree(tree, cw)
+	if source := cw.b.String(); !strings.Contains(source, "thresholds[] = {INFINITY}") {
+		t.Errorf("expected an INFINITY threshold, got %v", source)
+	}
+}
+
+func TestParseTreeLayout(t *testing.T) {
+	for _, l := range []treeLayout{branchLayout, arrayLayout} {
+		parsed, err := parseTreeLayout(l.String())
+		if err != nil || parsed != l {
+			t.Errorf("expected %v, got %v, %v", l, parsed, err)
+		}
+	}
+	if _, err := parseTreeLayout("simd"); err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
+
+func TestBenchmarkLayoutsAgree(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+	if _, err := exec.LookPath(*cCompilerPath); err != nil {
+		t.Skipf("%v is not on the PATH", *cCompilerPath)
+	}
+
+	results, err := timeLayouts(makeAnnotatedForest(20, 4, 10), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected a result per layout, got %v", results)
+	}
+	for _, r := range results {
+		if r.checksum != results[0].checksum {
+			t.Errorf("%v checksum %v differs from %v checksum %v",
+				r.layout, r.checksum, results[0].layout, results[0].checksum)
+		}
+		t.Log(r.layout, r.nanosPerRow, r.checksum)
+	}
+}
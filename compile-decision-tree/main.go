@@ -6,10 +6,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"github.com/golang/glog"
 	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -19,7 +22,31 @@ var (
 	// likelyThreshold, then we add a likely/unlikely annotation
 	likelyThreshold = flag.Float64("likely_threshold", 0.4, "")
 
-	compilerPath = flag.String("compiler", "/usr/bin/clang++", "")
+	compilerPath = flag.String("compiler", "c++", "C++ compiler for the cpp backend")
+	layoutName   = flag.String("layout", "branches", "layout of the trees for the cpp and c backends, branches or array")
+	// Benchmark flags
+	benchmarkLayouts = flag.Bool("benchmark_layouts", false, "time each tree layout on random rows instead of generating code")
+	benchmarkRows    = flag.Int("benchmark_rows", 100000, "number of rows to time each layout on")
+	cCompilerPath    = flag.String("c_compiler", "cc", "C compiler for the layout benchmark")
+
+	backend = flag.String(
+		"backend",
+		"cpp",
+		"cpp to compile a shared object, go to emit a Go package, c to emit a C99 header and source, "+
+			"or sql to emit a SQL query")
+	outputPath = flag.String("output", "", "file to write the generated Go or SQL source to, or stdout if empty")
+	// Go backend flags
+	goPackage = flag.String("go_package", "forest", "name of the generated Go package")
+	// C backend flags
+	cName     = flag.String("c_name", "forest", "base name of the generated C header and source, and prefix of their functions")
+	outputDir = flag.String("output_dir", ".", "directory to write the generated C header and source to")
+	// SQL backend flags
+	sqlDialectName = flag.String("sql_dialect", "ansi", "ansi or sqlite")
+	sqlTable       = flag.String("sql_table", "examples", "table holding the rows to evaluate")
+	sqlColumnsPath = flag.String(
+		"sql_columns",
+		"",
+		"file with the column name of feature i on line i + 1, or empty to name the columns f0, f1, ...")
 )
 
 func parseToProto(file string, protobuf proto.Message) error {
@@ -33,6 +60,7 @@ func parseToProto(file string, protobuf proto.Message) error {
 
 type codeGenerator struct {
 	forest *pb.Forest
+	layout treeLayout
 }
 
 type codeWriter struct {
@@ -60,13 +88,41 @@ func getAnnotation(node *pb.TreeNode) string {
 	return ""
 }
 
+// cFloat returns a C expression for the value, using the INFINITY and NAN
+// macros from math.h for values without a literal
+func cFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NAN"
+	case math.IsInf(v, 1):
+		return "INFINITY"
+	case math.IsInf(v, -1):
+		return "-INFINITY"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// hasNonFiniteValues returns whether the tree has an infinite or NaN split
+// or leaf value, which cFloat writes with math.h macros
+func hasNonFiniteValues(node *pb.TreeNode) bool {
+	if node == nil {
+		return false
+	}
+	for _, v := range []float64{node.GetLeafValue(), node.GetSplitValue()} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return true
+		}
+	}
+	return hasNonFiniteValues(node.GetLeft()) || hasNonFiniteValues(node.GetRight())
+}
+
 func printNode(node *pb.TreeNode, c *codeWriter) {
 	if node.GetLeft() == nil && node.GetRight() == nil {
-		c.WriteString(fmt.Sprintf("return %v;\n", node.GetLeafValue()))
+		c.WriteString(fmt.Sprintf("return %v;\n", cFloat(node.GetLeafValue())))
 		return
 	}
 
-	c.WriteString(fmt.Sprintf("if (%v(f[%v] < %v)) {\n", getAnnotation(node), node.GetFeature(), node.GetSplitValue()))
+	c.WriteString(fmt.Sprintf("if (%v(f[%v] < %v)) {\n", getAnnotation(node), node.GetFeature(), cFloat(node.GetSplitValue())))
 	{
 		c.indentLevel++
 		printNode(node.GetLeft(), c)
@@ -85,26 +141,102 @@ func (c *codeGenerator) generateForest(i int, cw *codeWriter) {
 	cw.WriteString(fmt.Sprintf("double evaluateTree%v(const double* f) {\n", i))
 	{
 		cw.indentLevel++
-		printNode(c.forest.GetTrees()[i], cw)
+		c.printTree(c.forest.GetTrees()[i], cw)
+		cw.indentLevel--
+	}
+	cw.WriteString("}\n")
+}
+
+// baseRescaling returns the C expression applying the uncalibrated
+// rescaling method to the margin
+func (c *codeGenerator) baseRescaling(r pb.Rescaling) string {
+	switch r {
+	case pb.Rescaling_AVERAGING:
+		return fmt.Sprintf("margin / %v", len(c.forest.GetTrees()))
+	case pb.Rescaling_LOG_ODDS:
+		return "1.0 / (1.0 + exp(-2.0 * margin))"
+	}
+	return "margin"
+}
+
+// needsMath returns whether the rescaling of the forest calls into math.h,
+// or the trees have values written with its macros
+func (c *codeGenerator) needsMath() bool {
+	switch c.forest.GetRescaling() {
+	case pb.Rescaling_LOG_ODDS, pb.Rescaling_CALIBRATED:
+		return true
+	}
+	for _, t := range c.forest.GetTrees() {
+		if hasNonFiniteValues(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateRescaling emits a function applying the rescaling of the forest,
+// including the base rescaling and calibrator of a CALIBRATED forest, to
+// the sum of the trees, matching dt.NewRescaledFastForestEvaluator
+func (c *codeGenerator) generateRescaling(cw *codeWriter) {
+	cw.WriteString("static double rescale(double margin) {\n")
+	cw.indentLevel++
+	if c.forest.GetRescaling() != pb.Rescaling_CALIBRATED {
+		cw.WriteString(fmt.Sprintf("return %v;\n", c.baseRescaling(c.forest.GetRescaling())))
 		cw.indentLevel--
+		cw.WriteString("}\n")
+		return
 	}
+
+	calibrator := c.forest.GetCalibrator()
+	cw.WriteString(fmt.Sprintf("const double score = %v;\n", c.baseRescaling(calibrator.GetBaseRescaling())))
+	if calibrator.GetMethod() == pb.CalibrationMethod_PLATT {
+		cw.WriteString(fmt.Sprintf(
+			"return 1.0 / (1.0 + exp(%v * score + %v));\n", cFloat(calibrator.GetPlattA()), cFloat(calibrator.GetPlattB())))
+	} else {
+		formatArray := func(values []float64) string {
+			formatted := make([]string, 0, len(values))
+			for _, v := range values {
+				formatted = append(formatted, cFloat(v))
+			}
+			return strings.Join(formatted, ", ")
+		}
+		thresholds, values := calibrator.GetIsotonicThresholds(), calibrator.GetIsotonicValues()
+		cw.WriteString(fmt.Sprintf("static const double thresholds[] = {%v};\n", formatArray(thresholds)))
+		cw.WriteString(fmt.Sprintf("static const double values[] = {%v};\n", formatArray(values)))
+		cw.WriteString(fmt.Sprintf("const int n = %v;\n", len(thresholds)))
+		cw.WriteString("if (score <= thresholds[0]) return values[0];\n")
+		cw.WriteString("if (score >= thresholds[n - 1]) return values[n - 1];\n")
+		cw.WriteString("int i = 1;\n")
+		cw.WriteString("while (thresholds[i] < score) i++;\n")
+		cw.WriteString("return values[i - 1] + (score - thresholds[i - 1]) / " +
+			"(thresholds[i] - thresholds[i - 1]) * (values[i] - values[i - 1]);\n")
+	}
+	cw.indentLevel--
 	cw.WriteString("}\n")
 }
 
+// generate emits two entry points: evaluate_margin, returning the sum of
+// the trees, and evaluate, returning the rescaled prediction
 func (c *codeGenerator) generate() string {
 	cw := &codeWriter{}
 	cw.WriteString("#define LIKELY(x)   (__builtin_expect((x), 1))\n")
 	cw.WriteString("#define UNLIKELY(x) (__builtin_expect((x), 0))\n")
+	if c.needsMath() {
+		cw.WriteString("#include <math.h>\n")
+	}
 	cw.WriteString(`extern "C" {`)
 	cw.WriteString("\n")
 
+	c.generateRescaling(cw)
+	cw.WriteString("\n")
+
 	for i := range c.forest.GetTrees() {
 		c.generateForest(i, cw)
 		cw.WriteString("\n")
 	}
 
-	// main routine
-	cw.WriteString("double evaluate(const double* f) {\n")
+	// main routines
+	cw.WriteString("double evaluate_margin(const double* f) {\n")
 	{
 		cw.indentLevel++
 		cw.WriteString("double result = 0.0;\n")
@@ -121,22 +253,97 @@ func (c *codeGenerator) generate() string {
 		cw.indentLevel--
 	}
 	cw.WriteString("}\n")
+	cw.WriteString("\n")
+	cw.WriteString("double evaluate(const double* f) {\n")
+	{
+		cw.indentLevel++
+		cw.WriteString("return rescale(evaluate_margin(f));\n")
+		cw.indentLevel--
+	}
+	cw.WriteString("}\n")
 	cw.WriteString("}\n")
 	return cw.b.String()
 }
 
+// writeOutput writes the generated source to -output, or stdout
+func writeOutput(source string) {
+	if *outputPath == "" {
+		os.Stdout.WriteString(source)
+		return
+	}
+	if err := ioutil.WriteFile(*outputPath, []byte(source), 0644); err != nil {
+		glog.Fatal(err)
+	}
+}
+
 func main() {
 	flag.Parse()
 	forest := &pb.Forest{}
 	if err := parseToProto(*forestPath, forest); err != nil {
 		glog.Fatal(err)
 	}
-	g := codeGenerator{forest}
-	glog.Info(g.generate())
-
-	soPath, err := compileTree(forest)
+	if _, err := dt.NewRescaledFastForestEvaluator(forest); err != nil {
+		glog.Fatal(err)
+	}
+	layout, err := parseTreeLayout(*layoutName)
 	if err != nil {
 		glog.Fatal(err)
 	}
-	os.Stdout.WriteString(soPath)
+
+	if *benchmarkLayouts {
+		results, err := timeLayouts(forest, *benchmarkRows)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		for _, r := range results {
+			fmt.Printf("%v\t%.2f ns/row\tchecksum %v\n", r.layout, r.nanosPerRow, r.checksum)
+		}
+		return
+	}
+
+	switch *backend {
+	case "cpp":
+		g := codeGenerator{forest: forest, layout: layout}
+		glog.Info(g.generate())
+
+		soPath, err := compileTree(forest, layout)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		os.Stdout.WriteString(soPath)
+	case "go":
+		g := goCodeGenerator{forest: forest, packageName: *goPackage}
+		source, err := g.generate()
+		if err != nil {
+			glog.Fatal(err)
+		}
+		writeOutput(source)
+	case "c":
+		g := cCodeGenerator{codeGenerator: codeGenerator{forest: forest, layout: layout}, name: *cName}
+		if err := g.write(*outputDir); err != nil {
+			glog.Fatal(err)
+		}
+	case "sql":
+		dialect, err := parseSQLDialect(*sqlDialectName)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		columns, err := defaultColumns(forest)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if *sqlColumnsPath != "" {
+			if columns, err = dt.ReadFeatureNames(*sqlColumnsPath); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		g := sqlCodeGenerator{forest: forest, columns: columns, dialect: dialect}
+		query, err := g.generate(*sqlTable)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		writeOutput(query + "\n")
+	default:
+		glog.Fatalf("Unknown backend: %v", *backend)
+	}
 }
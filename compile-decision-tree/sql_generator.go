@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sqlDialect is the flavour of SQL to generate
+type sqlDialect int
+
+const (
+	// ansiSQL writes numbers as approximate numeric literals, e.g. 5E-1,
+	// so that the arithmetic is done in floating point rather than
+	// decimal
+	ansiSQL sqlDialect = iota
+	// sqliteSQL writes numbers as decimals, which SQLite stores as REAL.
+	// Rescaling with EXP needs SQLite 3.35 or later with the math
+	// functions enabled.
+	sqliteSQL
+)
+
+func parseSQLDialect(s string) (sqlDialect, error) {
+	switch s {
+	case "ansi":
+		return ansiSQL, nil
+	case "sqlite":
+		return sqliteSQL, nil
+	}
+	return 0, fmt.Errorf("unknown SQL dialect: %v", s)
+}
+
+// Columns added to the rows of the table by the generated query
+const (
+	sqlMarginColumn     = "forest_margin"
+	sqlPredictionColumn = "forest_prediction"
+)
+
+// sqlCodeGenerator emits a SQL query evaluating the forest on the rows of
+// a table, with a nested CASE expression for each tree.  A NULL feature
+// goes right at every split, as NaN does.
+type sqlCodeGenerator struct {
+	forest *pb.Forest
+	// columns holds the column name of each feature
+	columns []string
+	dialect sqlDialect
+}
+
+// defaultColumns names the columns f0, f1, ... for a forest without a
+// mapping file
+func defaultColumns(f *pb.Forest) ([]string, error) {
+	numFeatures, err := dt.NumFeatures(f)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, numFeatures)
+	for i := range columns {
+		columns[i] = fmt.Sprintf("f%v", i)
+	}
+	return columns, nil
+}
+
+func (s *sqlCodeGenerator) number(v float64) (string, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("%v cannot be written in SQL", v)
+	}
+	if s.dialect == ansiSQL {
+		return strconv.FormatFloat(v, 'E', -1, 64), nil
+	}
+	return goFloat(v), nil
+}
+
+func (s *sqlCodeGenerator) column(feature int64) (string, error) {
+	if feature < 0 || feature >= int64(len(s.columns)) || s.columns[feature] == "" {
+		return "", fmt.Errorf("no column for feature %v", feature)
+	}
+	return `"` + strings.Replace(s.columns[feature], `"`, `""`, -1) + `"`, nil
+}
+
+func (s *sqlCodeGenerator) tree(node *pb.TreeNode) (string, error) {
+	if node.GetLeft() == nil && node.GetRight() == nil {
+		return s.number(node.GetLeafValue())
+	}
+
+	column, err := s.column(node.GetFeature())
+	if err != nil {
+		return "", err
+	}
+	split, err := s.number(node.GetSplitValue())
+	if err != nil {
+		return "", err
+	}
+	left, err := s.tree(node.GetLeft())
+	if err != nil {
+		return "", err
+	}
+	right, err := s.tree(node.GetRight())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CASE WHEN %v < %v THEN %v ELSE %v END", column, split, left, right), nil
+}
+
+// margin returns the expression summing the trees
+func (s *sqlCodeGenerator) margin() (string, error) {
+	trees := make([]string, 0, len(s.forest.GetTrees()))
+	for _, t := range s.forest.GetTrees() {
+		tree, err := s.tree(t)
+		if err != nil {
+			return "", err
+		}
+		trees = append(trees, "("+tree+")")
+	}
+	if len(trees) == 0 {
+		return s.number(0)
+	}
+	return strings.Join(trees, "\n + "), nil
+}
+
+func (s *sqlCodeGenerator) baseRescaling(r pb.Rescaling, margin string) (string, error) {
+	switch r {
+	case pb.Rescaling_AVERAGING:
+		numTrees, err := s.number(float64(len(s.forest.GetTrees())))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%v) / %v", margin, numTrees), nil
+	case pb.Rescaling_LOG_ODDS:
+		one, _ := s.number(1)
+		two, _ := s.number(-2)
+		return fmt.Sprintf("%v / (%v + EXP(%v * (%v)))", one, one, two, margin), nil
+	}
+	return margin, nil
+}
+
+// isotonic returns the expression interpolating the isotonic calibrator
+// at the score, matching calibrate.  The score is repeated in each branch,
+// so it should be cheap to evaluate.
+func (s *sqlCodeGenerator) isotonic(c *pb.Calibrator, score string) (string, error) {
+	thresholds, values := c.GetIsotonicThresholds(), c.GetIsotonicValues()
+	numbers := func(vs []float64) ([]string, error) {
+		result := make([]string, 0, len(vs))
+		for _, v := range vs {
+			n, err := s.number(v)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, n)
+		}
+		return result, nil
+	}
+	t, err := numbers(thresholds)
+	if err != nil {
+		return "", err
+	}
+	v, err := numbers(values)
+	if err != nil {
+		return "", err
+	}
+
+	n := len(t)
+	interpolate := func(i int) string {
+		return fmt.Sprintf("%v + (%v - %v) / (%v - %v) * (%v - %v)", v[i-1], score, t[i-1], t[i], t[i-1], v[i], v[i-1])
+	}
+	cases := []string{
+		fmt.Sprintf("WHEN %v <= %v THEN %v", score, t[0], v[0]),
+		fmt.Sprintf("WHEN %v >= %v THEN %v", score, t[n-1], v[n-1]),
+	}
+	for i := 1; i < n-1; i++ {
+		cases = append(cases, fmt.Sprintf("WHEN %v <= %v THEN %v", score, t[i], interpolate(i)))
+	}
+	if n == 1 {
+		return fmt.Sprintf("CASE %v ELSE %v END", strings.Join(cases, " "), v[0]), nil
+	}
+	return fmt.Sprintf("CASE %v ELSE %v END", strings.Join(cases, " "), interpolate(n-1)), nil
+}
+
+// rescale returns the expression of the rescaled prediction of the
+// forest, matching dt.NewRescaledFastForestEvaluator, in terms of the
+// margin column
+func (s *sqlCodeGenerator) rescale() (string, error) {
+	margin := `"` + sqlMarginColumn + `"`
+	if s.forest.GetRescaling() != pb.Rescaling_CALIBRATED {
+		return s.baseRescaling(s.forest.GetRescaling(), margin)
+	}
+
+	c := s.forest.GetCalibrator()
+	score, err := s.baseRescaling(c.GetBaseRescaling(), margin)
+	if err != nil {
+		return "", err
+	}
+	score = "(" + score + ")"
+	if c.GetMethod() == pb.CalibrationMethod_PLATT {
+		one, _ := s.number(1)
+		a, err := s.number(c.GetPlattA())
+		if err != nil {
+			return "", err
+		}
+		b, err := s.number(c.GetPlattB())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v / (%v + EXP(%v * %v + %v))", one, one, a, score, b), nil
+	}
+	return s.isotonic(c, score)
+}
+
+// generate returns a query selecting the rows of the table with the
+// margin and rescaled prediction of the forest appended.  The margin is
+// computed once per row in a derived table, and the rescaling refers to
+// it by name.  The table is inserted verbatim, so it may be a qualified
+// name.
+func (s *sqlCodeGenerator) generate(table string) (string, error) {
+	margin, err := s.margin()
+	if err != nil {
+		return "", err
+	}
+	prediction, err := s.rescale()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"SELECT scored.*,\n  %v AS \"%v\"\nFROM (\n  SELECT source.*,\n    %v AS \"%v\"\n  FROM %v AS source\n) AS scored",
+		prediction,
+		sqlPredictionColumn,
+		margin,
+		sqlMarginColumn,
+		table), nil
+}
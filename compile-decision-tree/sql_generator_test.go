@@ -0,0 +1,164 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"database/sql"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	"github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// The bundled SQLite is built without the math functions, so provide
+	// EXP for the rescaling
+	sql.Register("sqlite3_exp", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("exp", math.Exp, true)
+		},
+	})
+}
+
+func TestGeneratedSQLMatchesEvaluator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen_sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	columnsPath := filepath.Join(dir, "columns.txt")
+	mapping := "age\nincome\nhas \"quotes\"\nnum visits\nscore\n"
+	if err := ioutil.WriteFile(columnsPath, []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+	columns, err := dt.ReadFeatureNames(columnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 5 || columns[2] != `has "quotes"` {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+
+	db, err := sql.Open("sqlite3_exp", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Each connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	g := sqlCodeGenerator{columns: columns}
+	quoted := []string{"id INTEGER"}
+	placeholders := []string{"?"}
+	for i := range columns {
+		column, err := g.column(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		quoted = append(quoted, column+" REAL")
+		placeholders = append(placeholders, "?")
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE examples (%v)", strings.Join(quoted, ", "))); err != nil {
+		t.Fatal(err)
+	}
+
+	// A NULL feature goes right, as NaN does
+	rows := make([][]float64, 0)
+	for i := 0; i < 50; i++ {
+		fv := make([]float64, len(columns))
+		for j := range fv {
+			fv[j] = rand.Float64()
+		}
+		rows = append(rows, fv)
+	}
+	rows[0][1] = math.NaN()
+	insert := fmt.Sprintf("INSERT INTO examples VALUES (%v)", strings.Join(placeholders, ", "))
+	for i, fv := range rows {
+		values := []interface{}{i}
+		for _, v := range fv {
+			if math.IsNaN(v) {
+				values = append(values, nil)
+			} else {
+				values = append(values, v)
+			}
+		}
+		if _, err := db.Exec(insert, values...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, forest := range goTestForests() {
+		eval, err := dt.NewRescaledFastForestEvaluator(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dialect := range []sqlDialect{ansiSQL, sqliteSQL} {
+			g := sqlCodeGenerator{forest: forest, columns: columns, dialect: dialect}
+			query, err := g.generate("examples")
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := db.Query(fmt.Sprintf("SELECT id, %v FROM (%v) ORDER BY id", sqlPredictionColumn, query))
+			if err != nil {
+				t.Fatalf("%v: %v", err, query)
+			}
+			numResults := 0
+			for results.Next() {
+				var id int
+				var actual float64
+				if err := results.Scan(&id, &actual); err != nil {
+					t.Fatal(err)
+				}
+				if expected := eval.Evaluate(rows[id]); math.Abs(expected-actual) > 1e-12 {
+					t.Errorf("%v dialect %v row %v: expected %v, got %v", forest.GetRescaling(), dialect, id, expected, actual)
+				}
+				numResults++
+			}
+			if err := results.Err(); err != nil {
+				t.Fatal(err)
+			}
+			results.Close()
+			if numResults != len(rows) {
+				t.Fatalf("expected %v results, got %v", len(rows), numResults)
+			}
+		}
+	}
+}
+
+func TestSQLComputesMarginOnce(t *testing.T) {
+	for _, forest := range goTestForests() {
+		columns, err := defaultColumns(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := sqlCodeGenerator{forest: forest, columns: columns}
+		query, err := g.generate("examples")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := g.tree(forest.GetTrees()[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(query, tree); n != 1 {
+			t.Errorf("%v: expected the first tree once in the query, got %v", forest.GetRescaling(), n)
+		}
+	}
+}
+
+func TestSQLRejectsUnmappedFeatures(t *testing.T) {
+	forest := makeAnnotatedForest(2, 2, 5)
+	forest.Trees[0].Feature = proto.Int64(3)
+	g := sqlCodeGenerator{forest: forest, columns: []string{"only"}}
+	if _, err := g.generate("examples"); err == nil {
+		t.Error("expected an error for features without a column")
+	}
+	if _, err := parseSQLDialect("oracle"); err == nil {
+		t.Error("expected an error for an unknown dialect")
+	}
+}
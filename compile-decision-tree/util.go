@@ -1,50 +1,40 @@
 package main
 
 import (
+	"fmt"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 )
 
-// returns the filename of the shared object file
-func compileTree(f *pb.Forest) (string, error) {
-	c := codeGenerator{f}
+// compileTree compiles the forest into a shared object in a new temporary
+// directory, returning the path of the shared object.  Only the shared
+// object is left behind, and nothing on failure.
+func compileTree(f *pb.Forest, layout treeLayout) (soPath string, err error) {
+	c := codeGenerator{forest: f, layout: layout}
 
-	codeGenFile, _ := ioutil.TempFile("/tmp", "codegen_tree_file")
-	cppO, _ := ioutil.TempFile("/tmp", "codegen_tree_o")
-	cppSo, _ := ioutil.TempFile("/tmp", "codegen_tree_so")
-	codeGenFile.WriteString(c.generate())
-
-	commands := [][]string{
-		{
-			"mv",
-			codeGenFile.Name(),
-			codeGenFile.Name() + ".cpp",
-		},
-		{
-			*compilerPath,
-			"-O3",
-			codeGenFile.Name() + ".cpp",
-			"-c",
-			"-o",
-			cppO.Name(),
-		},
-		{
-			*compilerPath,
-			"-shared",
-			cppO.Name(),
-			"-dynamiclib",
-			"-o",
-			cppSo.Name(),
-		},
+	dir, err := ioutil.TempDir("", "codegen_tree")
+	if err != nil {
+		return "", err
 	}
-
-	for _, command := range commands {
-		cmd := exec.Command(command[0], command[1:]...)
-		err := cmd.Run()
+	defer func() {
 		if err != nil {
-			return "", err
+			os.RemoveAll(dir)
 		}
+	}()
+
+	sourcePath := filepath.Join(dir, "tree.cpp")
+	if err := ioutil.WriteFile(sourcePath, []byte(c.generate()), 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(sourcePath)
+
+	soPath = filepath.Join(dir, "tree.so")
+	cmd := exec.Command(*compilerPath, "-O3", "-shared", "-fPIC", sourcePath, "-o", soPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%v failed: %v: %s", *compilerPath, err, output)
 	}
-	return cppSo.Name(), nil
+	return soPath, nil
 }
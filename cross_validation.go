@@ -1,35 +1,140 @@
 package decisiontrees
 
 import (
+	"code.google.com/p/goprotobuf/proto"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
 	"sync"
 )
 
 type crossValidationFunc func(trainingSet, testingSet Examples) float64
 
-func runCrossValidation(numFolds int, e Examples, f crossValidationFunc) float64 {
-	folds := e.crossValidationSamples(numFolds)
-	crossValidatedResults := make([]float64, numFolds)
+// runFolds calls f concurrently on the training and testing sets of each
+// split
+func runFolds(c CrossValidationConfig, e Examples, f func(pos int, trainingSet, testingSet Examples)) error {
+	splits, err := e.crossValidationSplits(c)
+	if err != nil {
+		return err
+	}
+
 	w := sync.WaitGroup{}
-	for i := range folds {
+	for i := range splits {
 		w.Add(1)
 		go func(pos int) {
-			testingSet := folds[pos]
-			trainingSet := make([]*pb.Example, 0, len(e)*(numFolds-1)/numFolds)
-			for i := range folds {
-				if i != pos {
-					trainingSet = append(trainingSet, folds[i]...)
-				}
-			}
-
-			crossValidatedResults[pos] = f(trainingSet, testingSet)
+			f(pos, splits[pos].trainingSet, splits[pos].testingSet)
 			w.Done()
 		}(i)
 	}
 	w.Wait()
+	return nil
+}
+
+func runCrossValidation(c CrossValidationConfig, e Examples, f crossValidationFunc) (float64, error) {
+	crossValidatedResults := make([]float64, c.NumFolds)
+	err := runFolds(c, e, func(pos int, trainingSet, testingSet Examples) {
+		crossValidatedResults[pos] = f(trainingSet, testingSet)
+	})
+	if err != nil {
+		return 0, err
+	}
 	sum := 0.0
 	for _, instance := range crossValidatedResults {
 		sum += instance
 	}
-	return sum / float64(len(crossValidatedResults))
+	return sum / float64(len(crossValidatedResults)), nil
+}
+
+// CrossValidatedLearningCurve holds the learning curve of each fold on its
+// held-out examples, and the mean and standard deviation of every metric
+// across the folds after each epoch
+type CrossValidatedLearningCurve struct {
+	Folds  []*pb.TrainingResults `json:"folds"`
+	Mean   []*pb.EpochResult     `json:"mean"`
+	StdDev []*pb.EpochResult     `json:"stdDev"`
+}
+
+// epochMetrics are the accessors and setters of each EpochResult metric
+var epochMetrics = []struct {
+	get func(er *pb.EpochResult) float64
+	set func(er *pb.EpochResult, v float64)
+}{
+	{(*pb.EpochResult).GetRoc, func(er *pb.EpochResult, v float64) { er.Roc = proto.Float64(v) }},
+	{(*pb.EpochResult).GetLogScore, func(er *pb.EpochResult, v float64) { er.LogScore = proto.Float64(v) }},
+	{(*pb.EpochResult).GetNormalizedEntropy, func(er *pb.EpochResult, v float64) { er.NormalizedEntropy = proto.Float64(v) }},
+	{(*pb.EpochResult).GetCalibration, func(er *pb.EpochResult, v float64) { er.Calibration = proto.Float64(v) }},
+}
+
+// epochStatistics computes the mean and sample standard deviation of
+// every metric over the results
+func epochStatistics(results []*pb.EpochResult) (mean *pb.EpochResult, stdDev *pb.EpochResult) {
+	mean, stdDev = &pb.EpochResult{}, &pb.EpochResult{}
+	n := float64(len(results))
+	for _, m := range epochMetrics {
+		sum, sumSquares := 0.0, 0.0
+		for _, er := range results {
+			v := m.get(er)
+			sum += v
+			sumSquares += v * v
+		}
+		average := sum / n
+		variance := 0.0
+		if n > 1 {
+			variance = math.Max(0, (sumSquares-n*average*average)/(n-1))
+		}
+		m.set(mean, average)
+		m.set(stdDev, math.Sqrt(variance))
+	}
+	return mean, stdDev
+}
+
+// CrossValidateLearningCurve trains a forest with the given config on
+// the training set of each cross-validation split of the examples, and
+// computes the learning curve of each forest on its testing set.
+func CrossValidateLearningCurve(
+	config *pb.ForestConfig,
+	c CrossValidationConfig,
+	e Examples) (*CrossValidatedLearningCurve, error) {
+	if _, err := NewForestGenerator(config); err != nil {
+		return nil, err
+	}
+
+	result := &CrossValidatedLearningCurve{
+		Folds: make([]*pb.TrainingResults, c.NumFolds),
+	}
+	errs := make([]error, c.NumFolds)
+	err := runFolds(c, e, func(pos int, trainingSet, testingSet Examples) {
+		generator, _ := NewForestGenerator(config)
+		forest, err := generator.ConstructForest(trainingSet)
+		if err != nil {
+			errs[pos] = err
+			return
+		}
+		result.Folds[pos], errs[pos] = LearningCurve(forest, testingSet)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	numEpochs := len(result.Folds[0].GetEpochResults())
+	for _, fold := range result.Folds {
+		if len(fold.GetEpochResults()) < numEpochs {
+			numEpochs = len(fold.GetEpochResults())
+		}
+	}
+
+	for epoch := 0; epoch < numEpochs; epoch++ {
+		results := make([]*pb.EpochResult, 0, c.NumFolds)
+		for _, fold := range result.Folds {
+			results = append(results, fold.GetEpochResults()[epoch])
+		}
+		mean, stdDev := epochStatistics(results)
+		result.Mean = append(result.Mean, mean)
+		result.StdDev = append(result.StdDev, stdDev)
+	}
+	return result, nil
 }
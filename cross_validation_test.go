@@ -1,6 +1,7 @@
 package decisiontrees
 
 import (
+	"code.google.com/p/goprotobuf/proto"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"math"
 	"math/rand"
@@ -35,15 +36,70 @@ func TestCrossValidation(t *testing.T) {
 				math.Pow(average(trainingSet, testingSet), 2)))
 	}
 
-	crossValidatedAverage :=
-		runCrossValidation(10, examples, crossValidationFunc(average))
+	crossValidatedAverage, err :=
+		runCrossValidation(CrossValidationConfig{NumFolds: 10}, examples, crossValidationFunc(average))
+	if err != nil {
+		t.Fatal(err)
+	}
 	if math.Abs(crossValidatedAverage-0.5) > 0.02 {
 		t.Fatalf("Expected %v, got %v", 0.5, crossValidatedAverage)
 	}
 
-	crossValidatedStdDev :=
-		runCrossValidation(10, examples, crossValidationFunc(stdDev))
+	crossValidatedStdDev, err :=
+		runCrossValidation(CrossValidationConfig{NumFolds: 10}, examples, crossValidationFunc(stdDev))
+	if err != nil {
+		t.Fatal(err)
+	}
 	if math.Abs(crossValidatedStdDev-math.Sqrt(1.0/12.0)) > 0.01 {
 		t.Fatalf("Expected %v, got %v", math.Sqrt(1.0/12.0), crossValidatedStdDev)
 	}
 }
+
+func TestEpochStatistics(t *testing.T) {
+	mean, stdDev := epochStatistics([]*pb.EpochResult{
+		{Roc: proto.Float64(0.6), Calibration: proto.Float64(1.0)},
+		{Roc: proto.Float64(0.8), Calibration: proto.Float64(1.0)},
+	})
+	if math.Abs(mean.GetRoc()-0.7) > 1e-9 || math.Abs(stdDev.GetRoc()-math.Sqrt(0.02)) > 1e-9 {
+		t.Fatalf("Expected ROC 0.7 +/- %v, got %v +/- %v", math.Sqrt(0.02), mean.GetRoc(), stdDev.GetRoc())
+	}
+	if mean.GetCalibration() != 1.0 || stdDev.GetCalibration() != 0.0 {
+		t.Fatalf("Expected calibration 1.0 +/- 0.0, got %v +/- %v", mean.GetCalibration(), stdDev.GetCalibration())
+	}
+}
+
+func TestCrossValidateLearningCurve(t *testing.T) {
+	numFolds, numWeakLearners := 4, 5
+	config := &pb.ForestConfig{
+		NumWeakLearners: proto.Int64(int64(numWeakLearners)),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(2),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	}
+	curve, err := CrossValidateLearningCurve(config, CrossValidationConfig{NumFolds: numFolds}, constructBenchmarkExamples(200, 3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(curve.Folds) != numFolds {
+		t.Fatalf("Expected %v folds, got %v", numFolds, len(curve.Folds))
+	}
+
+	// One epoch per tree, including the prior
+	numEpochs := numWeakLearners + 1
+	if len(curve.Mean) != numEpochs || len(curve.StdDev) != numEpochs {
+		t.Fatalf("Expected %v epochs, got %v and %v", numEpochs, len(curve.Mean), len(curve.StdDev))
+	}
+	for epoch := range curve.Mean {
+		sum := 0.0
+		for _, fold := range curve.Folds {
+			sum += fold.GetEpochResults()[epoch].GetRoc()
+		}
+		if math.Abs(sum/float64(numFolds)-curve.Mean[epoch].GetRoc()) > 1e-9 {
+			t.Fatalf("Epoch %v: expected mean ROC %v, got %v", epoch, sum/float64(numFolds), curve.Mean[epoch].GetRoc())
+		}
+	}
+}
@@ -2,18 +2,37 @@ package main
 
 import (
 	"code.google.com/p/goprotobuf/proto"
+	"context"
 	"encoding/json"
 	"flag"
 	dt "github.com/ajtulloch/decisiontrees"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"github.com/golang/glog"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"strings"
 )
 
 var (
 	configPath    = flag.String("config", "dt.json", "")
 	trainDataPath = flag.String("train_data", "train_data.json", "")
+
+	calibrationMethod = flag.String(
+		"calibration_method",
+		"",
+		"PLATT or ISOTONIC - if set, the forest is calibrated on a held-out sample of the training data")
+	calibrationFraction = flag.Float64("calibration_fraction", 0.1, "")
+
+	cvFolds = flag.Int(
+		"cv_folds",
+		0,
+		"if set, output the cross-validated learning curve over this many folds of the training data rather than a forest")
+	cvFoldStrategy = flag.String("cv_fold_strategy", "random", "random, stratified, grouped, or time_series")
+
+	checkpointDir      = flag.String("checkpoint_dir", "", "if set, boosting checkpoints are written to this directory")
+	checkpointInterval = flag.Int("checkpoint_interval", 100, "number of boosting rounds between checkpoints")
+	resume             = flag.Bool("resume", false, "resume from the latest checkpoint in -checkpoint_dir")
 )
 
 func parseToProto(file string, protobuf proto.Message) error {
@@ -25,6 +44,55 @@ func parseToProto(file string, protobuf proto.Message) error {
 	return json.Unmarshal(f, protobuf)
 }
 
+// holdOut randomly splits the examples into a training set and a
+// held-out set containing the given fraction of the examples.  The split
+// is determined by the seed, so that resumed runs train on the same set.
+func holdOut(e []*pb.Example, fraction float64, seed int64) (train, heldOut []*pb.Example) {
+	numHeldOut := int(fraction * float64(len(e)))
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(e)) {
+		if i < numHeldOut {
+			heldOut = append(heldOut, e[j])
+		} else {
+			train = append(train, e[j])
+		}
+	}
+	return
+}
+
+// crossValidate writes the cross-validated learning curve of the config
+// on the examples to stdout
+func crossValidate(config *pb.ForestConfig, e []*pb.Example) {
+	strategy, err := dt.ParseFoldStrategy(*cvFoldStrategy)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	curve, err := dt.CrossValidateLearningCurve(
+		config,
+		dt.CrossValidationConfig{NumFolds: *cvFolds, Strategy: strategy},
+		e)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	bestEpoch := 0
+	for i, er := range curve.Mean {
+		if er.GetNormalizedEntropy() < curve.Mean[bestEpoch].GetNormalizedEntropy() {
+			bestEpoch = i
+		}
+	}
+	glog.Infof("Best mean normalized entropy %v +/- %v after %v trees",
+		curve.Mean[bestEpoch].GetNormalizedEntropy(),
+		curve.StdDev[bestEpoch].GetNormalizedEntropy(),
+		bestEpoch)
+
+	serializedCurve, err := json.MarshalIndent(curve, "", "  ")
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serializedCurve)
+}
+
 func main() {
 	flag.Parse()
 	trainData := &pb.TrainingData{}
@@ -42,13 +110,59 @@ func main() {
 		glog.Fatal(err)
 	}
 	glog.Infof("Loaded forest config %+v", config)
+	if err := dt.ValidateForestConfig(config, trainData); err != nil {
+		glog.Fatal(err)
+	}
+
+	if *cvFolds > 0 {
+		crossValidate(config, trainData.GetTrain())
+		return
+	}
 
 	generator, err := dt.NewForestGenerator(config)
 	if err != nil {
 		glog.Fatal(err)
 	}
-	forest := generator.ConstructForest(trainData.GetTrain())
-	learningCurve := dt.LearningCurve(forest, trainData.GetTest())
+	train := trainData.GetTrain()
+	var calibrationSet []*pb.Example
+	method, ok := pb.CalibrationMethod_value[strings.ToUpper(*calibrationMethod)]
+	if *calibrationMethod != "" {
+		if !ok {
+			glog.Fatalf("Unknown calibration method: %v", *calibrationMethod)
+		}
+		train, calibrationSet = holdOut(train, *calibrationFraction, config.GetSeed())
+	}
+
+	var forest *pb.Forest
+	if *checkpointDir != "" {
+		forest, err = dt.ConstructForestWithCheckpoints(
+			context.Background(),
+			config,
+			train,
+			dt.CheckpointConfig{
+				Directory: *checkpointDir,
+				Interval:  *checkpointInterval,
+				Resume:    *resume,
+			},
+			nil)
+	} else {
+		forest, err = generator.ConstructForest(train)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *calibrationMethod != "" {
+		forest, err = dt.CalibrateForest(forest, calibrationSet, pb.CalibrationMethod(method))
+		if err != nil {
+			glog.Fatal(err)
+		}
+		glog.Infof("Calibrated forest with %+v", forest.GetCalibrator())
+	}
+
+	learningCurve, err := dt.LearningCurve(forest, trainData.GetTest())
+	if err != nil {
+		glog.Fatal(err)
+	}
 
 	glog.Infof("Learning curve: %+v", learningCurve)
 
@@ -0,0 +1,392 @@
+package distributed
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"context"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"net/rpc"
+	"sync"
+)
+
+const (
+	// maxBins is the most bins a feature can be split into, as workers
+	// store bins in a byte
+	maxBins = 256
+	// DefaultMaxBins is the number of bins used if none is configured
+	DefaultMaxBins = 64
+	// DefaultMaxSummaryValues is the number of values of each feature a
+	// worker sends to compute the bin thresholds if none is configured
+	DefaultMaxSummaryValues = 4096
+)
+
+// Coordinator trains boosted forests on the examples held by a set of
+// workers.  Each round, the workers send gradient histograms for the
+// nodes being split, and the coordinator chooses the splits and sends
+// them back, so examples never leave the workers.
+type Coordinator struct {
+	workers []*rpc.Client
+
+	// MaxBins is the number of bins each feature is split into, at most
+	// 256
+	MaxBins int
+	// MaxSummaryValues is the number of values of each feature each worker
+	// sends to compute the bin thresholds.  The thresholds are exact
+	// quantiles if no shard has more distinct values than this.
+	MaxSummaryValues int
+}
+
+// Dial returns a coordinator connected to the workers at the addresses
+func Dial(addresses []string) (*Coordinator, error) {
+	c := &Coordinator{
+		MaxBins:          DefaultMaxBins,
+		MaxSummaryValues: DefaultMaxSummaryValues,
+	}
+	for _, address := range addresses {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.workers = append(c.workers, client)
+	}
+	return c, nil
+}
+
+// Close closes the connections to the workers
+func (c *Coordinator) Close() error {
+	var result error
+	for _, w := range c.workers {
+		if err := w.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// callAll makes the call on every worker in parallel, with reply(i)
+// returning the reply for the i'th worker
+func (c *Coordinator) callAll(method string, args interface{}, reply func(i int) interface{}) error {
+	errors := make([]error, len(c.workers))
+	w := sync.WaitGroup{}
+	for i, client := range c.workers {
+		w.Add(1)
+		go func(i int, client *rpc.Client) {
+			defer w.Done()
+			errors[i] = client.Call("Worker."+method, args, reply(i))
+		}(i, client)
+	}
+	w.Wait()
+	for i, err := range errors {
+		if err != nil {
+			return fmt.Errorf("worker %v: %v failed: %v", i, method, err)
+		}
+	}
+	return nil
+}
+
+func emptyReply(i int) interface{} {
+	return &Empty{}
+}
+
+// initializeWorkers bins the features of every worker, and returns the
+// thresholds of each feature and the prior
+func (c *Coordinator) initializeWorkers() ([][]float64, float64, error) {
+	if c.MaxBins < 2 || c.MaxBins > maxBins {
+		return nil, 0, fmt.Errorf("MaxBins must be in [2, %v], got %v", maxBins, c.MaxBins)
+	}
+
+	summaries := make([]SummarizeReply, len(c.workers))
+	err := c.callAll("Summarize", SummarizeArgs{MaxValues: c.MaxSummaryValues}, func(i int) interface{} {
+		return &summaries[i]
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	numExamples, sumLabels := int64(0), 0.0
+	numFeatures := summaries[0].Shard.NumFeatures
+	for i, s := range summaries {
+		if s.Shard.NumFeatures != numFeatures {
+			return nil, 0, fmt.Errorf("worker %v has %v features, expected %v", i, s.Shard.NumFeatures, numFeatures)
+		}
+		numExamples += s.Shard.NumExamples
+		sumLabels += s.Shard.SumLabels
+	}
+	glog.Infof("Training on %v examples with %v features across %v workers", numExamples, numFeatures, len(c.workers))
+
+	thresholds := make([][]float64, numFeatures)
+	for f := range thresholds {
+		featureSummaries := make([]WeightedValues, len(summaries))
+		for i, s := range summaries {
+			featureSummaries[i] = s.Features[f]
+		}
+		thresholds[f] = binThresholds(featureSummaries, c.MaxBins)
+	}
+
+	prior := logitPrior(sumLabels, numExamples)
+	err = c.callAll("SetBins", SetBinsArgs{Thresholds: thresholds, Prior: prior}, emptyReply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return thresholds, prior, nil
+}
+
+// Train constructs a boosted forest with the LOGIT loss on the examples
+// held by the workers, without sampling or influence trimming.  The forest has the same format as one trained
+// in-process, with split values chosen from the bin thresholds.  If the
+// context is cancelled, Train returns the trees completed so far along
+// with the error.
+func (c *Coordinator) Train(ctx context.Context, config *pb.ForestConfig) (*pb.Forest, error) {
+	if err := dt.ValidateForestConfig(config, nil); err != nil {
+		return nil, err
+	}
+	if config.GetAlgorithm() != pb.Algorithm_BOOSTING {
+		return nil, &dt.ConfigError{
+			Field:  "algorithm",
+			Reason: fmt.Sprintf("distributed training only supports boosting, got %v", config.GetAlgorithm()),
+		}
+	}
+	if config.GetLossFunctionConfig().GetLossFunction() != pb.LossFunction_LOGIT {
+		return nil, &dt.ConfigError{
+			Field: "lossFunctionConfig.lossFunction",
+			Reason: fmt.Sprintf("distributed training only supports LOGIT, got %v",
+				config.GetLossFunctionConfig().GetLossFunction()),
+		}
+	}
+	if config.GetStochasticityConfig() != nil {
+		return nil, &dt.ConfigError{
+			Field:  "stochasticityConfig",
+			Reason: "distributed training does not support example or feature sampling",
+		}
+	}
+	if config.GetInfluenceTrimmingConfig() != nil {
+		return nil, &dt.ConfigError{
+			Field:  "influenceTrimmingConfig",
+			Reason: "distributed training does not support influence trimming",
+		}
+	}
+	if len(c.workers) == 0 {
+		return nil, fmt.Errorf("no workers to train on")
+	}
+
+	thresholds, prior, err := c.initializeWorkers()
+	if err != nil {
+		return nil, err
+	}
+
+	forest := &pb.Forest{
+		Trees:     make([]*pb.TreeNode, 0, config.GetNumWeakLearners()+1),
+		Rescaling: pb.Rescaling_LOG_ODDS.Enum(),
+	}
+	forest.Trees = append(forest.Trees, &pb.TreeNode{
+		LeafValue: proto.Float64(prior),
+	})
+
+	b := &treeBuilder{
+		coordinator:          c,
+		thresholds:           thresholds,
+		splittingConstraints: config.GetSplittingConstraints(),
+		shrinkage:            1.0,
+	}
+	if config.GetShrinkageConfig() != nil && config.GetShrinkageConfig().Shrinkage != nil {
+		b.shrinkage = config.GetShrinkageConfig().GetShrinkage()
+	}
+
+	for i := 0; i < int(config.GetNumWeakLearners()); i++ {
+		if err := ctx.Err(); err != nil {
+			glog.Infof("Stopping after %v boosting rounds: %v", i, err)
+			return forest, err
+		}
+		glog.Infof("Running boosting round %v", i)
+		tree, err := b.buildTree()
+		if err != nil {
+			return forest, &dt.TrainingError{Round: i, Err: err}
+		}
+		forest.Trees = append(forest.Trees, tree)
+	}
+	return forest, nil
+}
+
+// treeBuilder grows a tree level by level from the workers' histograms
+type treeBuilder struct {
+	coordinator          *Coordinator
+	thresholds           [][]float64
+	splittingConstraints *pb.SplittingConstraints
+	shrinkage            float64
+}
+
+// growingNode is a node of the tree being built, with the statistics of
+// its examples across every worker
+type growingNode struct {
+	id    int32
+	node  *pb.TreeNode
+	stats Bin
+}
+
+type histogramSplit struct {
+	feature int
+	bin     int
+	gain    float64
+	left    Bin
+}
+
+// squaredDivergence returns the sum of squared divergences of the
+// gradients from their mean, up to a term constant across splits
+func squaredDivergence(b Bin) float64 {
+	if b.Count == 0 {
+		return 0
+	}
+	return -b.Gradient * b.Gradient / float64(b.Count)
+}
+
+// bestSplit returns the split of the node with the largest reduction in
+// squared divergence, breaking ties by the lowest feature then bin
+func bestSplit(h Histogram, total Bin) histogramSplit {
+	best := histogramSplit{}
+	for f, bins := range h {
+		left := Bin{}
+		for b := 1; b < len(bins); b++ {
+			left.add(bins[b-1])
+			right := total.sub(left)
+			if left.Count == 0 || right.Count == 0 {
+				continue
+			}
+			gain := squaredDivergence(total) - squaredDivergence(left) - squaredDivergence(right)
+			if gain > best.gain {
+				best = histogramSplit{feature: f, bin: b, gain: gain, left: left}
+			}
+		}
+	}
+	return best
+}
+
+func (b *treeBuilder) shouldSplit(n *growingNode, s histogramSplit, currentLevel int64) bool {
+	if n.stats.Count <= 1 || s.gain <= 0 {
+		return false
+	}
+
+	maximumLevels := b.splittingConstraints.MaximumLevels
+	if maximumLevels != nil && *maximumLevels < currentLevel {
+		return false
+	}
+
+	minAverageGain := b.splittingConstraints.MinimumAverageGain
+	if minAverageGain != nil && *minAverageGain > s.gain/float64(n.stats.Count) {
+		return false
+	}
+
+	minSamplesAtLeaf := b.splittingConstraints.MinimumSamplesAtLeaf
+	if minSamplesAtLeaf != nil && *minSamplesAtLeaf > n.stats.Count {
+		return false
+	}
+	return true
+}
+
+// histograms returns the sum of the histograms of the nodes across every
+// worker
+func (b *treeBuilder) histograms(nodes []*growingNode) ([]Histogram, error) {
+	args := HistogramsArgs{Nodes: make([]int32, len(nodes))}
+	for i, n := range nodes {
+		args.Nodes[i] = n.id
+	}
+	replies := make([]HistogramsReply, len(b.coordinator.workers))
+	err := b.coordinator.callAll("Histograms", args, func(i int) interface{} {
+		return &replies[i]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sum in worker order, so the result does not depend on which worker
+	// replies first
+	result := replies[0].Histograms
+	for _, r := range replies[1:] {
+		if len(r.Histograms) != len(result) {
+			return nil, fmt.Errorf("got %v histograms, expected %v", len(r.Histograms), len(result))
+		}
+		for i := range result {
+			result[i].add(r.Histograms[i])
+		}
+	}
+	return result, nil
+}
+
+func (b *treeBuilder) leafValue(stats Bin) float64 {
+	if stats.Hessian == 0 {
+		return 0
+	}
+	return b.shrinkage * stats.Gradient / stats.Hessian
+}
+
+// buildTree grows a tree on the current gradients of the workers, and
+// updates the workers' margins with it
+func (b *treeBuilder) buildTree() (*pb.TreeNode, error) {
+	c := b.coordinator
+	if err := c.callAll("StartTree", Empty{}, emptyReply); err != nil {
+		return nil, err
+	}
+
+	root := &growingNode{id: 0, node: &pb.TreeNode{}}
+	active := []*growingNode{root}
+	leafValues := make(map[int32]float64)
+	nextID := int32(1)
+	for level := int64(0); len(active) > 0; level++ {
+		histograms, err := b.histograms(active)
+		if err != nil {
+			return nil, err
+		}
+
+		splits := make([]NodeSplit, 0)
+		children := make([]*growingNode, 0)
+		for i, n := range active {
+			n.stats = histograms[i].total()
+			s := bestSplit(histograms[i], n.stats)
+			if !b.shouldSplit(n, s, level) {
+				glog.V(2).Infof("Terminating at level %v with %v examples", level, n.stats.Count)
+				n.node.LeafValue = proto.Float64(b.leafValue(n.stats))
+				n.node.Annotation = &pb.Annotation{
+					NumExamples: proto.Int64(n.stats.Count),
+				}
+				leafValues[n.id] = n.node.GetLeafValue()
+				continue
+			}
+
+			glog.V(2).Infof("Splitting at level %v on feature %v with gain %v", level, s.feature, s.gain)
+			left := &growingNode{id: nextID, node: &pb.TreeNode{}}
+			right := &growingNode{id: nextID + 1, node: &pb.TreeNode{}}
+			nextID += 2
+			n.node.Feature = proto.Int64(int64(s.feature))
+			n.node.SplitValue = proto.Float64(b.thresholds[s.feature][s.bin-1])
+			n.node.Left = left.node
+			n.node.Right = right.node
+			n.node.Annotation = &pb.Annotation{
+				NumExamples:  proto.Int64(n.stats.Count),
+				AverageGain:  proto.Float64(s.gain / float64(n.stats.Count)),
+				LeftFraction: proto.Float64(float64(s.left.Count) / float64(n.stats.Count)),
+			}
+			splits = append(splits, NodeSplit{
+				Node:    n.id,
+				Feature: s.feature,
+				Bin:     s.bin,
+				Left:    left.id,
+				Right:   right.id,
+			})
+			children = append(children, left, right)
+		}
+
+		if len(splits) > 0 {
+			if err := c.callAll("Split", SplitArgs{Splits: splits}, emptyReply); err != nil {
+				return nil, err
+			}
+		}
+		active = children
+	}
+
+	if err := c.callAll("FinishTree", FinishTreeArgs{LeafValues: leafValues}, emptyReply); err != nil {
+		return nil, err
+	}
+	return root.node, nil
+}
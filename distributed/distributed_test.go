@@ -0,0 +1,280 @@
+package distributed
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"context"
+	"encoding/json"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const helperShardEnv = "DISTRIBUTED_TEST_SHARD"
+
+// TestHelperWorker is not a test - it serves the shard named by the
+// environment when the test binary is run as a worker process
+func TestHelperWorker(t *testing.T) {
+	shard := os.Getenv(helperShardEnv)
+	if shard == "" {
+		return
+	}
+	w, err := LoadShard(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString(l.Addr().String() + "\n")
+	Serve(l, w)
+	os.Exit(0)
+}
+
+// startWorkerProcess runs the shard in a worker process, returning its
+// address
+func startWorkerProcess(t *testing.T, shard string) (string, *exec.Cmd) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWorker$")
+	cmd.Env = append(os.Environ(), helperShardEnv+"="+shard)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("worker for %v did not start: %v", shard, err)
+	}
+	return strings.TrimSpace(address), cmd
+}
+
+// startWorker serves the examples from a worker in this process,
+// returning its address
+func startWorker(t *testing.T, examples []*pb.Example) (string, net.Listener) {
+	w, err := NewWorker(examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go Serve(l, w)
+	return l.Addr().String(), l
+}
+
+func constructExamples(numExamples int, numFeatures int) []*pb.Example {
+	rng := rand.New(rand.NewSource(1))
+	result := make([]*pb.Example, 0, numExamples)
+	for i := 0; i < numExamples; i++ {
+		features := make([]float64, numFeatures)
+		for f := range features {
+			features[f] = rng.Float64()
+		}
+		label := -1.0
+		if features[0]+features[1]+0.2*rng.NormFloat64() > 1 {
+			label = 1.0
+		}
+		result = append(result, &pb.Example{
+			Features: features,
+			Label:    proto.Float64(label),
+		})
+	}
+	return result
+}
+
+func testConfig() *pb.ForestConfig {
+	return &pb.ForestConfig{
+		NumWeakLearners: proto.Int64(10),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(2),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		ShrinkageConfig: &pb.ShrinkageConfig{
+			Shrinkage: proto.Float64(0.5),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	}
+}
+
+func train(t *testing.T, addresses []string) *pb.Forest {
+	c, err := Dial(addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.MaxBins = 32
+	forest, err := c.Train(context.Background(), testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forest
+}
+
+func compareTrees(t *testing.T, path string, expected, actual *pb.TreeNode) {
+	if (expected.GetLeft() == nil) != (actual.GetLeft() == nil) {
+		t.Fatalf("%v: expected %v, got %v", path, expected, actual)
+	}
+	if expected.GetLeft() == nil {
+		if math.Abs(expected.GetLeafValue()-actual.GetLeafValue()) > 1e-9 {
+			t.Errorf("%v: expected leaf value %v, got %v", path, expected.GetLeafValue(), actual.GetLeafValue())
+		}
+		return
+	}
+	if expected.GetFeature() != actual.GetFeature() || expected.GetSplitValue() != actual.GetSplitValue() {
+		t.Fatalf("%v: expected split on %v at %v, got %v at %v",
+			path, expected.GetFeature(), expected.GetSplitValue(), actual.GetFeature(), actual.GetSplitValue())
+	}
+	compareTrees(t, path+"L", expected.GetLeft(), actual.GetLeft())
+	compareTrees(t, path+"R", expected.GetRight(), actual.GetRight())
+}
+
+func TestMultiProcessTrainingMatchesSingleWorker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts worker processes")
+	}
+	dir, err := ioutil.TempDir("", "shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	examples := constructExamples(600, 4)
+	numShards := 3
+	addresses := make([]string, 0, numShards)
+	for i := 0; i < numShards; i++ {
+		shard := &pb.TrainingData{}
+		for j := i; j < len(examples); j += numShards {
+			shard.Train = append(shard.Train, examples[j])
+		}
+		serialized, err := json.Marshal(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("shard-%v.json", i))
+		if err := ioutil.WriteFile(path, serialized, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		address, cmd := startWorkerProcess(t, path)
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+		addresses = append(addresses, address)
+	}
+	distributed := train(t, addresses)
+
+	address, l := startWorker(t, examples)
+	defer l.Close()
+	single := train(t, []string{address})
+
+	if len(distributed.GetTrees()) != 11 || len(single.GetTrees()) != 11 {
+		t.Fatalf("expected 11 trees, got %v and %v", len(distributed.GetTrees()), len(single.GetTrees()))
+	}
+	for i := range single.GetTrees() {
+		compareTrees(t, fmt.Sprintf("tree %v: ", i), single.GetTrees()[i], distributed.GetTrees()[i])
+	}
+
+	evaluator, err := dt.NewRescaledFastForestEvaluator(distributed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numCorrect := 0
+	for _, ex := range examples {
+		if (evaluator.Evaluate(ex.GetFeatures()) > 0.5) == (ex.GetLabel() > 0) {
+			numCorrect++
+		}
+	}
+	if accuracy := float64(numCorrect) / float64(len(examples)); accuracy < 0.85 {
+		t.Errorf("expected training accuracy of at least 0.85, got %v", accuracy)
+	}
+}
+
+func TestTrainRejectsUnsupportedLoss(t *testing.T) {
+	address, l := startWorker(t, constructExamples(10, 2))
+	defer l.Close()
+	c, err := Dial([]string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	config := testConfig()
+	config.LossFunctionConfig.LossFunction = pb.LossFunction_HUBER.Enum()
+	_, err = c.Train(context.Background(), config)
+	if e, ok := err.(*dt.ConfigError); !ok || e.Field != "lossFunctionConfig.lossFunction" {
+		t.Errorf("expected a loss function ConfigError, got %v", err)
+	}
+}
+
+func TestTrainRejectsUnsupportedConfigs(t *testing.T) {
+	address, l := startWorker(t, constructExamples(10, 2))
+	defer l.Close()
+	c, err := Dial([]string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	stochastic := testConfig()
+	stochastic.StochasticityConfig = &pb.StochasticityConfig{
+		PerRoundSamplingRate: proto.Float64(0.5),
+	}
+	trimmed := testConfig()
+	trimmed.InfluenceTrimmingConfig = &pb.InfluenceTrimmingConfig{
+		Alpha:        proto.Float64(0.1),
+		WarmupRounds: proto.Int64(2),
+	}
+
+	for field, config := range map[string]*pb.ForestConfig{
+		"stochasticityConfig":     stochastic,
+		"influenceTrimmingConfig": trimmed,
+	} {
+		_, err = c.Train(context.Background(), config)
+		if e, ok := err.(*dt.ConfigError); !ok || e.Field != field {
+			t.Errorf("expected a %v ConfigError, got %v", field, err)
+		}
+	}
+}
+
+func TestBinThresholdsIndependentOfSharding(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(i % 40)
+	}
+	whole := binThresholds([]WeightedValues{summarize(values, 1000)}, 8)
+	sharded := binThresholds([]WeightedValues{
+		summarize(values[:30], 1000),
+		summarize(values[30:], 1000),
+	}, 8)
+	if len(whole) == 0 || len(whole) > 7 {
+		t.Fatalf("expected between 1 and 7 thresholds, got %v", whole)
+	}
+	for i := range whole {
+		if i >= len(sharded) || whole[i] != sharded[i] {
+			t.Fatalf("expected %v, got %v", whole, sharded)
+		}
+		if i > 0 && whole[i] <= whole[i-1] {
+			t.Errorf("thresholds %v are not increasing", whole)
+		}
+	}
+
+	few := binThresholds([]WeightedValues{summarize([]float64{1, 1, 2, 3}, 1000)}, 8)
+	if len(few) != 2 || few[0] != 1.5 || few[1] != 2.5 {
+		t.Errorf("expected midpoint thresholds [1.5 2.5], got %v", few)
+	}
+}
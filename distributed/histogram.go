@@ -0,0 +1,169 @@
+package distributed
+
+import (
+	"math"
+	"sort"
+)
+
+// Bin accumulates the gradient statistics of the examples whose feature
+// value falls in a histogram bin
+type Bin struct {
+	Count    int64
+	Gradient float64
+	Hessian  float64
+}
+
+func (b *Bin) add(o Bin) {
+	b.Count += o.Count
+	b.Gradient += o.Gradient
+	b.Hessian += o.Hessian
+}
+
+func (b Bin) sub(o Bin) Bin {
+	return Bin{
+		Count:    b.Count - o.Count,
+		Gradient: b.Gradient - o.Gradient,
+		Hessian:  b.Hessian - o.Hessian,
+	}
+}
+
+// Histogram holds the bins of every feature for a single tree node,
+// indexed by feature then bin
+type Histogram [][]Bin
+
+func (h Histogram) add(o Histogram) {
+	for f := range h {
+		for b := range h[f] {
+			h[f][b].add(o[f][b])
+		}
+	}
+}
+
+// total returns the statistics of every example in the node
+func (h Histogram) total() Bin {
+	result := Bin{}
+	if len(h) > 0 {
+		for _, b := range h[0] {
+			result.add(b)
+		}
+	}
+	return result
+}
+
+// WeightedValues is a summary of the values of a feature on a shard, with
+// each value standing in for Weights[i] examples
+type WeightedValues struct {
+	Values  []float64
+	Weights []float64
+}
+
+// summarize returns the distinct values with their counts if there are
+// at most maxValues of them, and maxValues evenly spaced order statistics
+// otherwise
+func summarize(values []float64, maxValues int) WeightedValues {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	distinct := WeightedValues{}
+	for i, v := range sorted {
+		if i > 0 && v == sorted[i-1] {
+			distinct.Weights[len(distinct.Weights)-1]++
+			continue
+		}
+		distinct.Values = append(distinct.Values, v)
+		distinct.Weights = append(distinct.Weights, 1)
+	}
+	if len(distinct.Values) <= maxValues {
+		return distinct
+	}
+
+	result := WeightedValues{}
+	weight := float64(len(sorted)) / float64(maxValues)
+	for i := 0; i < maxValues; i++ {
+		result.Values = append(result.Values, sorted[int(float64(i)*weight)])
+		result.Weights = append(result.Weights, weight)
+	}
+	return result
+}
+
+// binThresholds merges the summaries of a feature from every shard, and
+// returns at most maxBins - 1 increasing thresholds.  A value x falls in
+// bin b if exactly b thresholds are at most x.
+func binThresholds(summaries []WeightedValues, maxBins int) []float64 {
+	type weightedValue struct {
+		value  float64
+		weight float64
+	}
+	merged := make([]weightedValue, 0)
+	totalWeight := 0.0
+	for _, s := range summaries {
+		for i := range s.Values {
+			merged = append(merged, weightedValue{s.Values[i], s.Weights[i]})
+			totalWeight += s.Weights[i]
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].value < merged[j].value })
+
+	// Combine the weights of equal values, so that the thresholds do not
+	// depend on how the examples are sharded
+	distinct := make([]weightedValue, 0)
+	for i, w := range merged {
+		if i > 0 && w.value == merged[i-1].value {
+			distinct[len(distinct)-1].weight += w.weight
+			continue
+		}
+		distinct = append(distinct, w)
+	}
+
+	// Few enough values for a bin each - split halfway between them
+	if len(distinct) <= maxBins {
+		thresholds := make([]float64, 0, len(distinct))
+		for i := 1; i < len(distinct); i++ {
+			thresholds = append(thresholds, 0.5*(distinct[i-1].value+distinct[i].value))
+		}
+		return thresholds
+	}
+
+	// Otherwise, split after the values at which each weighted quantile is
+	// reached
+	thresholds := make([]float64, 0, maxBins-1)
+	cumulative, next := 0.0, 1
+	for i := 0; i+1 < len(distinct) && next < maxBins; i++ {
+		cumulative += distinct[i].weight
+		if cumulative < float64(next)*totalWeight/float64(maxBins) {
+			continue
+		}
+		thresholds = append(thresholds, distinct[i+1].value)
+		for next < maxBins && cumulative >= float64(next)*totalWeight/float64(maxBins) {
+			next++
+		}
+	}
+	return thresholds
+}
+
+// binIndex returns the bin of the value given the thresholds
+func binIndex(thresholds []float64, value float64) int {
+	return sort.Search(len(thresholds), func(i int) bool { return thresholds[i] > value })
+}
+
+// logitGradients returns the weighted label and Newton step denominator
+// of the logit loss, matching the boosting trainer
+func logitGradients(label, margin float64) (gradient float64, hessian float64) {
+	gradient = 2 * label / (1 + math.Exp(2*label*margin))
+	hessian = math.Abs(gradient) * (2 - math.Abs(gradient))
+	return
+}
+
+const (
+	minLogitPrior = -20.0
+	maxLogitPrior = 20.0
+)
+
+// logitPrior returns the initial margin for the average label, matching
+// the boosting trainer
+func logitPrior(sumLabels float64, numExamples int64) float64 {
+	averageLabel := sumLabels / float64(numExamples)
+	prior := 0.5 * math.Log((1+averageLabel)/(1-averageLabel))
+	return math.Max(minLogitPrior, math.Min(maxLogitPrior, prior))
+}
@@ -0,0 +1,291 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// ShardSummary describes the examples held by a worker
+type ShardSummary struct {
+	NumExamples int64
+	NumFeatures int
+	SumLabels   float64
+}
+
+// SummarizeArgs are the arguments of Worker.Summarize
+type SummarizeArgs struct {
+	// MaxValues is the largest number of values returned for a feature
+	MaxValues int
+}
+
+// SummarizeReply is the reply of Worker.Summarize
+type SummarizeReply struct {
+	Shard    ShardSummary
+	Features []WeightedValues
+}
+
+// SetBinsArgs are the arguments of Worker.SetBins
+type SetBinsArgs struct {
+	// Thresholds are the bin thresholds of each feature
+	Thresholds [][]float64
+	// Prior is the initial margin of every example
+	Prior float64
+}
+
+// HistogramsArgs are the arguments of Worker.Histograms
+type HistogramsArgs struct {
+	Nodes []int32
+}
+
+// HistogramsReply is the reply of Worker.Histograms, with a histogram for
+// each of the requested nodes
+type HistogramsReply struct {
+	Histograms []Histogram
+}
+
+// NodeSplit sends the examples of a node with feature bin below Bin to
+// the Left node, and the remainder to the Right node
+type NodeSplit struct {
+	Node    int32
+	Feature int
+	Bin     int
+	Left    int32
+	Right   int32
+}
+
+// SplitArgs are the arguments of Worker.Split
+type SplitArgs struct {
+	Splits []NodeSplit
+}
+
+// FinishTreeArgs are the arguments of Worker.FinishTree
+type FinishTreeArgs struct {
+	// LeafValues maps each leaf node to its value
+	LeafValues map[int32]float64
+}
+
+// Empty is the argument or reply of RPCs that carry no data
+type Empty struct{}
+
+// Worker holds a shard of the training examples, and serves the gradient
+// histograms of the shard to a Coordinator.  Raw feature values are only
+// kept until the coordinator sends the bin thresholds.
+type Worker struct {
+	mu sync.Mutex
+
+	labels   []float64
+	features [][]float64 // indexed by feature then example
+
+	bins      [][]uint8 // indexed by feature then example
+	numBins   []int
+	margins   []float64
+	gradients []float64
+	hessians  []float64
+	nodes     []int32
+}
+
+// NewWorker returns a worker holding the examples
+func NewWorker(examples []*pb.Example) (*Worker, error) {
+	if len(examples) == 0 {
+		return nil, dt.ErrNoExamples
+	}
+	w := &Worker{labels: make([]float64, len(examples))}
+
+	numFeatures := len(examples[0].GetFeatures())
+	w.features = make([][]float64, numFeatures)
+	for f := range w.features {
+		w.features[f] = make([]float64, len(examples))
+	}
+	for i, ex := range examples {
+		if len(ex.GetFeatures()) != numFeatures {
+			return nil, fmt.Errorf("example %v has %v features, expected %v", i, len(ex.GetFeatures()), numFeatures)
+		}
+		w.labels[i] = ex.GetLabel()
+		for f, value := range ex.GetFeatures() {
+			w.features[f][i] = value
+		}
+	}
+	return w, nil
+}
+
+// LoadShard returns a worker holding the training examples of the
+// TrainingData serialized as JSON in the file
+func LoadShard(filename string) (*Worker, error) {
+	serialized, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	trainingData := &pb.TrainingData{}
+	if err := json.Unmarshal(serialized, trainingData); err != nil {
+		return nil, err
+	}
+	glog.Infof("Loaded %v examples from %v", len(trainingData.GetTrain()), filename)
+	return NewWorker(trainingData.GetTrain())
+}
+
+// Serve registers the worker with an RPC server and serves connections
+// accepted on the listener until it is closed
+func Serve(l net.Listener, w *Worker) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Worker", w); err != nil {
+		return err
+	}
+	server.Accept(l)
+	return nil
+}
+
+// Summarize returns the label statistics of the shard, and a summary of
+// the values of each feature
+func (w *Worker) Summarize(args SummarizeArgs, reply *SummarizeReply) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bins != nil {
+		return fmt.Errorf("worker has already been binned")
+	}
+
+	reply.Shard = ShardSummary{
+		NumExamples: int64(len(w.labels)),
+		NumFeatures: len(w.features),
+	}
+	for _, label := range w.labels {
+		reply.Shard.SumLabels += label
+	}
+	reply.Features = make([]WeightedValues, len(w.features))
+	for f, values := range w.features {
+		reply.Features[f] = summarize(values, args.MaxValues)
+	}
+	return nil
+}
+
+// SetBins replaces the feature values of the shard with their bins, and
+// initializes the margin of every example to the prior
+func (w *Worker) SetBins(args SetBinsArgs, reply *Empty) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(args.Thresholds) != len(w.features) {
+		return fmt.Errorf("got thresholds for %v features, shard has %v", len(args.Thresholds), len(w.features))
+	}
+
+	w.bins = make([][]uint8, len(w.features))
+	w.numBins = make([]int, len(w.features))
+	for f, values := range w.features {
+		if len(args.Thresholds[f]) > maxBins-1 {
+			return fmt.Errorf("feature %v has %v thresholds, at most %v are supported", f, len(args.Thresholds[f]), maxBins-1)
+		}
+		w.numBins[f] = len(args.Thresholds[f]) + 1
+		w.bins[f] = make([]uint8, len(values))
+		for i, value := range values {
+			w.bins[f][i] = uint8(binIndex(args.Thresholds[f], value))
+		}
+	}
+	w.features = nil
+
+	w.margins = make([]float64, len(w.labels))
+	for i := range w.margins {
+		w.margins[i] = args.Prior
+	}
+	w.gradients = make([]float64, len(w.labels))
+	w.hessians = make([]float64, len(w.labels))
+	w.nodes = make([]int32, len(w.labels))
+	return nil
+}
+
+// StartTree computes the gradients of the logit loss at the current
+// margins, and places every example in the root node
+func (w *Worker) StartTree(args Empty, reply *Empty) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bins == nil {
+		return fmt.Errorf("worker has not been binned")
+	}
+
+	for i, label := range w.labels {
+		w.gradients[i], w.hessians[i] = logitGradients(label, w.margins[i])
+		w.nodes[i] = 0
+	}
+	return nil
+}
+
+// Histograms returns the gradient histograms of the examples in each of
+// the nodes
+func (w *Worker) Histograms(args HistogramsArgs, reply *HistogramsReply) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bins == nil {
+		return fmt.Errorf("worker has not been binned")
+	}
+
+	position := make(map[int32]int, len(args.Nodes))
+	reply.Histograms = make([]Histogram, len(args.Nodes))
+	for i, node := range args.Nodes {
+		position[node] = i
+		reply.Histograms[i] = make(Histogram, len(w.bins))
+		for f := range w.bins {
+			reply.Histograms[i][f] = make([]Bin, w.numBins[f])
+		}
+	}
+
+	for i, node := range w.nodes {
+		p, ok := position[node]
+		if !ok {
+			continue
+		}
+		h := reply.Histograms[p]
+		for f := range w.bins {
+			b := &h[f][w.bins[f][i]]
+			b.Count++
+			b.Gradient += w.gradients[i]
+			b.Hessian += w.hessians[i]
+		}
+	}
+	return nil
+}
+
+// Split moves the examples of each split node to its children
+func (w *Worker) Split(args SplitArgs, reply *Empty) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	splits := make(map[int32]NodeSplit, len(args.Splits))
+	for _, s := range args.Splits {
+		if s.Feature < 0 || s.Feature >= len(w.bins) {
+			return fmt.Errorf("split of node %v on feature %v, shard has %v features", s.Node, s.Feature, len(w.bins))
+		}
+		splits[s.Node] = s
+	}
+	for i, node := range w.nodes {
+		s, ok := splits[node]
+		if !ok {
+			continue
+		}
+		if int(w.bins[s.Feature][i]) < s.Bin {
+			w.nodes[i] = s.Left
+		} else {
+			w.nodes[i] = s.Right
+		}
+	}
+	return nil
+}
+
+// FinishTree adds the value of the leaf holding each example to its
+// margin
+func (w *Worker) FinishTree(args FinishTreeArgs, reply *Empty) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, node := range w.nodes {
+		value, ok := args.LeafValues[node]
+		if !ok {
+			return fmt.Errorf("example %v is in node %v, which is not a leaf", i, node)
+		}
+		w.margins[i] += value
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ajtulloch/decisiontrees/distributed"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+var (
+	mode = flag.String("mode", "coordinator", "worker or coordinator")
+
+	// Worker flags
+	listenAddress = flag.String("listen", ":7070", "address the worker serves on")
+	shardPath     = flag.String("shard", "shard.json", "TrainingData holding the worker's training examples")
+
+	// Coordinator flags
+	workers          = flag.String("workers", "", "comma-separated addresses of the workers")
+	configPath       = flag.String("config", "dt.json", "")
+	maxBins          = flag.Int("max_bins", distributed.DefaultMaxBins, "number of histogram bins for each feature, at most 256")
+	maxSummaryValues = flag.Int(
+		"max_summary_values",
+		distributed.DefaultMaxSummaryValues,
+		"number of values of each feature each worker sends to compute the bins")
+)
+
+func runWorker() {
+	w, err := distributed.LoadShard(*shardPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	l, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Serving %v on %v", *shardPath, l.Addr())
+	// The address is written to stdout so that a port of 0 can be used
+	fmt.Println(l.Addr())
+	if err := distributed.Serve(l, w); err != nil {
+		glog.Fatal(err)
+	}
+}
+
+func runCoordinator() {
+	f, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	config := &pb.ForestConfig{}
+	if err := json.Unmarshal(f, config); err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Loaded forest config %+v", config)
+
+	if *workers == "" {
+		glog.Fatal("-workers is required")
+	}
+	c, err := distributed.Dial(strings.Split(*workers, ","))
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer c.Close()
+	c.MaxBins = *maxBins
+	c.MaxSummaryValues = *maxSummaryValues
+
+	forest, err := c.Train(context.Background(), config)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	serializedForest, err := json.MarshalIndent(forest, "", "  ")
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serializedForest)
+}
+
+func main() {
+	flag.Parse()
+	switch *mode {
+	case "worker":
+		runWorker()
+	case "coordinator":
+		runCoordinator()
+	default:
+		glog.Fatalf("Unknown mode: %v", *mode)
+	}
+}
@@ -0,0 +1,80 @@
+package decisiontrees
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoExamples is returned when training on an empty set of examples
+var ErrNoExamples = errors.New("no training examples")
+
+// ConfigError is returned for a ForestConfig that cannot be trained
+type ConfigError struct {
+	// Field is the path of the offending field, e.g.
+	// "lossFunctionConfig.lossFunction"
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid forest config %v: %v", e.Field, e.Reason)
+}
+
+func configErrorf(field string, format string, args ...interface{}) error {
+	return &ConfigError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ForestError is returned for a forest that cannot be evaluated
+type ForestError struct {
+	Reason string
+}
+
+func (e *ForestError) Error() string {
+	return fmt.Sprintf("invalid forest: %v", e.Reason)
+}
+
+func forestErrorf(format string, args ...interface{}) error {
+	return &ForestError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// TrainingError is returned when constructing a forest fails part way
+// through, wrapping the underlying error
+type TrainingError struct {
+	Round int
+	Err   error
+}
+
+func (e *TrainingError) Error() string {
+	return fmt.Sprintf("training failed in round %v: %v", e.Round, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TrainingError) Unwrap() error {
+	return e.Err
+}
+
+// FeatureError is returned for a feature vector that cannot be evaluated
+// by a forest
+type FeatureError struct {
+	// Feature is the index of the offending feature
+	Feature int
+	Reason  string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("invalid feature %v: %v", e.Feature, e.Reason)
+}
+
+// PMMLError is returned for a PMML document outside the subset written by
+// WritePMML
+type PMMLError struct {
+	Reason string
+}
+
+func (e *PMMLError) Error() string {
+	return fmt.Sprintf("unsupported PMML: %v", e.Reason)
+}
+
+func pmmlErrorf(format string, args ...interface{}) error {
+	return &PMMLError{Reason: fmt.Sprintf(format, args...)}
+}
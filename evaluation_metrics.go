@@ -4,7 +4,6 @@ import (
 	"code.google.com/p/goprotobuf/proto"
 	"fmt"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
-	"github.com/golang/glog"
 	"math"
 	"sort"
 )
@@ -97,6 +96,11 @@ func (l labelledPredictions) NormalizedEntropy() float64 {
 }
 
 func computeEpochResult(e Evaluator, examples Examples) pb.EpochResult {
+	return epochResult(evaluateRows(e, examples.featureRows()), examples)
+}
+
+// epochResult computes the metrics of the predictions of the examples
+func epochResult(predictions []float64, examples Examples) pb.EpochResult {
 	l := make([]labelledPrediction, 0, len(examples))
 
 	boolLabel := func(example *pb.Example) bool {
@@ -106,10 +110,10 @@ func computeEpochResult(e Evaluator, examples Examples) pb.EpochResult {
 		return false
 	}
 
-	for _, ex := range examples {
+	for i, prediction := range predictions {
 		l = append(l, labelledPrediction{
-			Label:      boolLabel(ex),
-			Prediction: e.Evaluate(ex.GetFeatures()),
+			Label:      boolLabel(examples[i]),
+			Prediction: prediction,
 		})
 	}
 
@@ -122,23 +126,23 @@ func computeEpochResult(e Evaluator, examples Examples) pb.EpochResult {
 	}
 }
 
-// LearningCurve computes the progressive learning curve after each epoch on the
-// given examples
-func LearningCurve(f *pb.Forest, e Examples) *pb.TrainingResults {
+// LearningCurve computes the progressive learning curve on the given
+// examples, with the result of epoch i evaluating the first i + 1 trees
+// of the forest, so that the last epoch evaluates the whole forest.
+// Earlier versions evaluated the first i trees, starting from the empty
+// forest.  It makes a single pass over the trees.
+func LearningCurve(f *pb.Forest, e Examples) (*pb.TrainingResults, error) {
 	tr := &pb.TrainingResults{
 		EpochResults: make([]*pb.EpochResult, 0, len(f.GetTrees())),
 	}
 
-	for i := range f.GetTrees() {
-		evaluator, err := NewRescaledFastForestEvaluator(&pb.Forest{
-			Trees:     f.GetTrees()[:i],
-			Rescaling: f.GetRescaling().Enum(),
-		})
-		if err != nil {
-			glog.Fatal(err)
-		}
-		er := computeEpochResult(evaluator, e)
-		tr.EpochResults = append(tr.EpochResults, &er)
+	s, err := NewStagedEvaluator(f)
+	if err != nil {
+		return nil, err
 	}
-	return tr
+	s.evaluateStagedBatch(e.featureRows(), func(stage int, predictions []float64) {
+		er := epochResult(predictions, e)
+		tr.EpochResults = append(tr.EpochResults, &er)
+	})
+	return tr, nil
 }
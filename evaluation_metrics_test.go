@@ -1,6 +1,8 @@
 package decisiontrees
 
 import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"math"
 	"math/rand"
 	"testing"
@@ -57,3 +59,64 @@ func TestRandomDatasetHasExpectedStatistics(t *testing.T) {
 		}
 	}
 }
+
+func TestLearningCurveRejectsInvalidForests(t *testing.T) {
+	forest := &pb.Forest{
+		Trees: []*pb.TreeNode{{
+			Feature:    proto.Int64(0),
+			SplitValue: proto.Float64(0.5),
+			Left:       &pb.TreeNode{LeafValue: proto.Float64(1.0)},
+		}, {
+			LeafValue: proto.Float64(1.0),
+		}},
+		Rescaling: pb.Rescaling_NONE.Enum(),
+	}
+	_, err := LearningCurve(forest, constructBenchmarkExamples(10, 2, 0))
+	if _, ok := err.(*ForestError); !ok {
+		t.Fatalf("Expected a ForestError, got %v", err)
+	}
+}
+
+func TestLearningCurveFirstEpochIsFirstTree(t *testing.T) {
+	forest := &pb.Forest{
+		Trees: []*pb.TreeNode{{
+			Feature:    proto.Int64(0),
+			SplitValue: proto.Float64(0.5),
+			Left:       &pb.TreeNode{LeafValue: proto.Float64(0.0)},
+			Right:      &pb.TreeNode{LeafValue: proto.Float64(1.0)},
+		}, {
+			LeafValue: proto.Float64(0.5),
+		}},
+		Rescaling: pb.Rescaling_NONE.Enum(),
+	}
+	examples := Examples{
+		{Label: proto.Float64(0), Features: []float64{0}},
+		{Label: proto.Float64(1), Features: []float64{1}},
+	}
+	curve, err := LearningCurve(forest, examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epochs := curve.GetEpochResults()
+	if len(epochs) != 2 {
+		t.Fatalf("Expected 2 epochs, got %v", len(epochs))
+	}
+	epochResultOf := func(trees []*pb.TreeNode) *pb.EpochResult {
+		evaluator, err := NewRescaledFastForestEvaluator(&pb.Forest{
+			Trees:     trees,
+			Rescaling: pb.Rescaling_NONE.Enum(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		er := computeEpochResult(evaluator, examples)
+		return &er
+	}
+	// Epoch 0 is the first tree, not the empty forest
+	if expected := epochResultOf(forest.GetTrees()[:1]); !proto.Equal(expected, epochs[0]) {
+		t.Fatalf("Expected epoch 0 to be %v, got %v", expected, epochs[0])
+	}
+	if empty := epochResultOf(nil); proto.Equal(empty, epochs[0]) {
+		t.Fatalf("Expected epoch 0 to differ from the empty forest %v", empty)
+	}
+}
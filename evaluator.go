@@ -1,7 +1,6 @@
 package decisiontrees
 
 import (
-	"fmt"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"github.com/golang/glog"
 	"math"
@@ -13,6 +12,15 @@ type Evaluator interface {
 	Evaluate(features []float64) float64
 }
 
+// BatchEvaluator is an Evaluator that can also score many feature vectors
+// at once
+type BatchEvaluator interface {
+	Evaluator
+	// EvaluateBatch writes the evaluation of rows[i] to predictions[i], and
+	// predictions must have at least len(rows) elements
+	EvaluateBatch(rows [][]float64, predictions []float64)
+}
+
 // EvaluatorFunc implements the Evaluator interface
 type EvaluatorFunc func(features []float64) float64
 
@@ -56,26 +64,31 @@ func (t *treeEvaluator) Evaluate(features []float64) float64 {
 const leafFeatureID = -1
 
 type flatNode struct {
-	value     float64
-	feature   int64
+	value   float64
+	feature int64
+	// leftChild is the index of the left child of a branch, with the right
+	// child following it, and the leaf index of a leaf
 	leftChild int
 }
 
 type fastTreeEvaluator struct {
 	nodes []flatNode
+	// numLeaves is the number of leaves, which are numbered from left to
+	// right by flattenTree
+	numLeaves int
 }
 
 func validateTree(t *pb.TreeNode) error {
 	if isLeaf(t) {
 		if t.GetLeft() != nil || t.GetRight() != nil {
-			return fmt.Errorf("leaf has non-zero children: %v", t)
+			return forestErrorf("leaf has non-zero children: %v", t)
 		}
 		return nil
 	}
 
 	// not a leaf - must have both children
 	if t.GetLeft() == nil || t.GetRight() == nil {
-		return fmt.Errorf("branch has nil children: %v", t.String())
+		return forestErrorf("branch has nil children: %v", t.String())
 	}
 
 	err := validateTree(t.GetLeft())
@@ -102,13 +115,28 @@ func (f *fastTreeEvaluator) Evaluate(features []float64) float64 {
 	return node.value
 }
 
+// leafIndex returns the index of the leaf the feature vector reaches
+func (f *fastTreeEvaluator) leafIndex(features []float64) int {
+	node := f.nodes[0]
+	for node.feature != leafFeatureID {
+		if features[node.feature] < node.value {
+			node = f.nodes[node.leftChild]
+		} else {
+			node = f.nodes[node.leftChild+1]
+		}
+	}
+	return node.leftChild
+}
+
 func flattenTree(f *fastTreeEvaluator, current *pb.TreeNode, currentIndex int) {
 	glog.Infof("Flattening tree at index %v", currentIndex)
 	if isLeaf(current) {
 		f.nodes[currentIndex] = flatNode{
-			value:   current.GetLeafValue(),
-			feature: leafFeatureID,
+			value:     current.GetLeafValue(),
+			feature:   leafFeatureID,
+			leftChild: f.numLeaves,
 		}
+		f.numLeaves++
 		return
 	}
 
@@ -127,7 +155,7 @@ func flattenTree(f *fastTreeEvaluator, current *pb.TreeNode, currentIndex int) {
 	flattenTree(f, current.GetRight(), leftChild+1)
 }
 
-func newFastTreeEvaluator(t *pb.TreeNode) (Evaluator, error) {
+func newFastTreeEvaluator(t *pb.TreeNode) (*fastTreeEvaluator, error) {
 	err := validateTree(t)
 	if err != nil {
 		return nil, err
@@ -141,7 +169,7 @@ func newFastTreeEvaluator(t *pb.TreeNode) (Evaluator, error) {
 }
 
 type fastForestEvaluator struct {
-	trees []Evaluator
+	trees []*fastTreeEvaluator
 }
 
 func (f *fastForestEvaluator) Evaluate(features []float64) float64 {
@@ -152,43 +180,146 @@ func (f *fastForestEvaluator) Evaluate(features []float64) float64 {
 	return sum
 }
 
-// NewRescaledFastForestEvaluator returns an evalator for a tree
-// that automatically corrects for various scaling factors required
-// for a given evaluation
-func NewRescaledFastForestEvaluator(f *pb.Forest) (Evaluator, error) {
-	e := &fastForestEvaluator{
-		trees: make([]Evaluator, 0, len(f.GetTrees())),
-	}
+// evaluationBlockSize is the number of rows each tree is evaluated on
+// before moving to the next tree, so that the nodes of a tree stay in
+// cache across the block
+const evaluationBlockSize = 256
 
-	for _, t := range f.GetTrees() {
-		evaluator, err := newFastTreeEvaluator(t)
-		if err != nil {
-			return nil, err
+// EvaluateBatch evaluates the forest tree by tree over blocks of rows,
+// with the blocks spread over GOMAXPROCS goroutines
+func (f *fastForestEvaluator) EvaluateBatch(rows [][]float64, predictions []float64) {
+	parallelBlocks(len(rows), evaluationBlockSize, func(begin, end int) {
+		f.evaluateBlock(rows[begin:end], predictions[begin:end])
+	})
+}
+
+func (f *fastForestEvaluator) evaluateBlock(rows [][]float64, predictions []float64) {
+	for i := range rows {
+		predictions[i] = 0.0
+	}
+	for _, t := range f.trees {
+		for i, features := range rows {
+			predictions[i] += t.Evaluate(features)
 		}
-		e.trees = append(e.trees, evaluator)
 	}
+}
+
+// rescaledEvaluator applies the rescaling of the forest to the sum of its
+// trees
+type rescaledEvaluator struct {
+	unscaled BatchEvaluator
+	rescale  func(float64) float64
+}
+
+func (r *rescaledEvaluator) Evaluate(features []float64) float64 {
+	return r.rescale(r.unscaled.Evaluate(features))
+}
+
+func (r *rescaledEvaluator) EvaluateBatch(rows [][]float64, predictions []float64) {
+	r.unscaled.EvaluateBatch(rows, predictions)
+	for i := range rows {
+		predictions[i] = r.rescale(predictions[i])
+	}
+}
 
-	switch f.GetRescaling() {
+// evaluateRows returns the evaluation of each of the rows, scoring them
+// as a batch if the evaluator supports it
+func evaluateRows(e Evaluator, rows [][]float64) []float64 {
+	predictions := make([]float64, len(rows))
+	if b, ok := e.(BatchEvaluator); ok {
+		b.EvaluateBatch(rows, predictions)
+		return predictions
+	}
+	for i, features := range rows {
+		predictions[i] = e.Evaluate(features)
+	}
+	return predictions
+}
+
+// baseRescalingFunc returns the function mapping the sum of the trees in
+// the forest to its prediction, for the uncalibrated rescaling methods
+func baseRescalingFunc(r pb.Rescaling, numTrees int) (func(float64) float64, error) {
+	switch r {
 	case pb.Rescaling_NONE:
-		return e, nil
+		return func(sum float64) float64 { return sum }, nil
 	case pb.Rescaling_AVERAGING:
-		return EvaluatorFunc(func(features []float64) float64 {
-			return e.Evaluate(features) / float64(len(e.trees))
-		}), nil
+		return func(sum float64) float64 { return sum / float64(numTrees) }, nil
 	case pb.Rescaling_LOG_ODDS:
-		return EvaluatorFunc(func(features []float64) float64 {
-			return 1.0 / (1.0 + math.Exp(-2.0*e.Evaluate(features)))
-		}), nil
+		return func(sum float64) float64 { return 1.0 / (1.0 + math.Exp(-2.0*sum)) }, nil
+	}
+	return nil, forestErrorf("unknown rescaling method: %v", r)
+}
+
+// rescalingFunc returns the function mapping the sum of the trees in the
+// forest to its prediction
+func rescalingFunc(f *pb.Forest) (func(float64) float64, error) {
+	return prefixRescalingFunc(f, len(f.GetTrees()))
+}
+
+// prefixRescalingFunc returns the function mapping the sum of the first
+// numTrees trees in the forest to their prediction
+func prefixRescalingFunc(f *pb.Forest, numTrees int) (func(float64) float64, error) {
+	if f.GetRescaling() != pb.Rescaling_CALIBRATED {
+		return baseRescalingFunc(f.GetRescaling(), numTrees)
+	}
+
+	if err := validateCalibrator(f.GetCalibrator()); err != nil {
+		return nil, err
+	}
+	base, err := baseRescalingFunc(f.GetCalibrator().GetBaseRescaling(), numTrees)
+	if err != nil {
+		return nil, err
 	}
+	c := f.GetCalibrator()
+	return func(sum float64) float64 { return calibrate(c, base(sum)) }, nil
+}
 
-	return nil, fmt.Errorf("unknown rescaling method: %v", f.GetRescaling)
+// NewRescaledFastForestEvaluator returns an evalator for a tree
+// that automatically corrects for various scaling factors required
+// for a given evaluation
+func NewRescaledFastForestEvaluator(f *pb.Forest) (BatchEvaluator, error) {
+	e, err := newUnscaledFastForestEvaluator(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.GetRescaling() == pb.Rescaling_NONE {
+		return e, nil
+	}
+
+	rescale, err := rescalingFunc(f)
+	if err != nil {
+		return nil, err
+	}
+	return &rescaledEvaluator{unscaled: e, rescale: rescale}, nil
+}
+
+// marginScale returns the factor that maps the sum of the trees in the
+// forest to its margin - the prediction before any non-linear rescaling
+// or calibration
+func marginScale(f *pb.Forest) (float64, error) {
+	rescaling := f.GetRescaling()
+	if rescaling == pb.Rescaling_CALIBRATED {
+		rescaling = f.GetCalibrator().GetBaseRescaling()
+	}
+
+	switch rescaling {
+	case pb.Rescaling_NONE, pb.Rescaling_LOG_ODDS:
+		return 1.0, nil
+	case pb.Rescaling_AVERAGING:
+		if len(f.GetTrees()) == 0 {
+			return 1.0, nil
+		}
+		return 1.0 / float64(len(f.GetTrees())), nil
+	}
+	return 0, forestErrorf("unknown rescaling method: %v", f.GetRescaling())
 }
 
 // NewFastForestEvaluator returns a flattened tree representation
 // used for efficient evaluation
-func newUnscaledFastForestEvaluator(f *pb.Forest) (Evaluator, error) {
+func newUnscaledFastForestEvaluator(f *pb.Forest) (*fastForestEvaluator, error) {
 	e := &fastForestEvaluator{
-		trees: make([]Evaluator, 0, len(f.GetTrees())),
+		trees: make([]*fastTreeEvaluator, 0, len(f.GetTrees())),
 	}
 
 	for _, t := range f.GetTrees() {
@@ -114,6 +114,80 @@ func BenchmarkFastTreeEvaluation(b *testing.B) {
 	benchEvaluator(f, b)
 }
 
+func TestBatchEvaluationMatchesEvaluate(t *testing.T) {
+	numFeatures := 20
+	forest := makeForest(50, 4, numFeatures)
+	// Not a multiple of the block size, so the last block is partial
+	rows := make([][]float64, 3*evaluationBlockSize+17)
+	for i := range rows {
+		rows[i] = randomFeatureVector(numFeatures)
+	}
+
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_LOG_ODDS, pb.Rescaling_AVERAGING} {
+		forest.Rescaling = rescaling.Enum()
+		evaluator, err := NewRescaledFastForestEvaluator(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		predictions := make([]float64, len(rows))
+		evaluator.EvaluateBatch(rows, predictions)
+		for i, features := range rows {
+			if expected := evaluator.Evaluate(features); predictions[i] != expected {
+				t.Fatalf("%v: row %v: expected %v, got %v", rescaling, i, expected, predictions[i])
+			}
+		}
+	}
+
+	// Plain evaluators are scored row by row
+	predictions := evaluateRows(&forestEvaluator{forest}, rows)
+	for i, features := range rows {
+		if expected := (&forestEvaluator{forest}).Evaluate(features); predictions[i] != expected {
+			t.Fatalf("row %v: expected %v, got %v", i, expected, predictions[i])
+		}
+	}
+}
+
+const numBatchRows = 4096
+
+func fastBatchEvaluator(forest *pb.Forest) BatchEvaluator {
+	evaluator, err := newUnscaledFastForestEvaluator(forest)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return evaluator
+}
+
+func benchBatch(b *testing.B, f func(*pb.Forest) BatchEvaluator, score func(e BatchEvaluator, rows [][]float64, predictions []float64)) {
+	forest := makeForest(*numTrees, *numLevels, *numFeatures)
+	evaluator := f(forest)
+	rows := make([][]float64, numBatchRows)
+	for i := range rows {
+		rows[i] = randomFeatureVector(*numFeatures)
+	}
+	predictions := make([]float64, numBatchRows)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		score(evaluator, rows, predictions)
+	}
+}
+
+func BenchmarkFastTreeRowByRowEvaluation(b *testing.B) {
+	flag.Parse()
+	benchBatch(b, fastBatchEvaluator, func(e BatchEvaluator, rows [][]float64, predictions []float64) {
+		for i, features := range rows {
+			predictions[i] = e.Evaluate(features)
+		}
+	})
+}
+
+func BenchmarkFastTreeBatchEvaluation(b *testing.B) {
+	flag.Parse()
+	benchBatch(b, fastBatchEvaluator, func(e BatchEvaluator, rows [][]float64, predictions []float64) {
+		e.EvaluateBatch(rows, predictions)
+	})
+}
+
 func BenchmarkNaiveTreeEvaluation(b *testing.B) {
 	flag.Parse()
 	f := func(forest *pb.Forest) Evaluator {
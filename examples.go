@@ -10,43 +10,64 @@ import (
 // Examples is a slice of Example elements
 type Examples []*pb.Example
 
-func (e Examples) subsampleExamples(samplingRate float64) Examples {
-	for i := range e {
-		j := rand.Intn(i + 1)
-		e[i], e[j] = e[j], e[i]
+// subsampleExamples returns a random sample of the given fraction of the
+// examples, leaving e unmodified
+func (e Examples) subsampleExamples(rng *rand.Rand, samplingRate float64) Examples {
+	shuffled := e.copyOrder()
+	for i := range shuffled {
+		j := rng.Intn(i + 1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
 	}
 
-	return e[:int64(float64(len(e))*samplingRate)]
+	return shuffled[:int64(float64(len(e))*samplingRate)]
 }
 
-func (e Examples) boostrapExamples(samplingRate float64) Examples {
+func (e Examples) boostrapExamples(rng *rand.Rand, samplingRate float64) Examples {
 	sampleSize := int(samplingRate * float64(len(e)))
 	result := make([]*pb.Example, 0, sampleSize)
 	for i := 0; i < sampleSize; i++ {
-		result = append(result, e[rand.Intn(len(e))])
+		result = append(result, e[rng.Intn(len(e))])
 	}
 	return result
 }
 
+// crossValidationSamples randomly assigns the examples to the folds,
+// leaving e unmodified
 func (e Examples) crossValidationSamples(folds int) []Examples {
 	crossValidatedSamples := make([]Examples, folds)
 	for i := range crossValidatedSamples {
 		crossValidatedSamples[i] = make([]*pb.Example, 0, len(e)/folds)
 	}
 
-	// Do a Fischer-Yates shuffle of the input array
-	for i := range e {
+	// Do a Fischer-Yates shuffle of a copy of the input array
+	shuffled := e.copyOrder()
+	for i := range shuffled {
 		j := rand.Intn(i + 1)
-		e[i], e[j] = e[j], e[i]
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
 	}
 
-	for i, ex := range e {
+	for i, ex := range shuffled {
 		fold := i % len(crossValidatedSamples)
 		crossValidatedSamples[fold] = append(crossValidatedSamples[fold], ex)
 	}
 	return crossValidatedSamples
 }
 
+// copyOrder returns a copy of the slice of examples, so that sorting or
+// shuffling the copy leaves the original order intact
+func (e Examples) copyOrder() Examples {
+	return append(Examples(nil), e...)
+}
+
+// featureRows returns the feature vector of each example
+func (e Examples) featureRows() [][]float64 {
+	result := make([][]float64, 0, len(e))
+	for _, ex := range e {
+		result = append(result, ex.GetFeatures())
+	}
+	return result
+}
+
 func (e Examples) String() string {
 	i := make([]interface{}, 0, len(e))
 	for _, ex := range e {
@@ -81,19 +102,3 @@ func (e *exampleSorter) Swap(i int, j int) {
 func (e *exampleSorter) Less(i int, j int) bool {
 	return e.by(e.examples[i], e.examples[j])
 }
-
-func (e Examples) getFeatures() []int {
-	vals := make(map[int]bool)
-	for _, example := range e {
-		for k, v := range example.Features {
-			if v != 0.0 {
-				vals[k] = true
-			}
-		}
-	}
-	res := make([]int, 0, len(vals))
-	for k := range vals {
-		res = append(res, k)
-	}
-	return res
-}
@@ -0,0 +1,24 @@
+package decisiontrees
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// ReadFeatureNames reads a file holding the name of feature i on line
+// i + 1, with surrounding whitespace trimmed
+func ReadFeatureNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		names = append(names, strings.TrimSpace(scanner.Text()))
+	}
+	return names, scanner.Err()
+}
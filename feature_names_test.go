@@ -0,0 +1,37 @@
+package decisiontrees
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFeatureNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feature_names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.txt")
+	if err := ioutil.WriteFile(path, []byte("age\n  income \n\nnum visits\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ReadFeatureNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"age", "income", "", "num visits"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, names)
+		}
+	}
+	if _, err := ReadFeatureNames(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
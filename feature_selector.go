@@ -5,24 +5,24 @@ import (
 )
 
 // FeatureSelector allows algorithms to configure which
-// features to use for a given round of splitting
+// features to use for a given round of splitting, from the features
+// with non-zero values in the node
 type FeatureSelector interface {
-	getFeatures(e Examples) []int
+	getFeatures(features []int, rng *rand.Rand) []int
 }
 
 type naiveFeatureSelector struct{}
 
-func (n naiveFeatureSelector) getFeatures(e Examples) []int {
-	return e.getFeatures()
+func (n naiveFeatureSelector) getFeatures(features []int, rng *rand.Rand) []int {
+	return features
 }
 
 type randomForestFeatureSelector struct {
 	featureSampleSize int
 }
 
-func (r randomForestFeatureSelector) getFeatures(e Examples) []int {
-	features := e.getFeatures()
-	perm := rand.Perm(len(features))
+func (r randomForestFeatureSelector) getFeatures(features []int, rng *rand.Rand) []int {
+	perm := rng.Perm(len(features))
 
 	// sampleSize = min(feature sample size, num features)
 	sampleSize := r.featureSampleSize
@@ -0,0 +1,179 @@
+package decisiontrees
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// FoldStrategy selects how examples are divided into cross-validation
+// folds
+type FoldStrategy int
+
+const (
+	// RandomFolds assigns shuffled examples to folds round-robin
+	RandomFolds FoldStrategy = iota
+	// StratifiedFolds assigns shuffled positive and negative examples to
+	// folds separately, so every fold has the same proportion of positives
+	StratifiedFolds
+	// GroupedFolds keeps all examples with the same GroupId in the same
+	// fold
+	GroupedFolds
+	// TimeSeriesFolds orders examples by Timestamp, and trains on the
+	// first k blocks to test on block k + 1
+	TimeSeriesFolds
+)
+
+var foldStrategyNames = map[string]FoldStrategy{
+	"random":      RandomFolds,
+	"stratified":  StratifiedFolds,
+	"grouped":     GroupedFolds,
+	"time_series": TimeSeriesFolds,
+}
+
+// ParseFoldStrategy returns the fold strategy with the given name - one of
+// random, stratified, grouped, or time_series
+func ParseFoldStrategy(name string) (FoldStrategy, error) {
+	s, ok := foldStrategyNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown fold strategy: %v", name)
+	}
+	return s, nil
+}
+
+// CrossValidationConfig configures the folds used in cross-validation
+type CrossValidationConfig struct {
+	NumFolds int
+	Strategy FoldStrategy
+}
+
+// crossValidationSplit is a single pair of training and testing sets
+type crossValidationSplit struct {
+	trainingSet Examples
+	testingSet  Examples
+}
+
+// splitsFromFolds tests on each fold in turn, training on the remainder
+func splitsFromFolds(folds []Examples) []crossValidationSplit {
+	splits := make([]crossValidationSplit, 0, len(folds))
+	for pos := range folds {
+		trainingSet := make([]*pb.Example, 0)
+		for i := range folds {
+			if i != pos {
+				trainingSet = append(trainingSet, folds[i]...)
+			}
+		}
+		splits = append(splits, crossValidationSplit{trainingSet: trainingSet, testingSet: folds[pos]})
+	}
+	return splits
+}
+
+func (e Examples) stratifiedFolds(numFolds int) []Examples {
+	positives, negatives := make(Examples, 0), make(Examples, 0)
+	for _, ex := range e {
+		if ex.GetLabel() > 0 {
+			positives = append(positives, ex)
+		} else {
+			negatives = append(negatives, ex)
+		}
+	}
+
+	folds := make([]Examples, numFolds)
+	i := 0
+	for _, class := range []Examples{positives, negatives} {
+		for _, j := range rand.Perm(len(class)) {
+			folds[i%numFolds] = append(folds[i%numFolds], class[j])
+			i++
+		}
+	}
+	return folds
+}
+
+func (e Examples) groupedFolds(numFolds int) ([]Examples, error) {
+	groups := make(map[string]Examples)
+	for _, ex := range e {
+		groups[ex.GetGroupId()] = append(groups[ex.GetGroupId()], ex)
+	}
+	if len(groups) < numFolds {
+		return nil, fmt.Errorf("cannot split %v groups into %v folds", len(groups), numFolds)
+	}
+
+	// Assign the largest groups first, each to the currently smallest
+	// fold, breaking ties by group id for reproducibility
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(groups[ids[i]]) != len(groups[ids[j]]) {
+			return len(groups[ids[i]]) > len(groups[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+
+	folds := make([]Examples, numFolds)
+	for _, id := range ids {
+		smallest := 0
+		for i := range folds {
+			if len(folds[i]) < len(folds[smallest]) {
+				smallest = i
+			}
+		}
+		folds[smallest] = append(folds[smallest], groups[id]...)
+	}
+	return folds, nil
+}
+
+// timeSeriesSplits divides the examples ordered by timestamp into
+// numFolds + 1 blocks, with the k-th split training on the first k blocks
+// and testing on the next
+func (e Examples) timeSeriesSplits(numFolds int) []crossValidationSplit {
+	sorted := make(Examples, len(e))
+	copy(sorted, e)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetTimestamp() < sorted[j].GetTimestamp()
+	})
+
+	boundary := func(k int) int { return k * len(sorted) / (numFolds + 1) }
+	splits := make([]crossValidationSplit, 0, numFolds)
+	for k := 1; k <= numFolds; k++ {
+		splits = append(splits, crossValidationSplit{
+			trainingSet: sorted[:boundary(k):boundary(k)],
+			testingSet:  sorted[boundary(k):boundary(k+1)],
+		})
+	}
+	return splits
+}
+
+// crossValidationSplits divides the examples into training and testing
+// sets according to the config.  The examples are not modified.
+func (e Examples) crossValidationSplits(c CrossValidationConfig) ([]crossValidationSplit, error) {
+	if c.NumFolds < 2 {
+		return nil, fmt.Errorf("cross-validation requires at least two folds, got %v", c.NumFolds)
+	}
+	minExamples := c.NumFolds
+	if c.Strategy == TimeSeriesFolds {
+		minExamples = c.NumFolds + 1
+	}
+	if len(e) < minExamples {
+		return nil, fmt.Errorf("cannot split %v examples into %v folds", len(e), c.NumFolds)
+	}
+
+	switch c.Strategy {
+	case RandomFolds:
+		return splitsFromFolds(e.crossValidationSamples(c.NumFolds)), nil
+	case StratifiedFolds:
+		return splitsFromFolds(e.stratifiedFolds(c.NumFolds)), nil
+	case GroupedFolds:
+		folds, err := e.groupedFolds(c.NumFolds)
+		if err != nil {
+			return nil, err
+		}
+		return splitsFromFolds(folds), nil
+	case TimeSeriesFolds:
+		return e.timeSeriesSplits(c.NumFolds), nil
+	}
+	return nil, fmt.Errorf("unknown fold strategy: %v", c.Strategy)
+}
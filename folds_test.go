@@ -0,0 +1,121 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"testing"
+)
+
+func checkPartition(t *testing.T, e Examples, splits []crossValidationSplit) {
+	seen := make(map[*pb.Example]int)
+	for _, s := range splits {
+		if len(s.trainingSet)+len(s.testingSet) != len(e) {
+			t.Fatalf("Expected %v examples in a split, got %v", len(e), len(s.trainingSet)+len(s.testingSet))
+		}
+		for _, ex := range s.testingSet {
+			seen[ex]++
+		}
+	}
+	for _, ex := range e {
+		if seen[ex] != 1 {
+			t.Fatalf("Example %v tested %v times", ex, seen[ex])
+		}
+	}
+}
+
+func TestStratifiedFolds(t *testing.T) {
+	numFolds := 5
+	e := make(Examples, 0)
+	for i := 0; i < 100; i++ {
+		label := -1.0
+		if i < 10 {
+			label = 1.0
+		}
+		e = append(e, &pb.Example{Label: proto.Float64(label)})
+	}
+
+	splits, err := e.crossValidationSplits(CrossValidationConfig{NumFolds: numFolds, Strategy: StratifiedFolds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPartition(t, e, splits)
+	for _, s := range splits {
+		if len(s.testingSet) != 20 {
+			t.Fatalf("Expected 20 examples per fold, got %v", len(s.testingSet))
+		}
+		numPositives := 0
+		for _, ex := range s.testingSet {
+			if ex.GetLabel() > 0 {
+				numPositives++
+			}
+		}
+		if numPositives != 2 {
+			t.Fatalf("Expected 2 positives per fold, got %v", numPositives)
+		}
+	}
+}
+
+func TestGroupedFolds(t *testing.T) {
+	numFolds := 3
+	e := make(Examples, 0)
+	for i := 0; i < 90; i++ {
+		e = append(e, &pb.Example{GroupId: proto.String(fmt.Sprintf("user%v", i%7))})
+	}
+
+	splits, err := e.crossValidationSplits(CrossValidationConfig{NumFolds: numFolds, Strategy: GroupedFolds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPartition(t, e, splits)
+	for _, s := range splits {
+		testGroups := make(map[string]bool)
+		for _, ex := range s.testingSet {
+			testGroups[ex.GetGroupId()] = true
+		}
+		for _, ex := range s.trainingSet {
+			if testGroups[ex.GetGroupId()] {
+				t.Fatalf("Group %v is in both the training and testing sets", ex.GetGroupId())
+			}
+		}
+	}
+
+	if _, err := e.crossValidationSplits(CrossValidationConfig{NumFolds: 8, Strategy: GroupedFolds}); err == nil {
+		t.Fatal("Expected an error for more folds than groups")
+	}
+}
+
+func TestTimeSeriesFolds(t *testing.T) {
+	numFolds := 4
+	e := make(Examples, 0)
+	for _, i := range []int64{9, 3, 7, 1, 0, 8, 2, 6, 4, 5} {
+		e = append(e, &pb.Example{Timestamp: proto.Int64(i)})
+	}
+
+	splits, err := e.crossValidationSplits(CrossValidationConfig{NumFolds: numFolds, Strategy: TimeSeriesFolds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(splits) != numFolds {
+		t.Fatalf("Expected %v splits, got %v", numFolds, len(splits))
+	}
+	previousTrainingSize := 0
+	for _, s := range splits {
+		if len(s.trainingSet) <= previousTrainingSize || len(s.testingSet) == 0 {
+			t.Fatalf("Expected a growing training set and a non-empty testing set, got %v and %v",
+				len(s.trainingSet), len(s.testingSet))
+		}
+		previousTrainingSize = len(s.trainingSet)
+		for _, train := range s.trainingSet {
+			for _, test := range s.testingSet {
+				if train.GetTimestamp() >= test.GetTimestamp() {
+					t.Fatalf("Training example at %v is not before testing example at %v",
+						train.GetTimestamp(), test.GetTimestamp())
+				}
+			}
+		}
+	}
+	if last := splits[numFolds-1]; len(last.trainingSet)+len(last.testingSet) != len(e) {
+		t.Fatalf("Expected the last split to cover every example")
+	}
+}
@@ -1,14 +1,35 @@
 package decisiontrees
 
 import (
-	"fmt"
+	"context"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 )
 
 // ForestGenerator is implemented by various algorithms that generate
 // an ensemble of decision trees from the given training dataset.
 type ForestGenerator interface {
-	ConstructForest(e Examples) *pb.Forest
+	ConstructForest(e Examples) (*pb.Forest, error)
+	// ConstructForestWithContext constructs the forest, notifying the
+	// observer (if non-nil) as each tree is added.  If the context is
+	// cancelled, it returns the partially constructed forest along with
+	// the context's error.
+	ConstructForestWithContext(ctx context.Context, e Examples, observer TrainingObserver) (*pb.Forest, error)
+}
+
+// TrainingObserver is notified of progress in constructing a forest
+type TrainingObserver interface {
+	// OnRound is called after each boosting round or random forest tree,
+	// with the forest constructed so far and its metrics on the training
+	// examples.  The forest must not be modified.
+	OnRound(round int, forest *pb.Forest, metrics pb.EpochResult)
+}
+
+// TrainingObserverFunc adapts a function to the TrainingObserver interface
+type TrainingObserverFunc func(round int, forest *pb.Forest, metrics pb.EpochResult)
+
+// OnRound calls f(round, forest, metrics)
+func (f TrainingObserverFunc) OnRound(round int, forest *pb.Forest, metrics pb.EpochResult) {
+	f(round, forest, metrics)
 }
 
 // NewForestGenerator returns a ForeestGenerator from the given
@@ -16,9 +37,18 @@ type ForestGenerator interface {
 func NewForestGenerator(forestConfig *pb.ForestConfig) (ForestGenerator, error) {
 	switch forestConfig.GetAlgorithm() {
 	case pb.Algorithm_BOOSTING:
-		return &boostingTreeGenerator{forestConfig: forestConfig}, nil
+		return newBoostingTreeGenerator(forestConfig), nil
 	case pb.Algorithm_RANDOM_FOREST:
-		return &randomForestGenerator{forestConfig: forestConfig}, nil
+		return newRandomForestGenerator(forestConfig), nil
+	}
+	return nil, configErrorf("algorithm", "unknown algorithm %v", forestConfig.GetAlgorithm())
+}
+
+// snapshotForest returns a copy of the forest that is unaffected by
+// further trees being added to it
+func snapshotForest(f *pb.Forest, trees []*pb.TreeNode) *pb.Forest {
+	return &pb.Forest{
+		Trees:     append([]*pb.TreeNode(nil), trees...),
+		Rescaling: f.GetRescaling().Enum(),
 	}
-	return nil, fmt.Errorf("unknown algorithm type: %v", forestConfig.GetAlgorithm())
 }
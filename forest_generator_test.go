@@ -0,0 +1,253 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"context"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"sync"
+	"testing"
+)
+
+func TestBoostingCancellation(t *testing.T) {
+	generator, err := NewForestGenerator(&pb.ForestConfig{
+		NumWeakLearners: proto.Int64(10),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(2),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rounds := make([]int, 0)
+	forest, err := generator.ConstructForestWithContext(
+		ctx,
+		constructBenchmarkExamples(100, 3, 0),
+		TrainingObserverFunc(func(round int, f *pb.Forest, metrics pb.EpochResult) {
+			rounds = append(rounds, round)
+			// The prior and one tree per round
+			if len(f.GetTrees()) != round+2 {
+				t.Fatalf("Expected %v trees after round %v, got %v", round+2, round, len(f.GetTrees()))
+			}
+			if metrics.Roc == nil {
+				t.Fatalf("Expected metrics after round %v", round)
+			}
+			if round == 2 {
+				cancel()
+			}
+		}))
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+	if len(rounds) != 3 || len(forest.GetTrees()) != 4 {
+		t.Fatalf("Expected 3 rounds and 4 trees, got rounds %v and %v trees", rounds, len(forest.GetTrees()))
+	}
+	if _, err := NewRescaledFastForestEvaluator(forest); err != nil {
+		t.Fatalf("Partial forest is invalid: %v", err)
+	}
+}
+
+func TestRandomForestObserver(t *testing.T) {
+	numTrees := 5
+	generator, err := NewForestGenerator(&pb.ForestConfig{
+		NumWeakLearners: proto.Int64(int64(numTrees)),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(2),
+		},
+		StochasticityConfig: &pb.StochasticityConfig{
+			ExampleBoostrapProportion: proto.Float64(0.5),
+			FeatureSampleSize:         proto.Int64(2),
+		},
+		Algorithm: pb.Algorithm_RANDOM_FOREST.Enum(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples := constructBenchmarkExamples(100, 3, 0)
+
+	numRounds := 0
+	forest, err := generator.ConstructForestWithContext(
+		context.Background(),
+		examples,
+		TrainingObserverFunc(func(round int, f *pb.Forest, metrics pb.EpochResult) {
+			if round != numRounds || len(f.GetTrees()) != round+1 {
+				t.Errorf("Unexpected round %v with %v trees", round, len(f.GetTrees()))
+			}
+			numRounds++
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numRounds != numTrees || len(forest.GetTrees()) != numTrees {
+		t.Fatalf("Expected %v rounds and trees, got %v and %v", numTrees, numRounds, len(forest.GetTrees()))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	forest, err = generator.ConstructForestWithContext(ctx, examples, nil)
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+	if len(forest.GetTrees()) != 0 {
+		t.Fatalf("Expected no trees after cancellation, got %v", len(forest.GetTrees()))
+	}
+}
+
+func TestForestGeneratorErrors(t *testing.T) {
+	_, err := NewForestGenerator(&pb.ForestConfig{Algorithm: pb.Algorithm(10).Enum()})
+	if configErr, ok := err.(*ConfigError); !ok || configErr.Field != "algorithm" {
+		t.Fatalf("Expected a ConfigError for algorithm, got %v", err)
+	}
+
+	generator, err := NewForestGenerator(&pb.ForestConfig{
+		NumWeakLearners: proto.Int64(2),
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction(10).Enum(),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = generator.ConstructForest(constructBenchmarkExamples(10, 2, 0))
+	if configErr, ok := err.(*ConfigError); !ok || configErr.Field != "lossFunctionConfig.lossFunction" {
+		t.Fatalf("Expected a ConfigError for the loss function, got %v", err)
+	}
+
+	for _, algorithm := range []pb.Algorithm{pb.Algorithm_BOOSTING, pb.Algorithm_RANDOM_FOREST} {
+		generator, err := NewForestGenerator(&pb.ForestConfig{Algorithm: algorithm.Enum()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := generator.ConstructForest(nil); err != ErrNoExamples {
+			t.Fatalf("%v: expected %v, got %v", algorithm, ErrNoExamples, err)
+		}
+	}
+}
+
+func TestForestIndependentOfNumThreads(t *testing.T) {
+	configs := []*pb.ForestConfig{
+		{
+			NumWeakLearners: proto.Int64(5),
+			SplittingConstraints: &pb.SplittingConstraints{
+				MaximumLevels: proto.Int64(3),
+			},
+			LossFunctionConfig: &pb.LossFunctionConfig{
+				LossFunction: pb.LossFunction_LOGIT.Enum(),
+			},
+			StochasticityConfig: &pb.StochasticityConfig{
+				PerRoundSamplingRate: proto.Float64(0.8),
+			},
+			Algorithm: pb.Algorithm_BOOSTING.Enum(),
+			Seed:      proto.Int64(7),
+		},
+		{
+			NumWeakLearners: proto.Int64(8),
+			SplittingConstraints: &pb.SplittingConstraints{
+				MaximumLevels: proto.Int64(3),
+			},
+			StochasticityConfig: &pb.StochasticityConfig{
+				ExampleBoostrapProportion: proto.Float64(0.7),
+				FeatureSampleSize:         proto.Int64(2),
+			},
+			Algorithm: pb.Algorithm_RANDOM_FOREST.Enum(),
+			Seed:      proto.Int64(7),
+		},
+	}
+	examples := constructBenchmarkExamples(200, 5, 0)
+
+	for _, config := range configs {
+		var expected *pb.Forest
+		for _, numThreads := range []int64{1, 2, 16} {
+			c := proto.Clone(config).(*pb.ForestConfig)
+			c.NumThreads = proto.Int64(numThreads)
+			generator, err := NewForestGenerator(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forest, err := generator.ConstructForest(examples)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected == nil {
+				expected = forest
+			} else if !proto.Equal(expected, forest) {
+				t.Errorf("%v forest with %v threads differs from one thread", c.GetAlgorithm(), numThreads)
+			}
+		}
+	}
+}
+
+func TestTrainingLeavesExamplesUnmodified(t *testing.T) {
+	boosting := func(loss pb.LossFunction) *pb.ForestConfig {
+		return &pb.ForestConfig{
+			NumWeakLearners: proto.Int64(4),
+			SplittingConstraints: &pb.SplittingConstraints{
+				MaximumLevels: proto.Int64(2),
+			},
+			LossFunctionConfig: &pb.LossFunctionConfig{
+				LossFunction: loss.Enum(),
+				HuberAlpha:   proto.Float64(0.8),
+			},
+			InfluenceTrimmingConfig: &pb.InfluenceTrimmingConfig{
+				Alpha: proto.Float64(0.1),
+			},
+			StochasticityConfig: &pb.StochasticityConfig{
+				PerRoundSamplingRate: proto.Float64(0.5),
+			},
+			Algorithm: pb.Algorithm_BOOSTING.Enum(),
+		}
+	}
+	configs := []*pb.ForestConfig{
+		boosting(pb.LossFunction_LOGIT),
+		boosting(pb.LossFunction_LEAST_ABSOLUTE_DEVIATION),
+		boosting(pb.LossFunction_HUBER),
+		{
+			NumWeakLearners: proto.Int64(4),
+			SplittingConstraints: &pb.SplittingConstraints{
+				MaximumLevels: proto.Int64(2),
+			},
+			StochasticityConfig: &pb.StochasticityConfig{
+				ExampleBoostrapProportion: proto.Float64(0.5),
+				FeatureSampleSize:         proto.Int64(2),
+			},
+			Algorithm: pb.Algorithm_RANDOM_FOREST.Enum(),
+		},
+	}
+
+	examples := constructBenchmarkExamples(200, 3, 0)
+	original := make(Examples, len(examples))
+	for i, ex := range examples {
+		original[i] = proto.Clone(ex).(*pb.Example)
+	}
+
+	// Train every config concurrently on the same examples, which the race
+	// detector checks
+	w := sync.WaitGroup{}
+	for _, config := range configs {
+		w.Add(1)
+		go func(config *pb.ForestConfig) {
+			defer w.Done()
+			generator, err := NewForestGenerator(config)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := generator.ConstructForest(examples); err != nil {
+				t.Error(err)
+			}
+		}(config)
+	}
+	w.Wait()
+
+	for i := range examples {
+		if !proto.Equal(examples[i], original[i]) {
+			t.Fatalf("Training modified example %v from %v to %v", i, original[i], examples[i])
+		}
+	}
+}
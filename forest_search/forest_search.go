@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	searchSpacePath = flag.String("search_space", "search_space.json", "")
+	trainDataPath   = flag.String("train_data", "train_data.json", "")
+	leaderboardPath = flag.String("leaderboard", "", "if set, the ranked leaderboard is written to this file")
+
+	strategy            = flag.String("strategy", "random", "grid, random, or halving")
+	metric              = flag.String("metric", "roc", "roc, normalized_entropy, or log_score")
+	numCandidates       = flag.Int("num_candidates", 20, "number of configurations sampled by random search and successive halving")
+	numFolds            = flag.Int("folds", 5, "")
+	foldStrategy        = flag.String("fold_strategy", "random", "random, stratified, grouped, or time_series")
+	maxConcurrentTrials = flag.Int("max_concurrent_trials", 2, "")
+	reductionFactor     = flag.Int("reduction_factor", 3, "")
+	seed                = flag.Int64("seed", 0, "seed for the configurations sampled by random search and successive halving")
+)
+
+var strategies = map[string]dt.SearchStrategy{
+	"grid":    dt.GridSearch,
+	"random":  dt.RandomSearch,
+	"halving": dt.SuccessiveHalvingSearch,
+}
+
+var metrics = map[string]dt.SearchMetric{
+	"roc":                dt.ROCMetric,
+	"normalized_entropy": dt.NormalizedEntropyMetric,
+	"log_score":          dt.LogScoreMetric,
+}
+
+func parseJSON(file string, v interface{}) error {
+	f, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(f, v)
+}
+
+func main() {
+	flag.Parse()
+	searchStrategy, ok := strategies[*strategy]
+	if !ok {
+		glog.Fatalf("Unknown search strategy: %v", *strategy)
+	}
+	searchMetric, ok := metrics[*metric]
+	if !ok {
+		glog.Fatalf("Unknown metric: %v", *metric)
+	}
+
+	searchFoldStrategy, err := dt.ParseFoldStrategy(*foldStrategy)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	space := dt.SearchSpace{}
+	if err := parseJSON(*searchSpacePath, &space); err != nil {
+		glog.Fatal(err)
+	}
+
+	trainData := &pb.TrainingData{}
+	if err := parseJSON(*trainDataPath, trainData); err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Loaded %v training examples", len(trainData.GetTrain()))
+
+	leaderboard, err := dt.SearchForestConfigs(space, dt.SearchConfig{
+		Strategy:      searchStrategy,
+		Metric:        searchMetric,
+		NumCandidates: *numCandidates,
+		CrossValidation: dt.CrossValidationConfig{
+			NumFolds: *numFolds,
+			Strategy: searchFoldStrategy,
+		},
+		MaxConcurrentTrials: *maxConcurrentTrials,
+		ReductionFactor:     *reductionFactor,
+		Seed:                *seed,
+	}, trainData.GetTrain())
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(leaderboard) == 0 {
+		glog.Fatal("Search evaluated no candidates")
+	}
+
+	if *leaderboardPath != "" {
+		serialized, err := json.MarshalIndent(leaderboard, "", "  ")
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*leaderboardPath, serialized, 0644); err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	glog.Infof("Best score %v", leaderboard[0].Score)
+	serialized, err := json.MarshalIndent(leaderboard[0].Config, "", "  ")
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serialized)
+}
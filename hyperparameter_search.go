@@ -0,0 +1,456 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// SearchStrategy is the algorithm used to generate and prune candidate
+// configurations in a hyperparameter search
+type SearchStrategy int
+
+const (
+	// GridSearch evaluates every combination of the parameter values
+	GridSearch SearchStrategy = iota
+	// RandomSearch evaluates randomly sampled configurations
+	RandomSearch
+	// SuccessiveHalvingSearch evaluates randomly sampled configurations
+	// with a small number of weak learners, and repeatedly keeps the best
+	// fraction of them while increasing the number of weak learners
+	SuccessiveHalvingSearch
+)
+
+// SearchMetric is the EpochResult metric used to rank candidates
+type SearchMetric int
+
+const (
+	// ROCMetric ranks by area under the ROC curve (higher is better)
+	ROCMetric SearchMetric = iota
+	// NormalizedEntropyMetric ranks by normalized entropy (lower is better)
+	NormalizedEntropyMetric
+	// LogScoreMetric ranks by log score (higher is better)
+	LogScoreMetric
+)
+
+func (m SearchMetric) value(er pb.EpochResult) float64 {
+	switch m {
+	case NormalizedEntropyMetric:
+		return er.GetNormalizedEntropy()
+	case LogScoreMetric:
+		return er.GetLogScore()
+	}
+	return er.GetRoc()
+}
+
+func (m SearchMetric) better(a, b float64) bool {
+	if m == NormalizedEntropyMetric {
+		return a < b
+	}
+	return a > b
+}
+
+// ParameterRange describes the values taken by a single ForestConfig field
+// during a search.
+type ParameterRange struct {
+	// Field is the path of the field in the ForestConfig, using the JSON
+	// names - e.g. "splittingConstraints.maximumLevels"
+	Field string `json:"field"`
+	// Values are the candidate values.  Grid search requires them, and
+	// random search samples from them if they are present.
+	Values []float64 `json:"values,omitempty"`
+	// Min and Max bound the values sampled by random search if there are
+	// no Values, on a log scale if LogScale is set.
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	LogScale bool    `json:"logScale,omitempty"`
+}
+
+// SearchSpace is a set of parameter ranges applied on top of a base
+// ForestConfig
+type SearchSpace struct {
+	Base       *pb.ForestConfig `json:"base"`
+	Parameters []ParameterRange `json:"parameters"`
+}
+
+// SearchConfig configures a hyperparameter search
+type SearchConfig struct {
+	Strategy SearchStrategy
+	Metric   SearchMetric
+	// NumCandidates is the number of configurations sampled by random
+	// search and successive halving
+	NumCandidates int
+	// CrossValidation configures the folds used to score each candidate
+	CrossValidation CrossValidationConfig
+	// MaxConcurrentTrials limits the number of candidates being
+	// cross-validated at once
+	MaxConcurrentTrials int
+	// ReductionFactor is the fraction (1 / ReductionFactor) of candidates
+	// kept at each rung of successive halving
+	ReductionFactor int
+	// Seed determines the configurations sampled by random search and
+	// successive halving
+	Seed int64
+}
+
+// SearchResult is the cross-validated score of a single candidate
+type SearchResult struct {
+	Config *pb.ForestConfig `json:"config"`
+	// Score is the mean of the metric over the cross-validation folds
+	Score float64 `json:"score"`
+	// Rung is the last round of successive halving the candidate
+	// survived, and is always zero for grid and random search.
+	Rung int `json:"rung"`
+}
+
+// Leaderboard is a ranked list of search results, best first
+type Leaderboard []SearchResult
+
+type parameterSetter func(c *pb.ForestConfig, v float64)
+
+func ensureSplittingConstraints(c *pb.ForestConfig) *pb.SplittingConstraints {
+	if c.SplittingConstraints == nil {
+		c.SplittingConstraints = &pb.SplittingConstraints{}
+	}
+	return c.SplittingConstraints
+}
+
+func ensureLossFunctionConfig(c *pb.ForestConfig) *pb.LossFunctionConfig {
+	if c.LossFunctionConfig == nil {
+		c.LossFunctionConfig = &pb.LossFunctionConfig{}
+	}
+	return c.LossFunctionConfig
+}
+
+func ensureInfluenceTrimmingConfig(c *pb.ForestConfig) *pb.InfluenceTrimmingConfig {
+	if c.InfluenceTrimmingConfig == nil {
+		c.InfluenceTrimmingConfig = &pb.InfluenceTrimmingConfig{}
+	}
+	return c.InfluenceTrimmingConfig
+}
+
+func ensureShrinkageConfig(c *pb.ForestConfig) *pb.ShrinkageConfig {
+	if c.ShrinkageConfig == nil {
+		c.ShrinkageConfig = &pb.ShrinkageConfig{}
+	}
+	return c.ShrinkageConfig
+}
+
+func ensureStochasticityConfig(c *pb.ForestConfig) *pb.StochasticityConfig {
+	if c.StochasticityConfig == nil {
+		c.StochasticityConfig = &pb.StochasticityConfig{}
+	}
+	return c.StochasticityConfig
+}
+
+func roundToInt64(v float64) *int64 {
+	return proto.Int64(int64(math.Floor(v + 0.5)))
+}
+
+// integerParameters are rounded to the nearest integer when sampled
+var integerParameters = map[string]bool{
+	"numWeakLearners":                           true,
+	"splittingConstraints.maximumLevels":        true,
+	"splittingConstraints.minimumSamplesAtLeaf": true,
+	"influenceTrimmingConfig.warmupRounds":      true,
+	"stochasticityConfig.featureSampleSize":     true,
+}
+
+var parameterSetters = map[string]parameterSetter{
+	"numWeakLearners": func(c *pb.ForestConfig, v float64) {
+		c.NumWeakLearners = roundToInt64(v)
+	},
+	"splittingConstraints.maximumLevels": func(c *pb.ForestConfig, v float64) {
+		ensureSplittingConstraints(c).MaximumLevels = roundToInt64(v)
+	},
+	"splittingConstraints.minimumAverageGain": func(c *pb.ForestConfig, v float64) {
+		ensureSplittingConstraints(c).MinimumAverageGain = proto.Float64(v)
+	},
+	"splittingConstraints.minimumSamplesAtLeaf": func(c *pb.ForestConfig, v float64) {
+		ensureSplittingConstraints(c).MinimumSamplesAtLeaf = roundToInt64(v)
+	},
+	"lossFunctionConfig.huberAlpha": func(c *pb.ForestConfig, v float64) {
+		ensureLossFunctionConfig(c).HuberAlpha = proto.Float64(v)
+	},
+	"influenceTrimmingConfig.alpha": func(c *pb.ForestConfig, v float64) {
+		ensureInfluenceTrimmingConfig(c).Alpha = proto.Float64(v)
+	},
+	"influenceTrimmingConfig.warmupRounds": func(c *pb.ForestConfig, v float64) {
+		ensureInfluenceTrimmingConfig(c).WarmupRounds = roundToInt64(v)
+	},
+	"shrinkageConfig.shrinkage": func(c *pb.ForestConfig, v float64) {
+		ensureShrinkageConfig(c).Shrinkage = proto.Float64(v)
+	},
+	"stochasticityConfig.perRoundSamplingRate": func(c *pb.ForestConfig, v float64) {
+		ensureStochasticityConfig(c).PerRoundSamplingRate = proto.Float64(v)
+	},
+	"stochasticityConfig.exampleBoostrapProportion": func(c *pb.ForestConfig, v float64) {
+		ensureStochasticityConfig(c).ExampleBoostrapProportion = proto.Float64(v)
+	},
+	"stochasticityConfig.featureSampleSize": func(c *pb.ForestConfig, v float64) {
+		ensureStochasticityConfig(c).FeatureSampleSize = roundToInt64(v)
+	},
+}
+
+func (s SearchSpace) validate(strategy SearchStrategy) error {
+	if s.Base == nil {
+		return configErrorf("base", "search space has no base config")
+	}
+	for _, p := range s.Parameters {
+		if _, ok := parameterSetters[p.Field]; !ok {
+			return configErrorf(p.Field, "unknown search parameter")
+		}
+		if strategy == GridSearch && len(p.Values) == 0 {
+			return configErrorf(p.Field, "grid search requires values")
+		}
+		if len(p.Values) == 0 && p.Min > p.Max {
+			return configErrorf(p.Field, "min %v greater than max %v", p.Min, p.Max)
+		}
+		if len(p.Values) == 0 && p.LogScale && p.Min <= 0 {
+			return configErrorf(p.Field, "log scale with non-positive min %v", p.Min)
+		}
+	}
+	return nil
+}
+
+// candidate returns a copy of the base config with the given parameter
+// values applied
+func (s SearchSpace) candidate(values []float64) *pb.ForestConfig {
+	c := proto.Clone(s.Base).(*pb.ForestConfig)
+	for i, p := range s.Parameters {
+		parameterSetters[p.Field](c, values[i])
+	}
+	return c
+}
+
+func (s SearchSpace) gridCandidates() []*pb.ForestConfig {
+	result := make([]*pb.ForestConfig, 0)
+	values := make([]float64, len(s.Parameters))
+	var recur func(i int)
+	recur = func(i int) {
+		if i == len(s.Parameters) {
+			result = append(result, s.candidate(values))
+			return
+		}
+		for _, v := range s.Parameters[i].Values {
+			values[i] = v
+			recur(i + 1)
+		}
+	}
+	recur(0)
+	return result
+}
+
+func (p ParameterRange) sample(rng *rand.Rand) float64 {
+	var v float64
+	switch {
+	case len(p.Values) > 0:
+		return p.Values[rng.Intn(len(p.Values))]
+	case p.LogScale:
+		v = math.Exp(math.Log(p.Min) + rng.Float64()*(math.Log(p.Max)-math.Log(p.Min)))
+	default:
+		v = p.Min + rng.Float64()*(p.Max-p.Min)
+	}
+	if integerParameters[p.Field] {
+		v = math.Floor(v + 0.5)
+	}
+	return v
+}
+
+func (s SearchSpace) randomCandidates(rng *rand.Rand, n int) []*pb.ForestConfig {
+	result := make([]*pb.ForestConfig, 0, n)
+	for i := 0; i < n; i++ {
+		values := make([]float64, len(s.Parameters))
+		for j, p := range s.Parameters {
+			values[j] = p.sample(rng)
+		}
+		result = append(result, s.candidate(values))
+	}
+	return result
+}
+
+// crossValidateConfig returns the mean of the metric over the held-out
+// folds of forests trained with the given config
+func crossValidateConfig(
+	config *pb.ForestConfig,
+	cv CrossValidationConfig,
+	metric SearchMetric,
+	e Examples) (float64, error) {
+	if _, err := NewForestGenerator(config); err != nil {
+		return 0, err
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	score, err := runCrossValidation(cv, e, func(trainingSet, testingSet Examples) float64 {
+		// Generators hold the forest under construction, so each fold
+		// needs its own generator
+		generator, _ := NewForestGenerator(config)
+		forest, err := generator.ConstructForest(trainingSet)
+		if err != nil {
+			setErr(err)
+			return 0
+		}
+		evaluator, err := NewRescaledFastForestEvaluator(forest)
+		if err != nil {
+			setErr(err)
+			return 0
+		}
+		return metric.value(computeEpochResult(evaluator, testingSet))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return score, firstErr
+}
+
+// evaluateCandidates cross-validates each of the candidates, running at
+// most c.MaxConcurrentTrials at once
+func evaluateCandidates(candidates []*pb.ForestConfig, c SearchConfig, rung int, e Examples) (Leaderboard, error) {
+	maxConcurrentTrials := c.MaxConcurrentTrials
+	if maxConcurrentTrials <= 0 {
+		maxConcurrentTrials = 1
+	}
+
+	results := make(Leaderboard, len(candidates))
+	errs := make([]error, len(candidates))
+	semaphore := make(chan struct{}, maxConcurrentTrials)
+	w := sync.WaitGroup{}
+	for i, candidate := range candidates {
+		w.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, candidate *pb.ForestConfig) {
+			defer func() {
+				<-semaphore
+				w.Done()
+			}()
+			score, err := crossValidateConfig(candidate, c.CrossValidation, c.Metric, e)
+			glog.Infof("Rung %v, candidate %v: score %v, config %v", rung, i, score, candidate)
+			results[i] = SearchResult{Config: candidate, Score: score, Rung: rung}
+			errs[i] = err
+		}(i, candidate)
+	}
+	w.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	results.sort(c.Metric)
+	return results, nil
+}
+
+func (l Leaderboard) sort(metric SearchMetric) {
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].Rung != l[j].Rung {
+			return l[i].Rung > l[j].Rung
+		}
+		return metric.better(l[i].Score, l[j].Score)
+	})
+}
+
+// successiveHalving evaluates the candidates with geometrically
+// increasing numbers of weak learners, keeping the best 1/eta of them at
+// each rung.  The final rung uses the full number of weak learners.
+func successiveHalving(candidates []*pb.ForestConfig, c SearchConfig, e Examples) (Leaderboard, error) {
+	eta := c.ReductionFactor
+	if eta < 2 {
+		eta = 3
+	}
+
+	numRungs := 1
+	for n := len(candidates); n >= eta; n /= eta {
+		numRungs++
+	}
+
+	leaderboard := make(Leaderboard, 0, len(candidates))
+	survivors := candidates
+	for rung := 0; rung < numRungs && len(survivors) > 0; rung++ {
+		budgetFraction := math.Pow(float64(eta), float64(rung-numRungs+1))
+		reduced := make([]*pb.ForestConfig, 0, len(survivors))
+		for _, candidate := range survivors {
+			r := proto.Clone(candidate).(*pb.ForestConfig)
+			numWeakLearners := int64(budgetFraction * float64(candidate.GetNumWeakLearners()))
+			if numWeakLearners < 1 {
+				numWeakLearners = 1
+			}
+			r.NumWeakLearners = proto.Int64(numWeakLearners)
+			reduced = append(reduced, r)
+		}
+
+		results, err := evaluateCandidates(reduced, c, rung, e)
+		if err != nil {
+			return nil, err
+		}
+
+		// Report the full configs rather than the reduced ones
+		byReduced := make(map[*pb.ForestConfig]*pb.ForestConfig)
+		for i := range reduced {
+			byReduced[reduced[i]] = survivors[i]
+		}
+		for i := range results {
+			results[i].Config = byReduced[results[i].Config]
+		}
+
+		numSurvivors := len(results) / eta
+		if rung == numRungs-1 || numSurvivors == 0 {
+			leaderboard = append(leaderboard, results...)
+			break
+		}
+		leaderboard = append(leaderboard, results[numSurvivors:]...)
+		survivors = make([]*pb.ForestConfig, 0, numSurvivors)
+		for _, r := range results[:numSurvivors] {
+			survivors = append(survivors, r.Config)
+		}
+	}
+
+	leaderboard.sort(c.Metric)
+	return leaderboard, nil
+}
+
+// SearchForestConfigs scores candidate configurations drawn from the
+// search space with k-fold cross-validation on the examples, and returns
+// them ranked best first.
+func SearchForestConfigs(space SearchSpace, c SearchConfig, e Examples) (Leaderboard, error) {
+	if err := space.validate(c.Strategy); err != nil {
+		return nil, err
+	}
+	if _, err := e.crossValidationSplits(c.CrossValidation); err != nil {
+		return nil, err
+	}
+
+	var candidates []*pb.ForestConfig
+	switch c.Strategy {
+	case GridSearch:
+		candidates = space.gridCandidates()
+	case RandomSearch, SuccessiveHalvingSearch:
+		rng := rand.New(rand.NewSource(c.Seed))
+		candidates = space.randomCandidates(rng, c.NumCandidates)
+	default:
+		return nil, configErrorf("strategy", "unknown search strategy %v", c.Strategy)
+	}
+
+	trainingData := &pb.TrainingData{Train: e}
+	for _, candidate := range candidates {
+		if err := ValidateForestConfig(candidate, trainingData); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Strategy == SuccessiveHalvingSearch {
+		return successiveHalving(candidates, c, e)
+	}
+	return evaluateCandidates(candidates, c, 0, e)
+}
@@ -0,0 +1,149 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math/rand"
+	"testing"
+)
+
+func searchBaseConfig() *pb.ForestConfig {
+	return &pb.ForestConfig{
+		NumWeakLearners: proto.Int64(9),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(2),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		ShrinkageConfig: &pb.ShrinkageConfig{
+			Shrinkage: proto.Float64(0.1),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	}
+}
+
+func TestGridSearch(t *testing.T) {
+	space := SearchSpace{
+		Base: searchBaseConfig(),
+		Parameters: []ParameterRange{
+			{Field: "splittingConstraints.maximumLevels", Values: []float64{1, 3}},
+			{Field: "shrinkageConfig.shrinkage", Values: []float64{0.05, 0.1, 0.5}},
+		},
+	}
+	examples := constructBenchmarkExamples(200, 3, 0)
+	leaderboard, err := SearchForestConfigs(space, SearchConfig{
+		Strategy:            GridSearch,
+		Metric:              NormalizedEntropyMetric,
+		CrossValidation:     CrossValidationConfig{NumFolds: 3},
+		MaxConcurrentTrials: 2,
+	}, examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaderboard) != 6 {
+		t.Fatalf("Expected 6 candidates, got %v", len(leaderboard))
+	}
+
+	seen := make(map[[2]float64]bool)
+	for i, r := range leaderboard {
+		if i > 0 && r.Score < leaderboard[i-1].Score {
+			t.Fatalf("Leaderboard is not sorted: %v", leaderboard)
+		}
+		seen[[2]float64{
+			float64(r.Config.GetSplittingConstraints().GetMaximumLevels()),
+			r.Config.GetShrinkageConfig().GetShrinkage(),
+		}] = true
+	}
+	if len(seen) != 6 {
+		t.Fatalf("Expected every grid point to be evaluated, got %v", seen)
+	}
+
+	// Training must not modify the weighted labels of the input examples
+	for _, ex := range examples {
+		if ex.WeightedLabel != nil {
+			t.Fatalf("Search modified example %v", ex)
+		}
+	}
+}
+
+func TestRandomSearchSamplesWithinRange(t *testing.T) {
+	space := SearchSpace{
+		Base: searchBaseConfig(),
+		Parameters: []ParameterRange{
+			{Field: "splittingConstraints.maximumLevels", Min: 1, Max: 4},
+			{Field: "shrinkageConfig.shrinkage", Min: 0.01, Max: 1, LogScale: true},
+		},
+	}
+	for _, c := range space.randomCandidates(rand.New(rand.NewSource(0)), 100) {
+		levels := c.GetSplittingConstraints().GetMaximumLevels()
+		if levels < 1 || levels > 4 {
+			t.Fatalf("Sampled maximum levels %v out of range", levels)
+		}
+		shrinkage := c.GetShrinkageConfig().GetShrinkage()
+		if shrinkage < 0.01 || shrinkage > 1 {
+			t.Fatalf("Sampled shrinkage %v out of range", shrinkage)
+		}
+	}
+}
+
+func TestRandomSearchIsDeterministicForSeed(t *testing.T) {
+	space := SearchSpace{
+		Base: searchBaseConfig(),
+		Parameters: []ParameterRange{
+			{Field: "shrinkageConfig.shrinkage", Min: 0.01, Max: 1, LogScale: true},
+		},
+	}
+	first := space.randomCandidates(rand.New(rand.NewSource(42)), 10)
+	second := space.randomCandidates(rand.New(rand.NewSource(42)), 10)
+	for i := range first {
+		if !proto.Equal(first[i], second[i]) {
+			t.Fatalf("Expected candidate %v to match, got %v and %v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestSuccessiveHalving(t *testing.T) {
+	space := SearchSpace{
+		Base: searchBaseConfig(),
+		Parameters: []ParameterRange{
+			{Field: "shrinkageConfig.shrinkage", Min: 0.01, Max: 1, LogScale: true},
+		},
+	}
+	leaderboard, err := SearchForestConfigs(space, SearchConfig{
+		Strategy:            SuccessiveHalvingSearch,
+		Metric:              ROCMetric,
+		NumCandidates:       9,
+		CrossValidation:     CrossValidationConfig{NumFolds: 2},
+		MaxConcurrentTrials: 4,
+		ReductionFactor:     3,
+	}, constructBenchmarkExamples(100, 3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaderboard) != 9 {
+		t.Fatalf("Expected 9 candidates, got %v", len(leaderboard))
+	}
+
+	// 9 candidates at 1 round, 3 at 3 rounds, 1 at 9 rounds
+	expectedRungs := []int{2, 1, 1, 0, 0, 0, 0, 0, 0}
+	for i, r := range leaderboard {
+		if r.Rung != expectedRungs[i] {
+			t.Fatalf("Expected rungs %v, got %v", expectedRungs, leaderboard)
+		}
+		if r.Config.GetNumWeakLearners() != 9 {
+			t.Fatalf("Expected the full config to be reported, got %v", r.Config)
+		}
+	}
+}
+
+func TestSearchRejectsUnknownParameters(t *testing.T) {
+	space := SearchSpace{
+		Base:       searchBaseConfig(),
+		Parameters: []ParameterRange{{Field: "numTrees", Values: []float64{1}}},
+	}
+	_, err := SearchForestConfigs(space, SearchConfig{CrossValidation: CrossValidationConfig{NumFolds: 2}}, nil)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("Expected a ConfigError for an unknown parameter, got %v", err)
+	}
+}
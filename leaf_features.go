@@ -0,0 +1,99 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+)
+
+// LeafEvaluator returns the leaf each tree of a forest reaches, so that the
+// forest can be used as a feature transformer for a linear model.  The
+// leaves of each tree are numbered from left to right.
+type LeafEvaluator struct {
+	trees []*fastTreeEvaluator
+	// offsets holds the index of the first leaf feature of each tree
+	offsets     []int
+	numFeatures int
+}
+
+// NewLeafEvaluator returns a LeafEvaluator for the trees of the forest
+func NewLeafEvaluator(f *pb.Forest) (*LeafEvaluator, error) {
+	l := &LeafEvaluator{
+		trees:   make([]*fastTreeEvaluator, 0, len(f.GetTrees())),
+		offsets: make([]int, 0, len(f.GetTrees())),
+	}
+	for _, t := range f.GetTrees() {
+		evaluator, err := newFastTreeEvaluator(t)
+		if err != nil {
+			return nil, err
+		}
+		l.trees = append(l.trees, evaluator)
+		l.offsets = append(l.offsets, l.numFeatures)
+		l.numFeatures += evaluator.numLeaves
+	}
+	return l, nil
+}
+
+// NumLeaves returns the number of leaves of each tree
+func (l *LeafEvaluator) NumLeaves() []int {
+	result := make([]int, 0, len(l.trees))
+	for _, t := range l.trees {
+		result = append(result, t.numLeaves)
+	}
+	return result
+}
+
+// NumLeafFeatures returns the total number of leaves in the forest, which
+// is the number of leaf indicator features
+func (l *LeafEvaluator) NumLeafFeatures() int {
+	return l.numFeatures
+}
+
+// Leaves returns the index of the leaf reached in each tree
+func (l *LeafEvaluator) Leaves(features []float64) []int {
+	result := make([]int, 0, len(l.trees))
+	for _, t := range l.trees {
+		result = append(result, t.leafIndex(features))
+	}
+	return result
+}
+
+// LeafFeatures returns the leaf indicator features of the feature vector,
+// with one feature for each leaf of the forest, numbered tree by tree,
+// and a value of one for the leaf reached in each tree
+func (l *LeafEvaluator) LeafFeatures(features []float64) []*pb.Feature {
+	result := make([]*pb.Feature, 0, len(l.trees))
+	for i, leaf := range l.Leaves(features) {
+		result = append(result, &pb.Feature{
+			Feature: proto.Int64(int64(l.offsets[i] + leaf)),
+			Value:   proto.Float64(1.0),
+		})
+	}
+	return result
+}
+
+func (l *LeafEvaluator) sparseExamples(e Examples) []*pb.SparseExample {
+	result := make([]*pb.SparseExample, len(e))
+	parallelFor(len(e), func(i int) {
+		result[i] = &pb.SparseExample{
+			Label:     e[i].Label,
+			Features:  l.LeafFeatures(e[i].GetFeatures()),
+			GroupId:   e[i].GroupId,
+			Timestamp: e[i].Timestamp,
+		}
+	})
+	return result
+}
+
+// LeafFeatureData transforms the train and test examples into sparse
+// examples holding the leaf indicator features of the forest
+func LeafFeatureData(f *pb.Forest, data *pb.TrainingData) (*pb.SparseTrainingData, error) {
+	l, err := NewLeafEvaluator(f)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SparseTrainingData{
+		Train:       l.sparseExamples(data.GetTrain()),
+		Test:        l.sparseExamples(data.GetTest()),
+		NumFeatures: proto.Int64(int64(l.NumLeafFeatures())),
+	}, nil
+}
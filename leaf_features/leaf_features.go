@@ -0,0 +1,53 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
+	"flag"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	forestPath = flag.String("forest", "forest.json", "")
+	dataPath   = flag.String("data", "train_data.json", "")
+)
+
+func parseToProto(file string, protobuf proto.Message) error {
+	f, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(f, protobuf)
+}
+
+// Writes the SparseTrainingData of leaf indicator features of the forest
+// for the examples in the TrainingData to stdout
+func main() {
+	flag.Parse()
+	forest := &pb.Forest{}
+	if err := parseToProto(*forestPath, forest); err != nil {
+		glog.Fatal(err)
+	}
+
+	data := &pb.TrainingData{}
+	if err := parseToProto(*dataPath, data); err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Transforming %v train and %v test examples", len(data.GetTrain()), len(data.GetTest()))
+
+	sparse, err := dt.LeafFeatureData(forest, data)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	serialized, err := json.Marshal(sparse)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serialized)
+}
@@ -0,0 +1,79 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"testing"
+)
+
+func TestLeavesNumberedLeftToRight(t *testing.T) {
+	forest := safeEvaluatorForest()
+	forest.Trees = append(forest.Trees, forest.Trees[0])
+	l, err := NewLeafEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := l.NumLeaves(); len(n) != 2 || n[0] != 3 || n[1] != 3 || l.NumLeafFeatures() != 6 {
+		t.Fatalf("expected 3 leaves in each tree, got %v", n)
+	}
+
+	// The leaves of the tree have values 1, 2 and 3 from left to right
+	for _, fv := range [][]float64{{0, 0, 0}, {0, 0, 1}, {1, 0, 1}} {
+		expected := int((&treeEvaluator{forest.Trees[0]}).Evaluate(fv)) - 1
+		leaves := l.Leaves(fv)
+		if leaves[0] != expected || leaves[1] != expected {
+			t.Errorf("%v: expected leaf %v in both trees, got %v", fv, expected, leaves)
+		}
+	}
+
+	data := &pb.TrainingData{
+		Train: []*pb.Example{{
+			Label:    proto.Float64(1.0),
+			Features: []float64{1, 0, 1},
+			GroupId:  proto.String("a"),
+		}},
+		Test: []*pb.Example{{Features: []float64{0, 0, 0}}},
+	}
+	sparse, err := LeafFeatureData(forest, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sparse.GetNumFeatures() != 6 || len(sparse.GetTrain()) != 1 || len(sparse.GetTest()) != 1 {
+		t.Fatalf("unexpected sparse data %v", sparse)
+	}
+	train := sparse.GetTrain()[0]
+	if train.GetLabel() != 1.0 || train.GetGroupId() != "a" {
+		t.Errorf("expected the label and group to be kept, got %v", train)
+	}
+	for i, expected := range []int64{2, 5} {
+		f := train.GetFeatures()[i]
+		if f.GetFeature() != expected || f.GetValue() != 1.0 {
+			t.Errorf("expected feature %v to be one, got %v", expected, f)
+		}
+	}
+	if f := sparse.GetTest()[0].GetFeatures(); f[0].GetFeature() != 0 || f[1].GetFeature() != 3 {
+		t.Errorf("expected features 0 and 3, got %v", f)
+	}
+}
+
+func TestLeafIndexMatchesQuickScorerNumbering(t *testing.T) {
+	numFeatures := 10
+	forest := makeForest(20, 4, numFeatures)
+	l, err := NewLeafEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := newUnscaledQuickScorer(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		fv := randomFeatureVector(numFeatures)
+		for tree, leaf := range l.Leaves(fv) {
+			expected := l.trees[tree].Evaluate(fv)
+			if actual := q.trees[tree].leafValues[leaf]; actual != expected {
+				t.Fatalf("tree %v leaf %v: expected value %v, got %v", tree, leaf, expected, actual)
+			}
+		}
+	}
+}
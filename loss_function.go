@@ -1,30 +1,57 @@
 package decisiontrees
 
 import (
-	"code.google.com/p/goprotobuf/proto"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
-	"github.com/golang/glog"
 	"math"
+	"sort"
 )
 
 // LossFunction is an arbitrary loss function used
-// in computing decision trees
+// in computing decision trees.  None of the methods modify the examples
+// or their order.
 type LossFunction interface {
-	UpdateWeightedLabels(e Examples)
+	// WeightedLabels returns the negative gradient of the loss at each of
+	// the examples
+	WeightedLabels(e Examples) []float64
 	GetPrior(e Examples) float64
 	GetLeafWeight(e Examples) float64
 	GetSampleImportance(ex *pb.Example) float64
 }
 
+// median returns the middle of the values, leaving them unmodified
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func labels(e Examples) []float64 {
+	result := make([]float64, len(e))
+	for i, ex := range e {
+		result[i] = ex.GetLabel()
+	}
+	return result
+}
+
+func residuals(e Examples, evaluator Evaluator) []float64 {
+	result := make([]float64, len(e))
+	for i, ex := range e {
+		result[i] = ex.GetLabel() - evaluator.Evaluate(ex.Features)
+	}
+	return result
+}
+
 type logitLoss struct {
 	evaluator Evaluator
 }
 
-func (l logitLoss) UpdateWeightedLabels(e Examples) {
-	for _, ex := range e {
+func (l logitLoss) WeightedLabels(e Examples) []float64 {
+	result := make([]float64, len(e))
+	for i, ex := range e {
 		prediction := l.evaluator.Evaluate(ex.Features)
-		ex.WeightedLabel = proto.Float64(2 * ex.GetLabel() / (1 + math.Exp(2*ex.GetLabel()*prediction)))
+		result[i] = 2 * ex.GetLabel() / (1 + math.Exp(2*ex.GetLabel()*prediction))
 	}
+	return result
 }
 
 func (l logitLoss) GetSampleImportance(ex *pb.Example) float64 {
@@ -83,30 +110,23 @@ func (l leastAbsoluteDeviationLoss) GetSampleImportance(ex *pb.Example) float64
 
 func (l leastAbsoluteDeviationLoss) GetPrior(e Examples) float64 {
 	// Return the median label
-	by(func(e1, e2 *pb.Example) bool { return e1.GetLabel() < e2.GetLabel() }).Sort(e)
-	return e[len(e)/2].GetLabel()
-}
-
-func (l leastAbsoluteDeviationLoss) residual(ex *pb.Example) float64 {
-	return ex.GetLabel() - l.evaluator.Evaluate(ex.Features)
+	return median(labels(e))
 }
 
 func (l leastAbsoluteDeviationLoss) GetLeafWeight(e Examples) float64 {
-	by(func(e1, e2 *pb.Example) bool {
-		return l.residual(e1) < l.residual(e2)
-	}).Sort(e)
-	return l.residual(e[len(e)/2])
+	return median(residuals(e, l.evaluator))
 }
 
-func (l leastAbsoluteDeviationLoss) UpdateWeightedLabels(e Examples) {
-	for _, ex := range e {
-		prediction := l.evaluator.Evaluate(ex.Features)
-		if ex.GetLabel()-prediction > 0 {
-			ex.WeightedLabel = proto.Float64(1.0)
+func (l leastAbsoluteDeviationLoss) WeightedLabels(e Examples) []float64 {
+	result := make([]float64, len(e))
+	for i, residual := range residuals(e, l.evaluator) {
+		if residual > 0 {
+			result[i] = 1.0
 		} else {
-			ex.WeightedLabel = proto.Float64(-1.0)
+			result[i] = -1.0
 		}
 	}
+	return result
 }
 
 type huberLoss struct {
@@ -118,44 +138,36 @@ type huberLoss struct {
 }
 
 func (h huberLoss) GetPrior(e Examples) float64 {
-	by(func(e1, e2 *pb.Example) bool {
-		return e1.GetLabel() < e2.GetLabel()
-	}).Sort(e)
-	return e[len(e)/2].GetLabel()
+	return median(labels(e))
 }
 
 func (h huberLoss) GetSampleImportance(ex *pb.Example) float64 {
 	return 1.0
 }
 
-func (h huberLoss) residual(ex *pb.Example) float64 {
-	return ex.GetLabel() - h.evaluator.Evaluate(ex.Features)
-}
+func (h huberLoss) WeightedLabels(e Examples) []float64 {
+	divergences := residuals(e, h.evaluator)
+	sorted := append([]float64(nil), divergences...)
+	sort.Float64s(sorted)
+	delta := sorted[int64(float64(len(e))*h.huberAlpha)]
 
-func (h huberLoss) UpdateWeightedLabels(e Examples) {
-	by(func(e1, e2 *pb.Example) bool {
-		return h.residual(e1) < h.residual(e2)
-	}).Sort(e)
-	marginalExample := e[int64(float64(len(e))*h.huberAlpha)]
-	delta := h.residual(marginalExample)
-	for _, ex := range e {
-		divergence := h.residual(ex)
+	result := make([]float64, len(e))
+	for i, divergence := range divergences {
 		if divergence <= delta {
-			ex.WeightedLabel = proto.Float64(divergence)
+			result[i] = divergence
 		} else {
-			ex.WeightedLabel = proto.Float64(delta * divergence / math.Abs(divergence))
+			result[i] = delta * divergence / math.Abs(divergence)
 		}
 	}
+	return result
 }
 
 func (h huberLoss) GetLeafWeight(e Examples) float64 {
-	by(func(e1, e2 *pb.Example) bool {
-		return h.residual(e1) < h.residual(e2)
-	}).Sort(e)
-	medianResidual := h.residual(e[len(e)/2])
+	nodeResiduals := residuals(e, h.evaluator)
+	medianResidual := median(nodeResiduals)
 	innerDistribution := 0.0
-	for _, ex := range e {
-		residualDelta := h.residual(ex) - medianResidual
+	for _, residual := range nodeResiduals {
+		residualDelta := residual - medianResidual
 		if residualDelta == 0.0 {
 			continue
 		}
@@ -170,22 +182,21 @@ func (h huberLoss) GetLeafWeight(e Examples) float64 {
 
 // NewLossFunction returns an implementation of `LossFunction`
 // given the LossFunctionConfig
-func NewLossFunction(l *pb.LossFunctionConfig, evaluator Evaluator) LossFunction {
+func NewLossFunction(l *pb.LossFunctionConfig, evaluator Evaluator) (LossFunction, error) {
 	switch l.GetLossFunction() {
 	case pb.LossFunction_LOGIT:
 		return logitLoss{
 			evaluator: evaluator,
-		}
+		}, nil
 	case pb.LossFunction_LEAST_ABSOLUTE_DEVIATION:
 		return leastAbsoluteDeviationLoss{
 			evaluator: evaluator,
-		}
+		}, nil
 	case pb.LossFunction_HUBER:
 		return huberLoss{
 			huberAlpha: l.GetHuberAlpha(),
 			evaluator:  evaluator,
-		}
+		}, nil
 	}
-	glog.Fatalf("Unknown enum: %v", l)
-	panic("")
+	return nil, configErrorf("lossFunctionConfig.lossFunction", "unknown loss function %v", l.GetLossFunction())
 }
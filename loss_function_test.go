@@ -1 +1,15 @@
 package decisiontrees
+
+import (
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"testing"
+)
+
+func TestUnknownLossFunction(t *testing.T) {
+	_, err := NewLossFunction(&pb.LossFunctionConfig{
+		LossFunction: pb.LossFunction(10).Enum(),
+	}, nil)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("Expected a ConfigError, got %v", err)
+	}
+}
@@ -86,13 +86,20 @@ func (m *MongoTrainer) runTraining(task *trainingTask) error {
 		return err
 	}
 
+	if err := dt.ValidateForestConfig(task.row.GetForestConfig(), trainingData); err != nil {
+		return err
+	}
+
 	generator, err := dt.NewForestGenerator(task.row.GetForestConfig())
 	if err != nil {
 		return err
 	}
-	task.row.Forest = generator.ConstructForest(trainingData.GetTrain())
-	task.row.TrainingResults = dt.LearningCurve(task.row.Forest, trainingData.GetTest())
-	return nil
+	task.row.Forest, err = generator.ConstructForest(trainingData.GetTrain())
+	if err != nil {
+		return err
+	}
+	task.row.TrainingResults, err = dt.LearningCurve(task.row.Forest, trainingData.GetTest())
+	return err
 }
 
 func (m *MongoTrainer) claimTask(task *trainingTask) error {
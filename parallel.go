@@ -0,0 +1,97 @@
+package decisiontrees
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelFor calls f(i) for every i in [0, n), spread over a bounded
+// number of goroutines
+func parallelFor(n int, f func(i int)) {
+	numWorkers := runtime.GOMAXPROCS(0)
+	if numWorkers > n {
+		numWorkers = n
+	}
+
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	w := sync.WaitGroup{}
+	for i := 0; i < numWorkers; i++ {
+		w.Add(1)
+		go func() {
+			for index := range indices {
+				f(index)
+			}
+			w.Done()
+		}()
+	}
+	w.Wait()
+}
+
+// parallelBlocks calls f(begin, end) for consecutive blocks of at most
+// blockSize of the indices in [0, n), spread over parallelFor
+func parallelBlocks(n int, blockSize int, f func(begin, end int)) {
+	numBlocks := (n + blockSize - 1) / blockSize
+	parallelFor(numBlocks, func(block int) {
+		begin := block * blockSize
+		end := begin + blockSize
+		if end > n {
+			end = n
+		}
+		f(begin, end)
+	})
+}
+
+// workerPool bounds the number of goroutines shared by every level of
+// tree construction.  Tasks started when the pool is full run in the
+// caller's goroutine, so nested tasks never wait on a slot and cannot
+// deadlock.
+type workerPool struct {
+	slots chan struct{}
+}
+
+// newWorkerPool returns a pool running at most numThreads tasks at once,
+// or GOMAXPROCS if numThreads is not positive
+func newWorkerPool(numThreads int) *workerPool {
+	if numThreads <= 0 {
+		numThreads = runtime.GOMAXPROCS(0)
+	}
+	return &workerPool{slots: make(chan struct{}, numThreads)}
+}
+
+// taskGroup is a set of tasks on a pool that can be waited on together
+type taskGroup struct {
+	pool *workerPool
+	wg   sync.WaitGroup
+}
+
+func (p *workerPool) group() *taskGroup {
+	return &taskGroup{pool: p}
+}
+
+// run runs f in a new goroutine if the pool has a free slot, and in the
+// calling goroutine otherwise
+func (g *taskGroup) run(f func()) {
+	select {
+	case g.pool.slots <- struct{}{}:
+		g.wg.Add(1)
+		go func() {
+			defer func() {
+				<-g.pool.slots
+				g.wg.Done()
+			}()
+			f()
+		}()
+	default:
+		f()
+	}
+}
+
+// wait blocks until every task in the group has finished
+func (g *taskGroup) wait() {
+	g.wg.Wait()
+}
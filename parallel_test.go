@@ -0,0 +1,42 @@
+package decisiontrees
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestWorkerPoolBoundsGoroutines(t *testing.T) {
+	numThreads := 3
+	pool := newWorkerPool(numThreads)
+	baseline := runtime.NumGoroutine()
+
+	var mu sync.Mutex
+	maxGoroutines, numTasks := 0, 0
+	var recur func(depth int)
+	recur = func(depth int) {
+		mu.Lock()
+		numTasks++
+		if n := runtime.NumGoroutine(); n > maxGoroutines {
+			maxGoroutines = n
+		}
+		mu.Unlock()
+		if depth == 0 {
+			return
+		}
+		g := pool.group()
+		for i := 0; i < 4; i++ {
+			g.run(func() { recur(depth - 1) })
+		}
+		g.wait()
+	}
+	recur(5)
+
+	// 1 + 4 + 16 + 64 + 256 + 1024 nested tasks
+	if numTasks != 1365 {
+		t.Errorf("Expected 1365 tasks to run, got %v", numTasks)
+	}
+	if maxGoroutines > baseline+numThreads {
+		t.Errorf("Expected at most %v goroutines, got %v", baseline+numThreads, maxGoroutines)
+	}
+}
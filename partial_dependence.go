@@ -0,0 +1,316 @@
+package decisiontrees
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"sort"
+)
+
+// PartialDependenceMethod selects how partial dependence is computed
+type PartialDependenceMethod int
+
+const (
+	// PartialDependenceAuto uses the recursion if every tree has cover
+	// annotations, and brute force otherwise.  The two average the other
+	// features over different distributions, so the result for the same
+	// background set depends on whether the forest has covers.
+	PartialDependenceAuto PartialDependenceMethod = iota
+	// PartialDependenceRecursion walks each tree once per grid point,
+	// following the branch for the target features and averaging over
+	// both branches (weighted by training cover) for every other feature.
+	// The other features are averaged over the training distribution
+	// recorded in the cover annotations, and the background set only
+	// determines the grid.
+	PartialDependenceRecursion
+	// PartialDependenceBruteForce evaluates the forest on every background
+	// example with the target features replaced by the grid values
+	PartialDependenceBruteForce
+)
+
+// PartialDependenceConfig configures the grid and the method used in
+// computing partial dependence and ICE curves
+type PartialDependenceConfig struct {
+	// GridResolution is the maximum number of grid points per feature
+	GridResolution int
+	// LowerPercentile and UpperPercentile bound the grid, in [0, 1]
+	LowerPercentile float64
+	UpperPercentile float64
+	Method          PartialDependenceMethod
+}
+
+// DefaultPartialDependenceConfig is a 100 point grid between the 5th and
+// 95th percentiles of the background set
+var DefaultPartialDependenceConfig = PartialDependenceConfig{
+	GridResolution:  100,
+	LowerPercentile: 0.05,
+	UpperPercentile: 0.95,
+	Method:          PartialDependenceAuto,
+}
+
+// PartialDependence is the average margin of the forest as a function of
+// one or two features, marginalising over the rest.  For two features,
+// Values is row-major with Values[i*len(Grid[1])+j] corresponding to
+// (Grid[0][i], Grid[1][j]).
+type PartialDependence struct {
+	Features []int       `json:"features"`
+	Grid     [][]float64 `json:"grid"`
+	Values   []float64   `json:"values"`
+}
+
+// ICECurves holds one individual conditional expectation curve per
+// background example.  The average of the curves is the partial
+// dependence of the feature.
+type ICECurves struct {
+	Feature int         `json:"feature"`
+	Grid    []float64   `json:"grid"`
+	Curves  [][]float64 `json:"curves"`
+}
+
+// quantileGrid returns the grid of values for the feature - the unique
+// values if there are few enough, and evenly spaced percentiles otherwise
+func quantileGrid(e Examples, feature int, c PartialDependenceConfig) ([]float64, error) {
+	if len(e) == 0 {
+		return nil, fmt.Errorf("empty background set")
+	}
+	if c.GridResolution < 2 {
+		return nil, fmt.Errorf("grid resolution must be at least 2, got %v", c.GridResolution)
+	}
+	if c.LowerPercentile < 0 || c.UpperPercentile > 1 || c.LowerPercentile >= c.UpperPercentile {
+		return nil, fmt.Errorf("invalid percentiles [%v, %v]", c.LowerPercentile, c.UpperPercentile)
+	}
+
+	values := make([]float64, 0, len(e))
+	for _, ex := range e {
+		if feature >= len(ex.GetFeatures()) {
+			return nil, fmt.Errorf("feature %v out of range for example %v", feature, ex)
+		}
+		values = append(values, ex.GetFeatures()[feature])
+	}
+	sort.Float64s(values)
+
+	unique := make([]float64, 0, c.GridResolution)
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+			if len(unique) > c.GridResolution {
+				break
+			}
+		}
+	}
+	if len(unique) <= c.GridResolution {
+		return unique, nil
+	}
+
+	percentile := func(p float64) float64 {
+		position := p * float64(len(values)-1)
+		lower := int(math.Floor(position))
+		upper := int(math.Ceil(position))
+		return values[lower] + (position-float64(lower))*(values[upper]-values[lower])
+	}
+
+	low, high := percentile(c.LowerPercentile), percentile(c.UpperPercentile)
+	grid := make([]float64, c.GridResolution)
+	for i := range grid {
+		grid[i] = low + (high-low)*float64(i)/float64(c.GridResolution-1)
+	}
+	return grid, nil
+}
+
+// gridValue is a (feature, value) pair held fixed in computing partial
+// dependence
+type gridValue struct {
+	feature int
+	value   float64
+}
+
+// partialDependence computes the expected value of the subtree at index
+// with the given features fixed, weighting the branches of every other
+// split by their training cover
+func (s *shapTree) partialDependence(index int, fixed []gridValue) float64 {
+	n := s.nodes[index]
+	if n.leaf {
+		return n.value
+	}
+
+	for _, g := range fixed {
+		if g.feature == n.feature {
+			if g.value < n.splitValue {
+				return s.partialDependence(n.left, fixed)
+			}
+			return s.partialDependence(n.right, fixed)
+		}
+	}
+
+	leftFraction, rightFraction := s.zeroFractions(n)
+	return leftFraction*s.partialDependence(n.left, fixed) +
+		rightFraction*s.partialDependence(n.right, fixed)
+}
+
+type partialDependenceEvaluator struct {
+	background Examples
+	scale      float64
+
+	// Only set if using the recursion
+	trees []*shapTree
+	// Only set if using brute force
+	evaluator Evaluator
+}
+
+func newPartialDependenceEvaluator(
+	f *pb.Forest,
+	background Examples,
+	method PartialDependenceMethod) (*partialDependenceEvaluator, error) {
+	scale, err := marginScale(f)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &partialDependenceEvaluator{
+		background: background,
+		scale:      scale,
+	}
+
+	if method == PartialDependenceAuto || method == PartialDependenceRecursion {
+		trees := make([]*shapTree, 0, len(f.GetTrees()))
+		for _, t := range f.GetTrees() {
+			tree, err := newShapTree(t)
+			if err != nil {
+				if method == PartialDependenceRecursion {
+					return nil, err
+				}
+				trees = nil
+				break
+			}
+			trees = append(trees, tree)
+		}
+		p.trees = trees
+	}
+
+	if p.trees == nil {
+		evaluator, err := newUnscaledFastForestEvaluator(f)
+		if err != nil {
+			return nil, err
+		}
+		p.evaluator = evaluator
+	}
+	return p, nil
+}
+
+func (p *partialDependenceEvaluator) recursion(fixed []gridValue) float64 {
+	sum := 0.0
+	for _, t := range p.trees {
+		sum += t.partialDependence(0, fixed)
+	}
+	return sum * p.scale
+}
+
+// individualCurve evaluates the margin of a copy of the feature vector
+// with the given features fixed
+func (p *partialDependenceEvaluator) individualCurve(features []float64, fixed []gridValue) float64 {
+	fv := make([]float64, len(features))
+	copy(fv, features)
+	for _, g := range fixed {
+		fv[g.feature] = g.value
+	}
+	return p.evaluator.Evaluate(fv) * p.scale
+}
+
+func (p *partialDependenceEvaluator) bruteForce(fixed []gridValue) float64 {
+	sum := 0.0
+	for _, ex := range p.background {
+		sum += p.individualCurve(ex.GetFeatures(), fixed)
+	}
+	return sum / float64(len(p.background))
+}
+
+func (p *partialDependenceEvaluator) evaluate(fixed []gridValue) float64 {
+	if p.trees != nil {
+		return p.recursion(fixed)
+	}
+	return p.bruteForce(fixed)
+}
+
+// ComputePartialDependence computes the partial dependence of the margin
+// of the forest on one or two features over a quantile grid derived from
+// the background set.  The margin is the prediction before the sigmoid
+// for LOG_ODDS forests.  Brute force averages the other features over the
+// background set, while the recursion averages them over the training
+// cover of the trees and only uses the background set for the grid.
+func ComputePartialDependence(
+	f *pb.Forest,
+	background Examples,
+	features []int,
+	c PartialDependenceConfig) (*PartialDependence, error) {
+	if len(features) != 1 && len(features) != 2 {
+		return nil, fmt.Errorf("partial dependence requires one or two features, got %v", features)
+	}
+
+	result := &PartialDependence{
+		Features: features,
+		Grid:     make([][]float64, 0, len(features)),
+	}
+	for _, feature := range features {
+		grid, err := quantileGrid(background, feature, c)
+		if err != nil {
+			return nil, err
+		}
+		result.Grid = append(result.Grid, grid)
+	}
+
+	p, err := newPartialDependenceEvaluator(f, background, c.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enumerate the grid points in row-major order
+	points := make([][]gridValue, 0)
+	for _, v := range result.Grid[0] {
+		if len(features) == 1 {
+			points = append(points, []gridValue{{features[0], v}})
+			continue
+		}
+		for _, w := range result.Grid[1] {
+			points = append(points, []gridValue{{features[0], v}, {features[1], w}})
+		}
+	}
+
+	result.Values = make([]float64, len(points))
+	parallelFor(len(points), func(i int) {
+		result.Values[i] = p.evaluate(points[i])
+	})
+	return result, nil
+}
+
+// ComputeICECurves computes the individual conditional expectation curve
+// of the margin of the forest on the given feature for every example in
+// the background set.  This always evaluates the forest directly.
+func ComputeICECurves(
+	f *pb.Forest,
+	background Examples,
+	feature int,
+	c PartialDependenceConfig) (*ICECurves, error) {
+	grid, err := quantileGrid(background, feature, c)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPartialDependenceEvaluator(f, background, PartialDependenceBruteForce)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ICECurves{
+		Feature: feature,
+		Grid:    grid,
+		Curves:  make([][]float64, len(background)),
+	}
+	parallelFor(len(background), func(i int) {
+		curve := make([]float64, len(grid))
+		for j, v := range grid {
+			curve[j] = p.individualCurve(background[i].GetFeatures(), []gridValue{{feature, v}})
+		}
+		result.Curves[i] = curve
+	})
+	return result, nil
+}
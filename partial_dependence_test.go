@@ -0,0 +1,186 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// annotateCovers sets the NumExamples annotation on every node to the
+// number of examples in e that reach it
+func annotateCovers(t *pb.TreeNode, e Examples) {
+	t.Annotation = &pb.Annotation{NumExamples: proto.Int64(int64(len(e)))}
+	if isLeaf(t) {
+		return
+	}
+	left, right := Examples{}, Examples{}
+	for _, ex := range e {
+		if ex.Features[t.GetFeature()] < t.GetSplitValue() {
+			left = append(left, ex)
+		} else {
+			right = append(right, ex)
+		}
+	}
+	annotateCovers(t.GetLeft(), left)
+	annotateCovers(t.GetRight(), right)
+}
+
+func randomBackground(numExamples, numFeatures int) Examples {
+	result := make([]*pb.Example, 0, numExamples)
+	for i := 0; i < numExamples; i++ {
+		result = append(result, &pb.Example{
+			Features: randomFeatureVector(numFeatures),
+			Label:    proto.Float64(rand.Float64()),
+		})
+	}
+	return result
+}
+
+func TestQuantileGrid(t *testing.T) {
+	background := randomBackground(1000, 2)
+	grid, err := quantileGrid(background, 0, DefaultPartialDependenceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grid) != DefaultPartialDependenceConfig.GridResolution {
+		t.Fatalf("Expected %v grid points, got %v", DefaultPartialDependenceConfig.GridResolution, len(grid))
+	}
+	if math.Abs(grid[0]-0.05) > 0.03 || math.Abs(grid[len(grid)-1]-0.95) > 0.03 {
+		t.Fatalf("Unexpected grid bounds: %v, %v", grid[0], grid[len(grid)-1])
+	}
+
+	for _, ex := range background {
+		ex.Features[1] = float64(rand.Intn(3))
+	}
+	grid, err = quantileGrid(background, 1, DefaultPartialDependenceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grid) != 3 || grid[0] != 0 || grid[1] != 1 || grid[2] != 2 {
+		t.Fatalf("Expected the unique values, got %v", grid)
+	}
+}
+
+func TestRecursionMatchesBruteForceOnStumps(t *testing.T) {
+	numFeatures := 4
+	background := randomBackground(200, numFeatures)
+	forest := makeForest(20, 1, numFeatures)
+	forest.Rescaling = pb.Rescaling_AVERAGING.Enum()
+	for _, tree := range forest.GetTrees() {
+		annotateCovers(tree, background)
+	}
+
+	config := DefaultPartialDependenceConfig
+	config.GridResolution = 10
+	for _, features := range [][]int{{0}, {1, 2}} {
+		config.Method = PartialDependenceRecursion
+		recursion, err := ComputePartialDependence(forest, background, features, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Method = PartialDependenceBruteForce
+		bruteForce, err := ComputePartialDependence(forest, background, features, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(recursion.Values) != len(bruteForce.Values) {
+			t.Fatalf("Mismatched lengths %v, %v", len(recursion.Values), len(bruteForce.Values))
+		}
+		for i := range recursion.Values {
+			if math.Abs(recursion.Values[i]-bruteForce.Values[i]) > 1e-9 {
+				t.Fatalf("Point %v: recursion %v, brute force %v", i, recursion.Values[i], bruteForce.Values[i])
+			}
+		}
+	}
+}
+
+func TestICECurvesAverageToPartialDependence(t *testing.T) {
+	numFeatures := 5
+	background := randomBackground(100, numFeatures)
+	// No cover annotations, so this falls back to brute force
+	forest := makeForest(10, 3, numFeatures)
+
+	config := DefaultPartialDependenceConfig
+	config.GridResolution = 20
+	pd, err := ComputePartialDependence(forest, background, []int{3}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ice, err := ComputeICECurves(forest, background, 3, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ice.Curves) != len(background) {
+		t.Fatalf("Expected %v curves, got %v", len(background), len(ice.Curves))
+	}
+	for j := range ice.Grid {
+		sum := 0.0
+		for _, curve := range ice.Curves {
+			sum += curve[j]
+		}
+		if math.Abs(sum/float64(len(ice.Curves))-pd.Values[j]) > 1e-9 {
+			t.Fatalf("Grid point %v: ICE average %v, partial dependence %v", j, sum/float64(len(ice.Curves)), pd.Values[j])
+		}
+	}
+
+	config.Method = PartialDependenceRecursion
+	if _, err := ComputePartialDependence(forest, background, []int{3}, config); err == nil {
+		t.Fatal("Expected an error using the recursion without cover annotations")
+	}
+}
+
+func TestAutoPicksRecursionOnlyWithCovers(t *testing.T) {
+	// A stump on feature 1, with covers from a uniform training set
+	training := randomBackground(1000, 2)
+	tree := &pb.TreeNode{
+		Feature:    proto.Int64(1),
+		SplitValue: proto.Float64(0.5),
+		Left:       &pb.TreeNode{LeafValue: proto.Float64(0.0)},
+		Right:      &pb.TreeNode{LeafValue: proto.Float64(1.0)},
+	}
+	annotateCovers(tree, training)
+	forest := &pb.Forest{Trees: []*pb.TreeNode{tree}}
+
+	// Every background example goes left at the stump
+	background := randomBackground(100, 2)
+	for _, ex := range background {
+		ex.Features[1] *= 0.4
+	}
+
+	// The recursion averages over the training cover and brute force over
+	// the background set
+	uncovered := proto.Clone(forest).(*pb.Forest)
+	for _, n := range []*pb.TreeNode{uncovered.Trees[0], uncovered.Trees[0].Left, uncovered.Trees[0].Right} {
+		n.Annotation = nil
+	}
+	rightFraction := float64(tree.GetRight().GetAnnotation().GetNumExamples()) / float64(len(training))
+	testCases := []struct {
+		forest   *pb.Forest
+		method   PartialDependenceMethod
+		expected float64
+	}{
+		{forest, PartialDependenceRecursion, rightFraction},
+		{forest, PartialDependenceBruteForce, 0.0},
+		{forest, PartialDependenceAuto, rightFraction},
+		{uncovered, PartialDependenceAuto, 0.0},
+	}
+
+	config := DefaultPartialDependenceConfig
+	config.GridResolution = 5
+	for i, tc := range testCases {
+		config.Method = tc.method
+		pd, err := ComputePartialDependence(tc.forest, background, []int{0}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, v := range pd.Values {
+			if math.Abs(v-tc.expected) > 1e-9 {
+				t.Errorf("Case %v point %v: expected %v, got %v", i, j, tc.expected, v)
+			}
+		}
+	}
+}
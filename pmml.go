@@ -0,0 +1,635 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/xml"
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"io"
+)
+
+// The PMML subset written by WritePMML and read by ReadPMML.  A forest is
+// a MiningModel summing or averaging a TreeModel segment per tree.  Each
+// internal node has a left child with the predicate feature < split and a
+// right child with the predicate True, so a missing feature goes right as
+// NaN does.  Rescalings other than sums and averages chain the trees with
+// RegressionModels, identified by the ids of their segments.
+const (
+	pmmlVersion   = "4.3"
+	pmmlNamespace = "http://www.dmg.org/PMML-4_3"
+	pmmlTarget    = "prediction"
+
+	// Segment ids of the model chain
+	pmmlMarginSegment   = "margin"
+	pmmlLogOddsSegment  = "logOdds"
+	pmmlPlattSegment    = "platt"
+	pmmlIsotonicSegment = "isotonic"
+)
+
+type pmmlDocument struct {
+	XMLName        xml.Name           `xml:"PMML"`
+	Namespace      string             `xml:"xmlns,attr"`
+	Version        string             `xml:"version,attr"`
+	Header         pmmlHeader         `xml:"Header"`
+	DataDictionary pmmlDataDictionary `xml:"DataDictionary"`
+	MiningModel    *pmmlMiningModel   `xml:"MiningModel"`
+}
+
+type pmmlHeader struct {
+	Description string          `xml:"description,attr,omitempty"`
+	Application pmmlApplication `xml:"Application"`
+}
+
+type pmmlApplication struct {
+	Name string `xml:"name,attr"`
+}
+
+type pmmlDataDictionary struct {
+	NumberOfFields int             `xml:"numberOfFields,attr"`
+	DataFields     []pmmlDataField `xml:"DataField"`
+}
+
+type pmmlDataField struct {
+	Name     string `xml:"name,attr"`
+	OpType   string `xml:"optype,attr"`
+	DataType string `xml:"dataType,attr"`
+}
+
+type pmmlMiningSchema struct {
+	MiningFields []pmmlMiningField `xml:"MiningField"`
+}
+
+type pmmlMiningField struct {
+	Name      string `xml:"name,attr"`
+	UsageType string `xml:"usageType,attr,omitempty"`
+}
+
+type pmmlOutput struct {
+	OutputFields []pmmlOutputField `xml:"OutputField"`
+}
+
+type pmmlOutputField struct {
+	Name     string `xml:"name,attr"`
+	OpType   string `xml:"optype,attr"`
+	DataType string `xml:"dataType,attr"`
+	Feature  string `xml:"feature,attr"`
+}
+
+type pmmlMiningModel struct {
+	FunctionName string           `xml:"functionName,attr"`
+	MiningSchema pmmlMiningSchema `xml:"MiningSchema"`
+	Output       *pmmlOutput      `xml:"Output"`
+	Segmentation pmmlSegmentation `xml:"Segmentation"`
+}
+
+type pmmlSegmentation struct {
+	MultipleModelMethod string        `xml:"multipleModelMethod,attr"`
+	Segments            []pmmlSegment `xml:"Segment"`
+}
+
+type pmmlSegment struct {
+	ID              string               `xml:"id,attr"`
+	True            *struct{}            `xml:"True"`
+	TreeModel       *pmmlTreeModel       `xml:"TreeModel"`
+	MiningModel     *pmmlMiningModel     `xml:"MiningModel"`
+	RegressionModel *pmmlRegressionModel `xml:"RegressionModel"`
+}
+
+type pmmlTreeModel struct {
+	FunctionName         string           `xml:"functionName,attr"`
+	MissingValueStrategy string           `xml:"missingValueStrategy,attr"`
+	NoTrueChildStrategy  string           `xml:"noTrueChildStrategy,attr"`
+	SplitCharacteristic  string           `xml:"splitCharacteristic,attr"`
+	MiningSchema         pmmlMiningSchema `xml:"MiningSchema"`
+	Node                 *pmmlNode        `xml:"Node"`
+}
+
+type pmmlNode struct {
+	Score           *float64             `xml:"score,attr,omitempty"`
+	True            *struct{}            `xml:"True"`
+	SimplePredicate *pmmlSimplePredicate `xml:"SimplePredicate"`
+	Nodes           []*pmmlNode          `xml:"Node"`
+}
+
+type pmmlSimplePredicate struct {
+	Field    string  `xml:"field,attr"`
+	Operator string  `xml:"operator,attr"`
+	Value    float64 `xml:"value,attr"`
+}
+
+type pmmlRegressionModel struct {
+	FunctionName         string                    `xml:"functionName,attr"`
+	NormalizationMethod  string                    `xml:"normalizationMethod,attr"`
+	Extensions           []pmmlExtension           `xml:"Extension"`
+	MiningSchema         pmmlMiningSchema          `xml:"MiningSchema"`
+	Output               *pmmlOutput               `xml:"Output"`
+	LocalTransformations *pmmlLocalTransformations `xml:"LocalTransformations"`
+	RegressionTable      pmmlRegressionTable       `xml:"RegressionTable"`
+}
+
+type pmmlExtension struct {
+	Name  string  `xml:"name,attr"`
+	Value float64 `xml:"value,attr"`
+}
+
+type pmmlLocalTransformations struct {
+	DerivedFields []pmmlDerivedField `xml:"DerivedField"`
+}
+
+type pmmlDerivedField struct {
+	Name           string              `xml:"name,attr"`
+	OpType         string              `xml:"optype,attr"`
+	DataType       string              `xml:"dataType,attr"`
+	NormContinuous *pmmlNormContinuous `xml:"NormContinuous"`
+}
+
+type pmmlNormContinuous struct {
+	Field       string           `xml:"field,attr"`
+	Outliers    string           `xml:"outliers,attr"`
+	LinearNorms []pmmlLinearNorm `xml:"LinearNorm"`
+}
+
+type pmmlLinearNorm struct {
+	Orig float64 `xml:"orig,attr"`
+	Norm float64 `xml:"norm,attr"`
+}
+
+type pmmlRegressionTable struct {
+	Intercept         float64                `xml:"intercept,attr"`
+	NumericPredictors []pmmlNumericPredictor `xml:"NumericPredictor"`
+}
+
+type pmmlNumericPredictor struct {
+	Name        string  `xml:"name,attr"`
+	Coefficient float64 `xml:"coefficient,attr"`
+}
+
+func continuousOutput(name string) *pmmlOutput {
+	return &pmmlOutput{
+		OutputFields: []pmmlOutputField{{
+			Name:     name,
+			OpType:   "continuous",
+			DataType: "double",
+			Feature:  "predictedValue",
+		}},
+	}
+}
+
+// pmmlExporter holds the state of writing a forest as PMML
+type pmmlExporter struct {
+	featureNames []string
+	// used records the features each tree splits on
+	used map[int64]bool
+}
+
+func (p *pmmlExporter) node(t *pb.TreeNode, predicate *pmmlSimplePredicate) (*pmmlNode, error) {
+	n := &pmmlNode{SimplePredicate: predicate}
+	if predicate == nil {
+		n.True = &struct{}{}
+	}
+	if t.GetLeft() == nil && t.GetRight() == nil {
+		n.Score = proto.Float64(t.GetLeafValue())
+		return n, nil
+	}
+	if t.GetLeft() == nil || t.GetRight() == nil {
+		return nil, forestErrorf("node splitting on feature %v has a single child", t.GetFeature())
+	}
+
+	feature := t.GetFeature()
+	if feature < 0 || feature >= int64(len(p.featureNames)) {
+		return nil, forestErrorf("no name for feature %v", feature)
+	}
+	p.used[feature] = true
+	left, err := p.node(t.GetLeft(), &pmmlSimplePredicate{
+		Field:    p.featureNames[feature],
+		Operator: "lessThan",
+		Value:    t.GetSplitValue(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.node(t.GetRight(), nil)
+	if err != nil {
+		return nil, err
+	}
+	n.Nodes = []*pmmlNode{left, right}
+	return n, nil
+}
+
+// miningSchema returns the schema of the features used since the last call
+func (p *pmmlExporter) miningSchema() pmmlMiningSchema {
+	schema := pmmlMiningSchema{MiningFields: make([]pmmlMiningField, 0)}
+	for i, name := range p.featureNames {
+		if p.used[int64(i)] {
+			schema.MiningFields = append(schema.MiningFields, pmmlMiningField{Name: name})
+		}
+	}
+	p.used = make(map[int64]bool)
+	return schema
+}
+
+// trees returns the MiningModel summing or averaging the trees
+func (p *pmmlExporter) trees(f *pb.Forest, method string) (*pmmlMiningModel, error) {
+	if len(f.GetTrees()) == 0 {
+		return nil, forestErrorf("forest has no trees")
+	}
+	m := &pmmlMiningModel{
+		FunctionName: "regression",
+		Segmentation: pmmlSegmentation{MultipleModelMethod: method},
+	}
+	used := make(map[int64]bool)
+	for i, t := range f.GetTrees() {
+		root, err := p.node(t, nil)
+		if err != nil {
+			return nil, err
+		}
+		for feature := range p.used {
+			used[feature] = true
+		}
+		m.Segmentation.Segments = append(m.Segmentation.Segments, pmmlSegment{
+			ID:   fmt.Sprintf("%v", i+1),
+			True: &struct{}{},
+			TreeModel: &pmmlTreeModel{
+				FunctionName:         "regression",
+				MissingValueStrategy: "none",
+				NoTrueChildStrategy:  "returnLastPrediction",
+				SplitCharacteristic:  "binarySplit",
+				MiningSchema:         p.miningSchema(),
+				Node:                 root,
+			},
+		})
+	}
+	p.used = used
+	m.MiningSchema = p.miningSchema()
+	return m, nil
+}
+
+// logisticSegment returns the segment computing 1 / (1 + exp(-(a * x + b)))
+func logisticSegment(id, input, output string, a, b float64) pmmlSegment {
+	return pmmlSegment{
+		ID:   id,
+		True: &struct{}{},
+		RegressionModel: &pmmlRegressionModel{
+			FunctionName:        "regression",
+			NormalizationMethod: "logit",
+			MiningSchema:        pmmlMiningSchema{MiningFields: []pmmlMiningField{{Name: input}}},
+			Output:              continuousOutput(output),
+			RegressionTable: pmmlRegressionTable{
+				Intercept:         b,
+				NumericPredictors: []pmmlNumericPredictor{{Name: input, Coefficient: a}},
+			},
+		},
+	}
+}
+
+// isotonicSegment returns the segment interpolating the isotonic
+// calibrator, as a NormContinuous clamped outside the thresholds.  A
+// calibrator with a single threshold is constant, and the threshold is
+// kept in an Extension.
+func isotonicSegment(c *pb.Calibrator, input, output string) (pmmlSegment, error) {
+	thresholds, values := c.GetIsotonicThresholds(), c.GetIsotonicValues()
+	r := &pmmlRegressionModel{
+		FunctionName:        "regression",
+		NormalizationMethod: "none",
+		MiningSchema:        pmmlMiningSchema{MiningFields: []pmmlMiningField{{Name: input}}},
+		Output:              continuousOutput(output),
+	}
+	if len(thresholds) == 1 {
+		r.RegressionTable.Intercept = values[0]
+		r.Extensions = []pmmlExtension{{Name: pmmlIsotonicSegment, Value: thresholds[0]}}
+		return pmmlSegment{ID: pmmlIsotonicSegment, True: &struct{}{}, RegressionModel: r}, nil
+	}
+
+	norm := &pmmlNormContinuous{Field: input, Outliers: "asExtremeValues"}
+	for i := range thresholds {
+		if i > 0 && thresholds[i] == thresholds[i-1] {
+			return pmmlSegment{}, forestErrorf("isotonic calibrator has a repeated threshold %v", thresholds[i])
+		}
+		norm.LinearNorms = append(norm.LinearNorms, pmmlLinearNorm{Orig: thresholds[i], Norm: values[i]})
+	}
+	r.LocalTransformations = &pmmlLocalTransformations{
+		DerivedFields: []pmmlDerivedField{{
+			Name:           pmmlIsotonicSegment,
+			OpType:         "continuous",
+			DataType:       "double",
+			NormContinuous: norm,
+		}},
+	}
+	r.RegressionTable.NumericPredictors = []pmmlNumericPredictor{{Name: pmmlIsotonicSegment, Coefficient: 1}}
+	return pmmlSegment{ID: pmmlIsotonicSegment, True: &struct{}{}, RegressionModel: r}, nil
+}
+
+func (p *pmmlExporter) forest(f *pb.Forest) (*pmmlMiningModel, error) {
+	base := f.GetRescaling()
+	if base == pb.Rescaling_CALIBRATED {
+		if err := validateCalibrator(f.GetCalibrator()); err != nil {
+			return nil, err
+		}
+		base = f.GetCalibrator().GetBaseRescaling()
+	}
+
+	method := "sum"
+	switch base {
+	case pb.Rescaling_NONE, pb.Rescaling_LOG_ODDS:
+	case pb.Rescaling_AVERAGING:
+		method = "average"
+	default:
+		return nil, forestErrorf("unknown rescaling method: %v", base)
+	}
+	trees, err := p.trees(f, method)
+	if err != nil {
+		return nil, err
+	}
+	schema := trees.MiningSchema
+	schema.MiningFields = append(schema.MiningFields, pmmlMiningField{Name: pmmlTarget, UsageType: "target"})
+	if f.GetRescaling() == pb.Rescaling_NONE || f.GetRescaling() == pb.Rescaling_AVERAGING {
+		trees.MiningSchema = schema
+		return trees, nil
+	}
+
+	// Chain the margin through the rescaling and calibration
+	trees.Output = continuousOutput(pmmlMarginSegment)
+	segments := []pmmlSegment{{ID: pmmlMarginSegment, True: &struct{}{}, MiningModel: trees}}
+	score := pmmlMarginSegment
+	if base == pb.Rescaling_LOG_ODDS {
+		segments = append(segments, logisticSegment(pmmlLogOddsSegment, score, pmmlLogOddsSegment, 2, 0))
+		score = pmmlLogOddsSegment
+	}
+	if f.GetRescaling() == pb.Rescaling_CALIBRATED {
+		c := f.GetCalibrator()
+		if c.GetMethod() == pb.CalibrationMethod_PLATT {
+			segments = append(segments, logisticSegment(pmmlPlattSegment, score, pmmlPlattSegment, -c.GetPlattA(), -c.GetPlattB()))
+		} else {
+			segment, err := isotonicSegment(c, score, pmmlIsotonicSegment)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+	}
+
+	// The last segment gives the prediction
+	last := segments[len(segments)-1].RegressionModel
+	last.MiningSchema.MiningFields = append(last.MiningSchema.MiningFields, pmmlMiningField{Name: pmmlTarget, UsageType: "target"})
+	return &pmmlMiningModel{
+		FunctionName: "regression",
+		MiningSchema: schema,
+		Segmentation: pmmlSegmentation{MultipleModelMethod: "modelChain", Segments: segments},
+	}, nil
+}
+
+// WritePMML writes the forest as a PMML 4.3 MiningModel with a TreeModel
+// segment per tree.  featureNames holds the field name of each feature,
+// and features without one are named f0, f1, ...
+func WritePMML(w io.Writer, f *pb.Forest, featureNames []string) error {
+	numFeatures := int64(len(featureNames))
+	for _, t := range f.GetTrees() {
+		// p.node rejects negative features
+		feature, _ := maxFeature(t)
+		if n := feature + 1; n > numFeatures {
+			numFeatures = n
+		}
+	}
+	names := make([]string, numFeatures)
+	seen := make(map[string]bool)
+	for i := range names {
+		if i < len(featureNames) && featureNames[i] != "" {
+			names[i] = featureNames[i]
+		} else {
+			names[i] = fmt.Sprintf("f%v", i)
+		}
+		if seen[names[i]] || names[i] == pmmlTarget {
+			return forestErrorf("feature name %v is not unique", names[i])
+		}
+		seen[names[i]] = true
+	}
+
+	p := &pmmlExporter{featureNames: names, used: make(map[int64]bool)}
+	model, err := p.forest(f)
+	if err != nil {
+		return err
+	}
+
+	doc := &pmmlDocument{
+		Namespace: pmmlNamespace,
+		Version:   pmmlVersion,
+		Header: pmmlHeader{
+			Description: fmt.Sprintf("%v forest of %v trees", f.GetRescaling(), len(f.GetTrees())),
+			Application: pmmlApplication{Name: "decisiontrees"},
+		},
+		MiningModel: model,
+	}
+	for _, name := range append(names, pmmlTarget) {
+		doc.DataDictionary.DataFields = append(doc.DataDictionary.DataFields, pmmlDataField{
+			Name:     name,
+			OpType:   "continuous",
+			DataType: "double",
+		})
+	}
+	doc.DataDictionary.NumberOfFields = len(doc.DataDictionary.DataFields)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// pmmlImporter holds the state of reading a forest from PMML
+type pmmlImporter struct {
+	features map[string]int64
+}
+
+func (p *pmmlImporter) node(n *pmmlNode) (*pb.TreeNode, error) {
+	if len(n.Nodes) == 0 {
+		if n.Score == nil {
+			return nil, pmmlErrorf("leaf has no score")
+		}
+		return &pb.TreeNode{LeafValue: proto.Float64(*n.Score)}, nil
+	}
+	if len(n.Nodes) != 2 {
+		return nil, pmmlErrorf("node has %v children", len(n.Nodes))
+	}
+
+	left, right := n.Nodes[0], n.Nodes[1]
+	predicate := left.SimplePredicate
+	if predicate == nil || predicate.Operator != "lessThan" {
+		return nil, pmmlErrorf("left child must have a lessThan SimplePredicate")
+	}
+	if right.True == nil {
+		return nil, pmmlErrorf("right child must have a True predicate")
+	}
+	feature, ok := p.features[predicate.Field]
+	if !ok {
+		return nil, pmmlErrorf("unknown field %v", predicate.Field)
+	}
+
+	t := &pb.TreeNode{
+		Feature:    proto.Int64(feature),
+		SplitValue: proto.Float64(predicate.Value),
+	}
+	var err error
+	if t.Left, err = p.node(left); err != nil {
+		return nil, err
+	}
+	if t.Right, err = p.node(right); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *pmmlImporter) trees(m *pmmlMiningModel) ([]*pb.TreeNode, pb.Rescaling, error) {
+	var rescaling pb.Rescaling
+	switch m.Segmentation.MultipleModelMethod {
+	case "sum":
+		rescaling = pb.Rescaling_NONE
+	case "average":
+		rescaling = pb.Rescaling_AVERAGING
+	default:
+		return nil, 0, pmmlErrorf("unsupported multipleModelMethod %v", m.Segmentation.MultipleModelMethod)
+	}
+
+	trees := make([]*pb.TreeNode, 0, len(m.Segmentation.Segments))
+	for _, s := range m.Segmentation.Segments {
+		if s.TreeModel == nil || s.TreeModel.Node == nil {
+			return nil, 0, pmmlErrorf("segment %v is not a TreeModel", s.ID)
+		}
+		t, err := p.node(s.TreeModel.Node)
+		if err != nil {
+			return nil, 0, err
+		}
+		trees = append(trees, t)
+	}
+	return trees, rescaling, nil
+}
+
+// logistic returns the coefficient and intercept of a logisticSegment
+func logistic(s pmmlSegment) (float64, float64, error) {
+	r := s.RegressionModel
+	if r == nil || r.NormalizationMethod != "logit" || len(r.RegressionTable.NumericPredictors) != 1 {
+		return 0, 0, pmmlErrorf("segment %v is not a logistic RegressionModel", s.ID)
+	}
+	return r.RegressionTable.NumericPredictors[0].Coefficient, r.RegressionTable.Intercept, nil
+}
+
+func isotonic(s pmmlSegment) (thresholds []float64, values []float64, err error) {
+	r := s.RegressionModel
+	if r == nil || r.NormalizationMethod != "none" {
+		return nil, nil, pmmlErrorf("segment %v is not an isotonic RegressionModel", s.ID)
+	}
+	if r.LocalTransformations == nil {
+		if len(r.Extensions) != 1 || r.Extensions[0].Name != pmmlIsotonicSegment {
+			return nil, nil, pmmlErrorf("segment %v has no thresholds", s.ID)
+		}
+		return []float64{r.Extensions[0].Value}, []float64{r.RegressionTable.Intercept}, nil
+	}
+	fields := r.LocalTransformations.DerivedFields
+	if len(fields) != 1 || fields[0].NormContinuous == nil {
+		return nil, nil, pmmlErrorf("segment %v has no NormContinuous", s.ID)
+	}
+	for _, n := range fields[0].NormContinuous.LinearNorms {
+		thresholds = append(thresholds, n.Orig)
+		values = append(values, n.Norm)
+	}
+	return thresholds, values, nil
+}
+
+func (p *pmmlImporter) forest(m *pmmlMiningModel) (*pb.Forest, error) {
+	if m.Segmentation.MultipleModelMethod != "modelChain" {
+		trees, rescaling, err := p.trees(m)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Forest{Trees: trees, Rescaling: rescaling.Enum()}, nil
+	}
+
+	segments := m.Segmentation.Segments
+	if len(segments) == 0 || segments[0].ID != pmmlMarginSegment || segments[0].MiningModel == nil {
+		return nil, pmmlErrorf("model chain must start with the %v segment", pmmlMarginSegment)
+	}
+	trees, base, err := p.trees(segments[0].MiningModel)
+	if err != nil {
+		return nil, err
+	}
+	f := &pb.Forest{Trees: trees}
+	segments = segments[1:]
+	if len(segments) > 0 && segments[0].ID == pmmlLogOddsSegment {
+		if a, b, err := logistic(segments[0]); err != nil || a != 2 || b != 0 || base != pb.Rescaling_NONE {
+			return nil, pmmlErrorf("segment %v is not the log odds of the sum of the trees", pmmlLogOddsSegment)
+		}
+		base = pb.Rescaling_LOG_ODDS
+		segments = segments[1:]
+	}
+
+	switch {
+	case len(segments) == 0:
+		if base != pb.Rescaling_LOG_ODDS {
+			return nil, pmmlErrorf("model chain has no rescaling")
+		}
+		f.Rescaling = base.Enum()
+		return f, nil
+	case len(segments) > 1:
+		return nil, pmmlErrorf("model chain has %v unexpected segments", len(segments))
+	}
+
+	c := &pb.Calibrator{BaseRescaling: base.Enum()}
+	switch segments[0].ID {
+	case pmmlPlattSegment:
+		a, b, err := logistic(segments[0])
+		if err != nil {
+			return nil, err
+		}
+		c.Method = pb.CalibrationMethod_PLATT.Enum()
+		c.PlattA = proto.Float64(-a)
+		c.PlattB = proto.Float64(-b)
+	case pmmlIsotonicSegment:
+		thresholds, values, err := isotonic(segments[0])
+		if err != nil {
+			return nil, err
+		}
+		c.Method = pb.CalibrationMethod_ISOTONIC.Enum()
+		c.IsotonicThresholds = thresholds
+		c.IsotonicValues = values
+	default:
+		return nil, pmmlErrorf("unexpected segment %v", segments[0].ID)
+	}
+	if err := validateCalibrator(c); err != nil {
+		return nil, err
+	}
+	f.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	f.Calibrator = c
+	return f, nil
+}
+
+// ReadPMML reads a forest written by WritePMML, returning the forest and
+// the field name of each feature
+func ReadPMML(r io.Reader) (*pb.Forest, []string, error) {
+	doc := &pmmlDocument{}
+	if err := xml.NewDecoder(r).Decode(doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.MiningModel == nil {
+		return nil, nil, pmmlErrorf("document has no MiningModel")
+	}
+
+	p := &pmmlImporter{features: make(map[string]int64)}
+	names := make([]string, 0, len(doc.DataDictionary.DataFields))
+	for _, field := range doc.DataDictionary.DataFields {
+		if field.Name == pmmlTarget {
+			continue
+		}
+		p.features[field.Name] = int64(len(names))
+		names = append(names, field.Name)
+	}
+
+	f, err := p.forest(doc.MiningModel)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, names, nil
+}
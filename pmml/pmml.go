@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
+	"flag"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	forestPath       = flag.String("forest", "forest.json", "")
+	featureNamesPath = flag.String("feature_names", "", "file holding the name of feature i on line i + 1")
+	importPath       = flag.String("import", "", "PMML file to convert back to a forest, instead of exporting -forest")
+)
+
+func parseToProto(file string, protobuf proto.Message) error {
+	f, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(f, protobuf)
+}
+
+func importPMML(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer f.Close()
+
+	forest, names, err := dt.ReadPMML(f)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Imported %v trees on features %v", len(forest.GetTrees()), names)
+	serialized, err := json.Marshal(forest)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serialized)
+}
+
+// Writes the forest as PMML to stdout, checking that it reads back as the
+// same forest, or with -import writes the forest JSON of a PMML file
+func main() {
+	flag.Parse()
+	if *importPath != "" {
+		importPMML(*importPath)
+		return
+	}
+
+	forest := &pb.Forest{}
+	if err := parseToProto(*forestPath, forest); err != nil {
+		glog.Fatal(err)
+	}
+	var names []string
+	if *featureNamesPath != "" {
+		var err error
+		if names, err = dt.ReadFeatureNames(*featureNamesPath); err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := dt.WritePMML(&b, forest, names); err != nil {
+		glog.Fatal(err)
+	}
+	imported, _, err := dt.ReadPMML(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		glog.Fatal(err)
+	}
+	var roundTrip bytes.Buffer
+	if err := dt.WritePMML(&roundTrip, imported, names); err != nil {
+		glog.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), roundTrip.Bytes()) {
+		glog.Fatal("PMML does not read back as the same forest")
+	}
+	os.Stdout.Write(b.Bytes())
+}
@@ -0,0 +1,144 @@
+package decisiontrees
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"strings"
+	"testing"
+)
+
+func pmmlTestForests() []*pb.Forest {
+	forests := stagedTestForests()
+	forests[0].Rescaling = pb.Rescaling_NONE.Enum()
+	for _, base := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_LOG_ODDS} {
+		platt := makeForest(10, 3, 5)
+		platt.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+		platt.Calibrator = &pb.Calibrator{
+			Method:        pb.CalibrationMethod_PLATT.Enum(),
+			BaseRescaling: base.Enum(),
+			PlattA:        proto.Float64(-1.5),
+			PlattB:        proto.Float64(0.25),
+		}
+		isotonic := makeForest(10, 3, 5)
+		isotonic.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+		isotonic.Calibrator = &pb.Calibrator{
+			Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+			BaseRescaling:      base.Enum(),
+			IsotonicThresholds: []float64{0.1, 0.5, 0.9},
+			IsotonicValues:     []float64{0.0, 0.3, 1.0},
+		}
+		forests = append(forests, platt, isotonic)
+	}
+	constant := makeForest(10, 3, 5)
+	constant.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	constant.Calibrator = &pb.Calibrator{
+		Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+		BaseRescaling:      pb.Rescaling_NONE.Enum(),
+		IsotonicThresholds: []float64{0.25},
+		IsotonicValues:     []float64{0.75},
+	}
+	return append(forests, constant)
+}
+
+func TestPMMLRoundTrip(t *testing.T) {
+	names := []string{"age", "income", "num visits"}
+	for _, forest := range pmmlTestForests() {
+		var b bytes.Buffer
+		if err := WritePMML(&b, forest, names); err != nil {
+			t.Fatal(err)
+		}
+		imported, importedNames, err := ReadPMML(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(forest, imported) {
+			t.Errorf("%v: expected %v, got %v", forest.GetRescaling(), forest, imported)
+		}
+		for i, name := range names {
+			if i < len(importedNames) && importedNames[i] != name {
+				t.Errorf("expected feature %v to be named %v, got %v", i, name, importedNames[i])
+			}
+		}
+		for i := len(names); i < len(importedNames); i++ {
+			if expected := fmt.Sprintf("f%v", i); importedNames[i] != expected {
+				t.Errorf("expected feature %v to be named %v, got %v", i, expected, importedNames[i])
+			}
+		}
+
+		expected, err := NewRescaledFastForestEvaluator(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := NewRescaledFastForestEvaluator(imported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			fv := randomFeatureVector(5)
+			fv[i%5] = math.NaN()
+			if e, a := expected.Evaluate(fv), actual.Evaluate(fv); e != a {
+				t.Errorf("%v: expected %v, got %v", forest.GetRescaling(), e, a)
+			}
+		}
+	}
+}
+
+func TestPMMLChainsRescaling(t *testing.T) {
+	forests := pmmlTestForests()
+	expectations := []struct {
+		forest    *pb.Forest
+		fragments []string
+	}{
+		{forests[0], []string{`multipleModelMethod="sum"`, `<True></True>`, `operator="lessThan"`}},
+		{forests[1], []string{`multipleModelMethod="average"`}},
+		{forests[2], []string{`multipleModelMethod="modelChain"`, `normalizationMethod="logit"`, `coefficient="2"`}},
+		{forests[5], []string{`<NormContinuous field="margin" outliers="asExtremeValues">`, `<LinearNorm orig="0.5" norm="0.3">`}},
+	}
+	for _, e := range expectations {
+		var b bytes.Buffer
+		if err := WritePMML(&b, e.forest, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), `xmlns="http://www.dmg.org/PMML-4_3"`) {
+			t.Errorf("expected the PMML namespace in %v", b.String())
+		}
+		for _, fragment := range e.fragments {
+			if !strings.Contains(b.String(), fragment) {
+				t.Errorf("%v: expected %v in %v", e.forest.GetRescaling(), fragment, b.String())
+			}
+		}
+	}
+}
+
+func TestPMMLRejectsUnsupportedForests(t *testing.T) {
+	forest := makeForest(2, 2, 3)
+	if err := WritePMML(&bytes.Buffer{}, forest, []string{"a", "a", "a"}); err == nil {
+		t.Error("expected an error for repeated feature names")
+	}
+	if err := WritePMML(&bytes.Buffer{}, &pb.Forest{}, nil); err == nil {
+		t.Error("expected an error for an empty forest")
+	}
+
+	forest.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	forest.Calibrator = &pb.Calibrator{
+		Method:             pb.CalibrationMethod_ISOTONIC.Enum(),
+		IsotonicThresholds: []float64{0.1, 0.1},
+		IsotonicValues:     []float64{0.0, 1.0},
+	}
+	if err := WritePMML(&bytes.Buffer{}, forest, nil); err == nil {
+		t.Error("expected an error for repeated isotonic thresholds")
+	}
+
+	var b bytes.Buffer
+	if err := WritePMML(&b, makeForest(2, 2, 3), nil); err != nil {
+		t.Fatal(err)
+	}
+	unsupported := strings.Replace(b.String(), `operator="lessThan"`, `operator="lessOrEqual"`, -1)
+	_, _, err := ReadPMML(strings.NewReader(unsupported))
+	if _, ok := err.(*PMMLError); !ok {
+		t.Errorf("expected a PMMLError, got %v", err)
+	}
+}
@@ -58,17 +58,21 @@ const (
 	Rescaling_NONE      Rescaling = 1
 	Rescaling_AVERAGING Rescaling = 2
 	Rescaling_LOG_ODDS  Rescaling = 3
+	// Applies the forest's calibrator to the output of its base rescaling
+	Rescaling_CALIBRATED Rescaling = 4
 )
 
 var Rescaling_name = map[int32]string{
 	1: "NONE",
 	2: "AVERAGING",
 	3: "LOG_ODDS",
+	4: "CALIBRATED",
 }
 var Rescaling_value = map[string]int32{
-	"NONE":      1,
-	"AVERAGING": 2,
-	"LOG_ODDS":  3,
+	"NONE":       1,
+	"AVERAGING":  2,
+	"LOG_ODDS":   3,
+	"CALIBRATED": 4,
 }
 
 func (x Rescaling) Enum() *Rescaling {
@@ -91,6 +95,42 @@ func (x *Rescaling) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+type CalibrationMethod int32
+
+const (
+	CalibrationMethod_PLATT    CalibrationMethod = 1
+	CalibrationMethod_ISOTONIC CalibrationMethod = 2
+)
+
+var CalibrationMethod_name = map[int32]string{
+	1: "PLATT",
+	2: "ISOTONIC",
+}
+var CalibrationMethod_value = map[string]int32{
+	"PLATT":    1,
+	"ISOTONIC": 2,
+}
+
+func (x CalibrationMethod) Enum() *CalibrationMethod {
+	p := new(CalibrationMethod)
+	*p = x
+	return p
+}
+func (x CalibrationMethod) String() string {
+	return proto.EnumName(CalibrationMethod_name, int32(x))
+}
+func (x CalibrationMethod) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.String())
+}
+func (x *CalibrationMethod) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(CalibrationMethod_value, data, "CalibrationMethod")
+	if err != nil {
+		return err
+	}
+	*x = CalibrationMethod(value)
+	return nil
+}
+
 type Algorithm int32
 
 const (
@@ -227,6 +267,8 @@ type Example struct {
 	Label            *float64  `protobuf:"fixed64,1,opt,name=label" json:"label,omitempty" bson:"label,omitempty"`
 	WeightedLabel    *float64  `protobuf:"fixed64,2,opt,name=weightedLabel" json:"weightedLabel,omitempty" bson:"weightedLabel,omitempty"`
 	Features         []float64 `protobuf:"fixed64,3,rep,packed,name=features" json:"features,omitempty" bson:"features,omitempty"`
+	GroupId          *string   `protobuf:"bytes,4,opt,name=groupId" json:"groupId,omitempty" bson:"groupId,omitempty"`
+	Timestamp        *int64    `protobuf:"varint,5,opt,name=timestamp" json:"timestamp,omitempty" bson:"timestamp,omitempty"`
 	XXX_unrecognized []byte    `json:"-" bson:"-"`
 }
 
@@ -255,6 +297,20 @@ func (m *Example) GetFeatures() []float64 {
 	return nil
 }
 
+func (m *Example) GetGroupId() string {
+	if m != nil && m.GroupId != nil {
+		return *m.GroupId
+	}
+	return ""
+}
+
+func (m *Example) GetTimestamp() int64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
 type TrainingData struct {
 	Train            []*Example `protobuf:"bytes,1,rep,name=train" json:"train,omitempty" bson:"train,omitempty"`
 	Test             []*Example `protobuf:"bytes,2,rep,name=test" json:"test,omitempty" bson:"test,omitempty"`
@@ -279,6 +335,80 @@ func (m *TrainingData) GetTest() []*Example {
 	return nil
 }
 
+// SparseExample is an Example holding only its non-zero features
+type SparseExample struct {
+	Label            *float64   `protobuf:"fixed64,1,opt,name=label" json:"label,omitempty" bson:"label,omitempty"`
+	Features         []*Feature `protobuf:"bytes,2,rep,name=features" json:"features,omitempty" bson:"features,omitempty"`
+	GroupId          *string    `protobuf:"bytes,3,opt,name=groupId" json:"groupId,omitempty" bson:"groupId,omitempty"`
+	Timestamp        *int64     `protobuf:"varint,4,opt,name=timestamp" json:"timestamp,omitempty" bson:"timestamp,omitempty"`
+	XXX_unrecognized []byte     `json:"-" bson:"-"`
+}
+
+func (m *SparseExample) Reset()         { *m = SparseExample{} }
+func (m *SparseExample) String() string { return proto.CompactTextString(m) }
+func (*SparseExample) ProtoMessage()    {}
+
+func (m *SparseExample) GetLabel() float64 {
+	if m != nil && m.Label != nil {
+		return *m.Label
+	}
+	return 0
+}
+
+func (m *SparseExample) GetFeatures() []*Feature {
+	if m != nil {
+		return m.Features
+	}
+	return nil
+}
+
+func (m *SparseExample) GetGroupId() string {
+	if m != nil && m.GroupId != nil {
+		return *m.GroupId
+	}
+	return ""
+}
+
+func (m *SparseExample) GetTimestamp() int64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
+type SparseTrainingData struct {
+	Train []*SparseExample `protobuf:"bytes,1,rep,name=train" json:"train,omitempty" bson:"train,omitempty"`
+	Test  []*SparseExample `protobuf:"bytes,2,rep,name=test" json:"test,omitempty" bson:"test,omitempty"`
+	// one more than the largest feature of any example
+	NumFeatures      *int64 `protobuf:"varint,3,opt,name=numFeatures" json:"numFeatures,omitempty" bson:"numFeatures,omitempty"`
+	XXX_unrecognized []byte `json:"-" bson:"-"`
+}
+
+func (m *SparseTrainingData) Reset()         { *m = SparseTrainingData{} }
+func (m *SparseTrainingData) String() string { return proto.CompactTextString(m) }
+func (*SparseTrainingData) ProtoMessage()    {}
+
+func (m *SparseTrainingData) GetTrain() []*SparseExample {
+	if m != nil {
+		return m.Train
+	}
+	return nil
+}
+
+func (m *SparseTrainingData) GetTest() []*SparseExample {
+	if m != nil {
+		return m.Test
+	}
+	return nil
+}
+
+func (m *SparseTrainingData) GetNumFeatures() int64 {
+	if m != nil && m.NumFeatures != nil {
+		return *m.NumFeatures
+	}
+	return 0
+}
+
 type TreeNode struct {
 	// feature to split on
 	Feature *int64 `protobuf:"varint,1,opt,name=feature" json:"feature,omitempty" bson:"feature,omitempty"`
@@ -371,9 +501,73 @@ func (m *Annotation) GetLeftFraction() float64 {
 	return 0
 }
 
+type Calibrator struct {
+	Method *CalibrationMethod `protobuf:"varint,1,opt,name=method,enum=protobufs.CalibrationMethod" json:"method,omitempty" bson:"method,omitempty"`
+	// Rescaling applied to the sum of the trees before calibration
+	BaseRescaling *Rescaling `protobuf:"varint,2,opt,name=baseRescaling,enum=protobufs.Rescaling,def=1" json:"baseRescaling,omitempty" bson:"baseRescaling,omitempty"`
+	// Platt scaling, p = 1 / (1 + exp(plattA * score + plattB))
+	PlattA *float64 `protobuf:"fixed64,3,opt,name=plattA" json:"plattA,omitempty" bson:"plattA,omitempty"`
+	PlattB *float64 `protobuf:"fixed64,4,opt,name=plattB" json:"plattB,omitempty" bson:"plattB,omitempty"`
+	// Isotonic regression, interpolated linearly between the points
+	// (isotonicThresholds[i], isotonicValues[i]) and clamped outside them
+	IsotonicThresholds []float64 `protobuf:"fixed64,5,rep,packed,name=isotonicThresholds" json:"isotonicThresholds,omitempty" bson:"isotonicThresholds,omitempty"`
+	IsotonicValues     []float64 `protobuf:"fixed64,6,rep,packed,name=isotonicValues" json:"isotonicValues,omitempty" bson:"isotonicValues,omitempty"`
+	XXX_unrecognized   []byte    `json:"-" bson:"-"`
+}
+
+func (m *Calibrator) Reset()         { *m = Calibrator{} }
+func (m *Calibrator) String() string { return proto.CompactTextString(m) }
+func (*Calibrator) ProtoMessage()    {}
+
+const Default_Calibrator_BaseRescaling Rescaling = Rescaling_NONE
+
+func (m *Calibrator) GetMethod() CalibrationMethod {
+	if m != nil && m.Method != nil {
+		return *m.Method
+	}
+	return CalibrationMethod_PLATT
+}
+
+func (m *Calibrator) GetBaseRescaling() Rescaling {
+	if m != nil && m.BaseRescaling != nil {
+		return *m.BaseRescaling
+	}
+	return Default_Calibrator_BaseRescaling
+}
+
+func (m *Calibrator) GetPlattA() float64 {
+	if m != nil && m.PlattA != nil {
+		return *m.PlattA
+	}
+	return 0
+}
+
+func (m *Calibrator) GetPlattB() float64 {
+	if m != nil && m.PlattB != nil {
+		return *m.PlattB
+	}
+	return 0
+}
+
+func (m *Calibrator) GetIsotonicThresholds() []float64 {
+	if m != nil {
+		return m.IsotonicThresholds
+	}
+	return nil
+}
+
+func (m *Calibrator) GetIsotonicValues() []float64 {
+	if m != nil {
+		return m.IsotonicValues
+	}
+	return nil
+}
+
 type Forest struct {
-	Trees            []*TreeNode `protobuf:"bytes,1,rep,name=trees" json:"trees,omitempty" bson:"trees,omitempty"`
-	Rescaling        *Rescaling  `protobuf:"varint,2,opt,name=rescaling,enum=protobufs.Rescaling,def=1" json:"rescaling,omitempty" bson:"rescaling,omitempty"`
+	Trees     []*TreeNode `protobuf:"bytes,1,rep,name=trees" json:"trees,omitempty" bson:"trees,omitempty"`
+	Rescaling *Rescaling  `protobuf:"varint,2,opt,name=rescaling,enum=protobufs.Rescaling,def=1" json:"rescaling,omitempty" bson:"rescaling,omitempty"`
+	// Only used with CALIBRATED rescaling
+	Calibrator       *Calibrator `protobuf:"bytes,3,opt,name=calibrator" json:"calibrator,omitempty" bson:"calibrator,omitempty"`
 	XXX_unrecognized []byte      `json:"-" bson:"-"`
 }
 
@@ -397,6 +591,13 @@ func (m *Forest) GetRescaling() Rescaling {
 	return Default_Forest_Rescaling
 }
 
+func (m *Forest) GetCalibrator() *Calibrator {
+	if m != nil {
+		return m.Calibrator
+	}
+	return nil
+}
+
 type SplittingConstraints struct {
 	MaximumLevels        *int64   `protobuf:"varint,1,opt,name=maximumLevels" json:"maximumLevels,omitempty" bson:"maximumLevels,omitempty"`
 	MinimumAverageGain   *float64 `protobuf:"fixed64,2,opt,name=minimumAverageGain" json:"minimumAverageGain,omitempty" bson:"minimumAverageGain,omitempty"`
@@ -555,6 +756,8 @@ type ForestConfig struct {
 	ShrinkageConfig         *ShrinkageConfig         `protobuf:"bytes,5,opt,name=shrinkageConfig" json:"shrinkageConfig,omitempty" bson:"shrinkageConfig,omitempty"`
 	StochasticityConfig     *StochasticityConfig     `protobuf:"bytes,6,opt,name=stochasticityConfig" json:"stochasticityConfig,omitempty" bson:"stochasticityConfig,omitempty"`
 	Algorithm               *Algorithm               `protobuf:"varint,7,opt,name=algorithm,enum=protobufs.Algorithm" json:"algorithm,omitempty" bson:"algorithm,omitempty"`
+	Seed                    *int64                   `protobuf:"varint,8,opt,name=seed" json:"seed,omitempty" bson:"seed,omitempty"`
+	NumThreads              *int64                   `protobuf:"varint,9,opt,name=numThreads" json:"numThreads,omitempty" bson:"numThreads,omitempty"`
 	XXX_unrecognized        []byte                   `json:"-" bson:"-"`
 }
 
@@ -611,6 +814,20 @@ func (m *ForestConfig) GetAlgorithm() Algorithm {
 	return Algorithm_BOOSTING
 }
 
+func (m *ForestConfig) GetSeed() int64 {
+	if m != nil && m.Seed != nil {
+		return *m.Seed
+	}
+	return 0
+}
+
+func (m *ForestConfig) GetNumThreads() int64 {
+	if m != nil && m.NumThreads != nil {
+		return *m.NumThreads
+	}
+	return 0
+}
+
 type GridFsConfig struct {
 	Database         *string `protobuf:"bytes,1,opt,name=database" json:"database,omitempty" bson:"database,omitempty"`
 	Collection       *string `protobuf:"bytes,2,opt,name=collection,def=fs" json:"collection,omitempty" bson:"collection,omitempty"`
@@ -773,9 +990,58 @@ func (m *TrainingRow) GetTrainingResults() *TrainingResults {
 	return nil
 }
 
+type TrainingCheckpoint struct {
+	ForestConfig     *ForestConfig `protobuf:"bytes,1,opt,name=forestConfig" json:"forestConfig,omitempty" bson:"forestConfig,omitempty"`
+	Forest           *Forest       `protobuf:"bytes,2,opt,name=forest" json:"forest,omitempty" bson:"forest,omitempty"`
+	Round            *int64        `protobuf:"varint,3,opt,name=round" json:"round,omitempty" bson:"round,omitempty"`
+	Seed             *int64        `protobuf:"varint,4,opt,name=seed" json:"seed,omitempty" bson:"seed,omitempty"`
+	Predictions      []float64     `protobuf:"fixed64,5,rep,packed,name=predictions" json:"predictions,omitempty" bson:"predictions,omitempty"`
+	XXX_unrecognized []byte        `json:"-" bson:"-"`
+}
+
+func (m *TrainingCheckpoint) Reset()         { *m = TrainingCheckpoint{} }
+func (m *TrainingCheckpoint) String() string { return proto.CompactTextString(m) }
+func (*TrainingCheckpoint) ProtoMessage()    {}
+
+func (m *TrainingCheckpoint) GetForestConfig() *ForestConfig {
+	if m != nil {
+		return m.ForestConfig
+	}
+	return nil
+}
+
+func (m *TrainingCheckpoint) GetForest() *Forest {
+	if m != nil {
+		return m.Forest
+	}
+	return nil
+}
+
+func (m *TrainingCheckpoint) GetRound() int64 {
+	if m != nil && m.Round != nil {
+		return *m.Round
+	}
+	return 0
+}
+
+func (m *TrainingCheckpoint) GetSeed() int64 {
+	if m != nil && m.Seed != nil {
+		return *m.Seed
+	}
+	return 0
+}
+
+func (m *TrainingCheckpoint) GetPredictions() []float64 {
+	if m != nil {
+		return m.Predictions
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protobufs.LossFunction", LossFunction_name, LossFunction_value)
 	proto.RegisterEnum("protobufs.Rescaling", Rescaling_name, Rescaling_value)
+	proto.RegisterEnum("protobufs.CalibrationMethod", CalibrationMethod_name, CalibrationMethod_value)
 	proto.RegisterEnum("protobufs.Algorithm", Algorithm_name, Algorithm_value)
 	proto.RegisterEnum("protobufs.TrainingStatus", TrainingStatus_name, TrainingStatus_value)
 	proto.RegisterEnum("protobufs.DataSource", DataSource_name, DataSource_value)
@@ -7,52 +7,68 @@ import (
 	"sync"
 )
 
-func splitExamples(t *pb.TreeNode, e Examples) (left Examples, right Examples) {
-	by(func(e1, e2 *pb.Example) bool {
-		return e1.Features[t.GetFeature()] < e2.Features[t.GetFeature()]
-	}).Sort(e)
-	splitIndex := 0
-	for i, ex := range e {
-		splitIndex = i
-		if ex.Features[t.GetFeature()] > t.GetSplitValue() {
-			break
+// labelledExamples pairs examples with their weighted labels for a round
+// of training, as the examples themselves are read-only
+type labelledExamples struct {
+	examples Examples
+	labels   []float64
+}
+
+// splitExamples partitions the examples by the split of the node, as the
+// evaluators do, leaving e unmodified
+func splitExamples(t *pb.TreeNode, e labelledExamples) (left labelledExamples, right labelledExamples) {
+	for i, ex := range e.examples {
+		if ex.Features[t.GetFeature()] < t.GetSplitValue() {
+			left.examples = append(left.examples, ex)
+			left.labels = append(left.labels, e.labels[i])
+		} else {
+			right.examples = append(right.examples, ex)
+			right.labels = append(right.labels, e.labels[i])
 		}
 	}
-	left, right = e[:splitIndex], e[splitIndex:]
 	return
 }
 
 // TreeMapperFunc returns the mapped node and a boolean representing whether
 // we should continue traversal
-type TreeMapperFunc func(t *pb.TreeNode, e Examples) (*pb.TreeNode, bool)
+type TreeMapperFunc func(t *pb.TreeNode, e labelledExamples) (*pb.TreeNode, bool)
 
-func mapTree(t *pb.TreeNode, e Examples, m TreeMapperFunc) *pb.TreeNode {
-	left, right := splitExamples(t, e)
+func mapTree(t *pb.TreeNode, e labelledExamples, m TreeMapperFunc) *pb.TreeNode {
 	result, continueTraversal := m(t, e)
-	if continueTraversal == false {
+	if continueTraversal == false || isLeaf(t) {
 		return result
 	}
 
+	left, right := splitExamples(t, e)
 	if result.GetLeft() != nil {
-		result.Left, _ = m(t.GetLeft(), left)
+		result.Left = mapTree(t.GetLeft(), left, m)
 	}
 
 	if result.GetRight() != nil {
-		result.Right, _ = m(t.GetRight(), right)
+		result.Right = mapTree(t.GetRight(), right, m)
 	}
 
 	return result
 }
 
-func weakestLinkCostFunction(t *pb.TreeNode, e Examples) (float64, int) {
-	left, right := splitExamples(t, e)
+func weakestLinkCostFunction(t *pb.TreeNode, e labelledExamples) (float64, int) {
 	if !isLeaf(t) {
+		left, right := splitExamples(t, e)
 		leftSquaredDivergence, leftNodes := weakestLinkCostFunction(t.GetLeft(), left)
 		rightSquaredDivergence, rightNodes := weakestLinkCostFunction(t.GetRight(), right)
 		return leftSquaredDivergence + rightSquaredDivergence, leftNodes + rightNodes
 	}
 
-	return constructLoss(e).sumSquaredDivergence, 1
+	return leafCost(e), 1
+}
+
+// leafCost is the squared divergence of the labels from their mean
+func leafCost(e labelledExamples) float64 {
+	l := &lossState{}
+	for _, label := range e.labels {
+		l.add(label)
+	}
+	return l.sumSquaredDivergence
 }
 
 type pruner struct {
@@ -65,42 +81,43 @@ type prunedStage struct {
 	tree  *pb.TreeNode
 }
 
-//
-func (p *pruner) pruneTree(t *pb.TreeNode, e Examples) prunedStage {
-	bestNode, bestCost, bestLeaves := &pb.TreeNode{}, math.MaxFloat64, 0
-	mapTree(t, e, TreeMapperFunc(func(n *pb.TreeNode, ex Examples) (*pb.TreeNode, bool) {
-		nodeSquaredDivergence, nodeLeaves := weakestLinkCostFunction(n, ex)
-		nodeCost := nodeSquaredDivergence / float64(nodeLeaves)
-		if nodeCost < bestCost {
-			bestNode = t
-			bestCost = nodeCost
-			bestLeaves = nodeLeaves
+// pruneTree collapses the weakest link of the tree, the internal node
+// whose subtree reduces the cost the least per extra leaf
+func (p *pruner) pruneTree(t *pb.TreeNode, e labelledExamples) prunedStage {
+	bestNode, bestAlpha := &pb.TreeNode{}, math.MaxFloat64
+	mapTree(t, e, TreeMapperFunc(func(n *pb.TreeNode, ex labelledExamples) (*pb.TreeNode, bool) {
+		if isLeaf(n) {
+			return n, false
+		}
+		subtreeCost, subtreeLeaves := weakestLinkCostFunction(n, ex)
+		alpha := (leafCost(ex) - subtreeCost) / float64(subtreeLeaves-1)
+		if alpha < bestAlpha {
+			bestNode = n
+			bestAlpha = alpha
 		}
-		return proto.Clone(n).(*pb.TreeNode), true
+		return n, true
 	}))
 
-	prunedTree := mapTree(t, e, TreeMapperFunc(func(n *pb.TreeNode, ex Examples) (*pb.TreeNode, bool) {
+	prunedTree := mapTree(t, e, TreeMapperFunc(func(n *pb.TreeNode, ex labelledExamples) (*pb.TreeNode, bool) {
 		if n != bestNode {
 			return proto.Clone(n).(*pb.TreeNode), true
 		}
 
 		// Otherwise, return the leaf constructed by pruning all subtrees
-		leafWeight := p.lossFunction.GetLeafWeight(ex)
-		prior := p.lossFunction.GetPrior(ex)
+		leafWeight := p.lossFunction.GetLeafWeight(ex.examples)
+		prior := p.lossFunction.GetPrior(ex.examples)
 		return &pb.TreeNode{
 			LeafValue: proto.Float64(leafWeight * prior),
 		}, false
 	}))
 
-	rootCost, rootLeaves := weakestLinkCostFunction(t, e)
-	alpha := (rootCost - bestCost) / float64(rootLeaves-bestLeaves)
 	return prunedStage{
-		alpha: alpha,
+		alpha: bestAlpha,
 		tree:  prunedTree,
 	}
 }
 
-func (p *pruner) constructPrunedSequence(originalTree *pb.TreeNode, e Examples) []prunedStage {
+func (p *pruner) constructPrunedSequence(originalTree *pb.TreeNode, e labelledExamples) []prunedStage {
 	sequence := make([]prunedStage, 0)
 	sequence = append(sequence, prunedStage{0.0, originalTree})
 	for {
@@ -115,18 +132,29 @@ func (p *pruner) constructPrunedSequence(originalTree *pb.TreeNode, e Examples)
 	return sequence
 }
 
-func (p *pruner) Prune(t *pb.TreeNode, trainingSet Examples, testingSet Examples) *pb.TreeNode {
-	prunedSequence := p.constructPrunedSequence(t, trainingSet)
-	result := make([]float64, 0, len(prunedSequence))
+// Prune returns the tree of the pruned sequence with the lowest cost on
+// the testing set.  The labels are the weighted labels of the examples for
+// the round the tree was trained in, as returned by
+// LossFunction.WeightedLabels.
+func (p *pruner) Prune(
+	t *pb.TreeNode,
+	trainingSet Examples,
+	trainingLabels []float64,
+	testingSet Examples,
+	testingLabels []float64) *pb.TreeNode {
+	prunedSequence := p.constructPrunedSequence(t, labelledExamples{trainingSet, trainingLabels})
+	testing := labelledExamples{testingSet, testingLabels}
+	result := make([]float64, len(prunedSequence))
 	w := sync.WaitGroup{}
 	for i := range prunedSequence {
 		w.Add(1)
 		go func(pos int) {
-			rootCost, _ := weakestLinkCostFunction(prunedSequence[pos].tree, testingSet)
+			defer w.Done()
+			rootCost, _ := weakestLinkCostFunction(prunedSequence[pos].tree, testing)
 			result[pos] = rootCost / float64(len(testingSet))
 		}(i)
 	}
-	w.Done()
+	w.Wait()
 	minCost, minCostTree := math.MaxFloat64, &pb.TreeNode{}
 	for i, testingCost := range result {
 		if testingCost < minCost {
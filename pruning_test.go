@@ -0,0 +1,65 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"testing"
+)
+
+func TestPruningUsesWeightedLabels(t *testing.T) {
+	// The right subtree splits examples with identical labels, so it is
+	// the weakest link
+	tree := &pb.TreeNode{
+		Feature:    proto.Int64(0),
+		SplitValue: proto.Float64(0.5),
+		Left: &pb.TreeNode{
+			Feature:    proto.Int64(0),
+			SplitValue: proto.Float64(0.25),
+			Left:       &pb.TreeNode{LeafValue: proto.Float64(-1)},
+			Right:      &pb.TreeNode{LeafValue: proto.Float64(1)},
+		},
+		Right: &pb.TreeNode{
+			Feature:    proto.Int64(0),
+			SplitValue: proto.Float64(0.75),
+			Left:       &pb.TreeNode{LeafValue: proto.Float64(2)},
+			Right:      &pb.TreeNode{LeafValue: proto.Float64(2)},
+		},
+	}
+	examples := make(Examples, 0)
+	labels := make([]float64, 0)
+	for _, x := range []float64{0.9, 0.1, 0.6, 0.3, 0.8, 0.2} {
+		examples = append(examples, &pb.Example{Features: []float64{x}, Label: proto.Float64(x)})
+		switch {
+		case x < 0.25:
+			labels = append(labels, -1)
+		case x < 0.5:
+			labels = append(labels, 1)
+		default:
+			labels = append(labels, 2)
+		}
+	}
+	order := append(Examples(nil), examples...)
+
+	e := labelledExamples{examples, labels}
+	if cost, leaves := weakestLinkCostFunction(tree, e); cost != 0 || leaves != 4 {
+		t.Fatalf("Expected a perfect fit with 4 leaves, got %v, %v", cost, leaves)
+	}
+	if cost, _ := weakestLinkCostFunction(&pb.TreeNode{LeafValue: proto.Float64(0)}, e); cost == 0 {
+		t.Fatal("Expected a positive cost for a single leaf")
+	}
+
+	zero := &treeEvaluator{&pb.TreeNode{LeafValue: proto.Float64(0)}}
+	p := &pruner{lossFunction: leastAbsoluteDeviationLoss{evaluator: zero}}
+	stage := p.pruneTree(tree, e)
+	if stage.alpha != 0 || !isLeaf(stage.tree.GetRight()) || isLeaf(stage.tree.GetLeft()) {
+		t.Fatalf("Expected the right subtree to be pruned at alpha 0, got %v at %v", stage.tree, stage.alpha)
+	}
+	if sequence := p.constructPrunedSequence(tree, e); !isLeaf(sequence[len(sequence)-1].tree) {
+		t.Fatal("Expected the pruned sequence to end at a leaf")
+	}
+	for i := range examples {
+		if examples[i] != order[i] {
+			t.Fatal("Pruning reordered the examples")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package decisiontrees
+
+import (
+	"flag"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestQuickScorerMatchesFastEvaluator(t *testing.T) {
+	numFeatures := 10
+	forest := makeForest(100, 5, numFeatures)
+	// Too many leaves for a bitvector, so evaluated node by node
+	forest.Trees = append(forest.Trees, makeTree(7, numFeatures), makeTree(6, numFeatures))
+
+	fast, err := newUnscaledFastForestEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := newUnscaledQuickScorer(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.trees) != 102 || q.trees[100].fallback == nil || q.trees[101].fallback != nil {
+		t.Fatalf("expected only tree 100 to fall back, got %+v", q.trees[100:])
+	}
+
+	rows := make([][]float64, 0)
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, randomFeatureVector(numFeatures))
+	}
+	// Values equal to a threshold go right, and NaN goes right everywhere
+	for i := 0; i < 100; i++ {
+		fv := randomFeatureVector(numFeatures)
+		root := forest.Trees[rand.Intn(len(forest.Trees))]
+		fv[root.GetFeature()] = root.GetSplitValue()
+		rows = append(rows, fv)
+	}
+	fv := randomFeatureVector(numFeatures)
+	fv[forest.Trees[0].GetFeature()] = math.NaN()
+	rows = append(rows, fv)
+
+	predictions := make([]float64, len(rows))
+	q.EvaluateBatch(rows, predictions)
+	for i, fv := range rows {
+		expected := fast.Evaluate(fv)
+		if actual := q.Evaluate(fv); actual != expected {
+			t.Fatalf("row %v: expected %v, got %v", i, expected, actual)
+		}
+		if predictions[i] != expected {
+			t.Fatalf("row %v: expected batch prediction %v, got %v", i, expected, predictions[i])
+		}
+	}
+}
+
+func TestRescaledQuickScorer(t *testing.T) {
+	forest := makeForest(10, 3, 5)
+	forest.Rescaling = pb.Rescaling_LOG_ODDS.Enum()
+	fast, err := NewRescaledFastForestEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewRescaledQuickScorer(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		fv := randomFeatureVector(5)
+		if expected, actual := fast.Evaluate(fv), q.Evaluate(fv); actual != expected {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+
+	forest.Trees[0].Left = nil
+	if _, err := NewRescaledQuickScorer(forest); err == nil {
+		t.Error("expected an error for an invalid tree")
+	}
+}
+
+func quickScorerEvaluator(forest *pb.Forest) BatchEvaluator {
+	evaluator, err := newUnscaledQuickScorer(forest)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return evaluator
+}
+
+func BenchmarkQuickScorerEvaluation(b *testing.B) {
+	flag.Parse()
+	benchEvaluator(func(forest *pb.Forest) Evaluator { return quickScorerEvaluator(forest) }, b)
+}
+
+func BenchmarkQuickScorerBatchEvaluation(b *testing.B) {
+	flag.Parse()
+	benchBatch(b, quickScorerEvaluator, func(e BatchEvaluator, rows [][]float64, predictions []float64) {
+		e.EvaluateBatch(rows, predictions)
+	})
+}
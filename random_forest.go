@@ -2,9 +2,11 @@ package decisiontrees
 
 import (
 	// "code.google.com/p/goprotobuf/proto"
+	"context"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
-	// "github.com/golang/glog"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 func averageLabel(e Examples) float64 {
@@ -17,9 +19,28 @@ func averageLabel(e Examples) float64 {
 
 type randomForestGenerator struct {
 	forestConfig *pb.ForestConfig
+
+	// seed determines the bootstrap sample and sampled features of each
+	// tree
+	seed int64
+	// pool runs the trees and the construction of each tree
+	pool *workerPool
+}
+
+func newRandomForestGenerator(forestConfig *pb.ForestConfig) *randomForestGenerator {
+	seed := forestConfig.GetSeed()
+	if forestConfig.Seed == nil {
+		seed = time.Now().UnixNano()
+	}
+	return &randomForestGenerator{
+		forestConfig: forestConfig,
+		seed:         seed,
+		pool:         newWorkerPool(int(forestConfig.GetNumThreads())),
+	}
 }
 
-func (r *randomForestGenerator) constructRandomTree(e Examples) *pb.TreeNode {
+func (r *randomForestGenerator) constructRandomTree(e Examples, tree int) *pb.TreeNode {
+	rng := rand.New(rand.NewSource(r.seed + int64(tree)))
 	splitter := regressionSplitter{
 		leafWeight: averageLabel,
 		featureSelector: randomForestFeatureSelector{
@@ -27,25 +48,70 @@ func (r *randomForestGenerator) constructRandomTree(e Examples) *pb.TreeNode {
 		},
 		splittingConstraints: r.forestConfig.GetSplittingConstraints(),
 		shrinkageConfig:      r.forestConfig.GetShrinkageConfig(),
+		pool:                 r.pool,
+		seed:                 rng.Int63(),
 	}
-	return splitter.GenerateTree(e.boostrapExamples(
-		r.forestConfig.GetStochasticityConfig().GetExampleBoostrapProportion()))
+	sample := e.boostrapExamples(rng, r.forestConfig.GetStochasticityConfig().GetExampleBoostrapProportion())
+	// The trees split on the labels themselves
+	return splitter.GenerateTree(sample, labels(sample))
+}
+
+func (r *randomForestGenerator) ConstructForest(e Examples) (*pb.Forest, error) {
+	return r.ConstructForestWithContext(context.Background(), e, nil)
 }
 
-func (r *randomForestGenerator) ConstructForest(e Examples) *pb.Forest {
+func (r *randomForestGenerator) ConstructForestWithContext(
+	ctx context.Context,
+	e Examples,
+	observer TrainingObserver) (*pb.Forest, error) {
+	if len(e) == 0 {
+		return nil, ErrNoExamples
+	}
+
 	result := &pb.Forest{
 		Trees:     make([]*pb.TreeNode, int(r.forestConfig.GetNumWeakLearners())),
 		Rescaling: pb.Rescaling_AVERAGING.Enum(),
 	}
 
-	wg := sync.WaitGroup{}
+	// Trees in the order they completed, for reporting partial forests
+	var mu sync.Mutex
+	var firstErr error
+	completed := make([]*pb.TreeNode, 0, len(result.Trees))
+
+	g := r.pool.group()
 	for i := 0; i < int(r.forestConfig.GetNumWeakLearners()); i++ {
-		wg.Add(1)
-		go func(i int) {
-			result.Trees[i] = r.constructRandomTree(e)
-			wg.Done()
-		}(i)
-	}
-	wg.Wait()
-	return result
+		i := i
+		g.run(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			tree := r.constructRandomTree(e, i)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if firstErr != nil {
+				return
+			}
+			result.Trees[i] = tree
+			completed = append(completed, tree)
+			if observer != nil {
+				partial := snapshotForest(result, completed)
+				evaluator, err := NewRescaledFastForestEvaluator(partial)
+				if err != nil {
+					firstErr = &TrainingError{Round: len(completed) - 1, Err: err}
+					return
+				}
+				observer.OnRound(len(completed)-1, partial, computeEpochResult(evaluator, e))
+			}
+		})
+	}
+	g.wait()
+
+	if firstErr != nil {
+		return snapshotForest(result, completed), firstErr
+	}
+	if len(completed) < len(result.Trees) {
+		return snapshotForest(result, completed), ctx.Err()
+	}
+	return result, nil
 }
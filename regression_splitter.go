@@ -4,7 +4,8 @@ import (
 	"code.google.com/p/goprotobuf/proto"
 	pb "github.com/ajtulloch/decisiontrees/protobufs"
 	"github.com/golang/glog"
-	"sync"
+	"math/rand"
+	"sort"
 )
 
 type lossState struct {
@@ -13,27 +14,23 @@ type lossState struct {
 	numExamples          int
 }
 
-func constructLoss(e Examples) *lossState {
-	l := &lossState{}
-	for _, ex := range e {
-		l.addExample(ex)
-	}
-	return l
-}
-
-func (l *lossState) addExample(e *pb.Example) {
+func (l *lossState) add(weightedLabel float64) {
 	l.numExamples += 1
-	delta := e.GetWeightedLabel() - l.averageLabel
+	delta := weightedLabel - l.averageLabel
 	l.averageLabel += delta / float64(l.numExamples)
-	newDelta := e.GetWeightedLabel() - l.averageLabel
+	newDelta := weightedLabel - l.averageLabel
 	l.sumSquaredDivergence += delta * newDelta
 }
 
-func (l *lossState) removeExample(e *pb.Example) {
+func (l *lossState) remove(weightedLabel float64) {
 	l.numExamples -= 1
-	delta := e.GetWeightedLabel() - l.averageLabel
+	if l.numExamples == 0 {
+		*l = lossState{}
+		return
+	}
+	delta := weightedLabel - l.averageLabel
 	l.averageLabel -= delta / float64(l.numExamples)
-	newDelta := e.GetWeightedLabel() - l.averageLabel
+	newDelta := weightedLabel - l.averageLabel
 	l.sumSquaredDivergence -= delta * newDelta
 }
 
@@ -42,19 +39,25 @@ type regressionSplitter struct {
 	featureSelector      FeatureSelector
 	splittingConstraints *pb.SplittingConstraints
 	shrinkageConfig      *pb.ShrinkageConfig
+
+	// pool runs the feature scans and subtrees, or a pool of GOMAXPROCS
+	// goroutines if nil
+	pool *workerPool
+	// seed determines the features sampled at each node
+	seed int64
 }
 
 func (c *regressionSplitter) shouldSplit(
-	examples Examples,
+	numExamples int,
 	bestSplit split,
 	currentLevel int64) bool {
-	if len(examples) <= 1 {
-		glog.Infof("Num examples is %v, terminating", len(examples))
+	if numExamples <= 1 {
+		glog.Infof("Num examples is %v, terminating", numExamples)
 		return false
 	}
 
-	if bestSplit.index == 0 || bestSplit.index == len(examples) {
-		glog.Infof("Empty branch with bestSplit = %v, numExamples = %v, terminating", bestSplit, len(examples))
+	if bestSplit.index == 0 || bestSplit.index == numExamples {
+		glog.Infof("Empty branch with bestSplit = %v, numExamples = %v, terminating", bestSplit, numExamples)
 		return false
 	}
 
@@ -65,12 +68,12 @@ func (c *regressionSplitter) shouldSplit(
 	}
 
 	minAverageGain := c.splittingConstraints.MinimumAverageGain
-	if minAverageGain != nil && *minAverageGain > bestSplit.gain/float64(len(examples)) {
+	if minAverageGain != nil && *minAverageGain > bestSplit.gain/float64(numExamples) {
 		return false
 	}
 
 	minSamplesAtLeaf := c.splittingConstraints.MinimumSamplesAtLeaf
-	if minSamplesAtLeaf != nil && *minSamplesAtLeaf > int64(len(examples)) {
+	if minSamplesAtLeaf != nil && *minSamplesAtLeaf > int64(numExamples) {
 		return false
 	}
 	return true
@@ -82,30 +85,36 @@ type split struct {
 	gain    float64
 }
 
-func getBestSplit(examples Examples, feature int) split {
-	examplesCopy := make([]*pb.Example, len(examples))
-	if copy(examplesCopy, examples) != len(examples) {
-		glog.Fatal("Failed copying all examples for sorting")
+// getBestSplit finds the best split of the examples on the feature by
+// sorting them.  Tree construction uses the presorted
+// columnarExamples.bestSplit, which finds the same split.
+func getBestSplit(examples Examples, weightedLabels []float64, feature int) split {
+	order := make([]int, len(examples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return examples[order[i]].Features[feature] < examples[order[j]].Features[feature]
+	})
+
+	leftLoss := &lossState{}
+	rightLoss := &lossState{}
+	totalLoss := &lossState{}
+	for _, i := range order {
+		rightLoss.add(weightedLabels[i])
+		totalLoss.add(weightedLabels[i])
 	}
-
-	by(func(e1, e2 *pb.Example) bool {
-		return e1.Features[feature] < e2.Features[feature]
-	}).Sort(Examples(examplesCopy))
-
-	leftLoss := constructLoss(Examples{})
-	rightLoss := constructLoss(examplesCopy)
-	totalLoss := constructLoss(examplesCopy)
 	bestSplit := split{
 		feature: feature,
 	}
-	for index, example := range examplesCopy {
+	for index, i := range order {
 		func() {
 			if index == 0 {
 				return
 			}
 
-			previousValue := examplesCopy[index-1].Features[feature]
-			currentValue := example.Features[feature]
+			previousValue := examples[order[index-1]].Features[feature]
+			currentValue := examples[i].Features[feature]
 			if previousValue == currentValue {
 				return
 			}
@@ -119,67 +128,76 @@ func getBestSplit(examples Examples, feature int) split {
 			}
 		}()
 
-		leftLoss.addExample(example)
-		rightLoss.removeExample(example)
+		leftLoss.add(weightedLabels[i])
+		rightLoss.remove(weightedLabels[i])
 	}
 	return bestSplit
 }
 
-func (c *regressionSplitter) generateTree(examples Examples, currentLevel int64) *pb.TreeNode {
-	glog.Infof("Generating tree at level %v with %v examples", currentLevel, len(examples))
-	glog.V(2).Infof("Generating with examples %+v", currentLevel, examples)
-
-	features := c.featureSelector.getFeatures(examples)
-	candidateSplits := make(chan split, len(features))
-	for _, feature := range features {
-		go func(feature int) {
-			candidateSplits <- getBestSplit(examples, feature)
-		}(feature)
+func (c *regressionSplitter) generateTree(
+	data *columnarExamples,
+	r nodeRange,
+	currentLevel int64,
+	rng *rand.Rand) *pb.TreeNode {
+	numExamples := r.len()
+	glog.Infof("Generating tree at level %v with %v examples", currentLevel, numExamples)
+
+	features := c.featureSelector.getFeatures(data.nodeFeatures(r), rng)
+	candidateSplits := make([]split, len(features))
+	g := c.pool.group()
+	for i, feature := range features {
+		i, feature := i, feature
+		g.run(func() {
+			candidateSplits[i] = data.bestSplit(r, feature)
+		})
 	}
+	g.wait()
 
+	// Ties are broken by the lowest feature, so the tree does not depend
+	// on the order of the sampled features
 	bestSplit := split{}
-	for _ = range features {
-		candidateSplit := <-candidateSplits
-		if candidateSplit.gain > bestSplit.gain {
+	for _, candidateSplit := range candidateSplits {
+		if candidateSplit.gain > bestSplit.gain ||
+			(candidateSplit.gain > 0 && candidateSplit.gain == bestSplit.gain &&
+				candidateSplit.feature < bestSplit.feature) {
 			bestSplit = candidateSplit
 		}
 	}
 
-	if c.shouldSplit(examples, bestSplit, currentLevel) {
+	if c.shouldSplit(numExamples, bestSplit, currentLevel) {
 		glog.Infof("Splitting at level %v with split %v", currentLevel, bestSplit)
-		by(func(e1, e2 *pb.Example) bool {
-			return e1.Features[bestSplit.feature] < e2.Features[bestSplit.feature]
-		}).Sort(examples)
-
-		bestValue := 0.5 * (examples[bestSplit.index-1].Features[bestSplit.feature] +
-			examples[bestSplit.index].Features[bestSplit.feature])
+		bestValue := data.splitValue(r, bestSplit)
+		// Examples below the split value go left, as in evaluation
+		left, right := data.partition(r, bestSplit.feature, bestValue, c.pool)
 		tree := &pb.TreeNode{
 			Feature:    proto.Int64(int64(bestSplit.feature)),
 			SplitValue: proto.Float64(bestValue),
 			Annotation: &pb.Annotation{
-				NumExamples:  proto.Int64(int64(len(examples))),
-				AverageGain:  proto.Float64(bestSplit.gain / float64(len(examples))),
-				LeftFraction: proto.Float64(float64(bestSplit.index) / float64(len(examples))),
+				NumExamples:  proto.Int64(int64(numExamples)),
+				AverageGain:  proto.Float64(bestSplit.gain / float64(numExamples)),
+				LeftFraction: proto.Float64(float64(left.len()) / float64(numExamples)),
 			},
 		}
 
-		// Recur down the left and right branches in parallel
-		w := sync.WaitGroup{}
-		recur := func(child **pb.TreeNode, e Examples) {
-			w.Add(1)
-			go func() {
-				*child = c.generateTree(e, currentLevel+1)
-				w.Done()
-			}()
+		// Recur down the left and right branches in parallel, with the
+		// children's seeds drawn before either starts so that the features
+		// they sample do not depend on scheduling
+		g := c.pool.group()
+		recur := func(child **pb.TreeNode, r nodeRange, seed int64) {
+			g.run(func() {
+				*child = c.generateTree(data, r, currentLevel+1, rand.New(rand.NewSource(seed)))
+			})
 		}
 
-		recur(&tree.Left, examples[bestSplit.index:])
-		recur(&tree.Right, examples[:bestSplit.index])
-		w.Wait()
+		leftSeed, rightSeed := rng.Int63(), rng.Int63()
+		recur(&tree.Left, left, leftSeed)
+		recur(&tree.Right, right, rightSeed)
+		g.wait()
 		return tree
 	}
 
-	glog.Infof("Terminating at level %v with %v examples", currentLevel, len(examples))
+	glog.Infof("Terminating at level %v with %v examples", currentLevel, numExamples)
+	examples := data.nodeExamples(r)
 	glog.V(2).Infof("Terminating with examples: %v", examples)
 	// Otherwise, return the leaf
 	leafWeight := c.leafWeight(examples)
@@ -192,12 +210,17 @@ func (c *regressionSplitter) generateTree(examples Examples, currentLevel int64)
 	return &pb.TreeNode{
 		LeafValue: proto.Float64(leafWeight * shrinkage),
 		Annotation: &pb.Annotation{
-			NumExamples: proto.Int64(int64(len(examples))),
+			NumExamples: proto.Int64(int64(numExamples)),
 		},
 	}
 }
 
-// GenerateTree generates a regression tree on the examples given
-func (c *regressionSplitter) GenerateTree(examples Examples) *pb.TreeNode {
-	return c.generateTree(examples, 0)
+// GenerateTree generates a regression tree on the examples given, splitting
+// on the weighted label of each example
+func (c *regressionSplitter) GenerateTree(examples Examples, weightedLabels []float64) *pb.TreeNode {
+	if c.pool == nil {
+		c.pool = newWorkerPool(0)
+	}
+	data := newColumnarExamples(examples, weightedLabels, c.pool)
+	return c.generateTree(data, data.root(), 0, rand.New(rand.NewSource(c.seed)))
 }
@@ -32,6 +32,16 @@ func constructSmallExamples(numExamples int, numFeatures int) Examples {
 	return result
 }
 
+// weightedLabels returns the weighted labels the test examples were
+// constructed with
+func weightedLabels(e Examples) []float64 {
+	result := make([]float64, len(e))
+	for i, ex := range e {
+		result[i] = ex.GetWeightedLabel()
+	}
+	return result
+}
+
 // Tests that we split correctly on a trivial example
 // label == f[0] > 0.5
 func TestBestSplit(t *testing.T) {
@@ -57,7 +67,7 @@ func TestBestSplit(t *testing.T) {
 			WeightedLabel: proto.Float64(0.0),
 		},
 	}
-	bestSplit := getBestSplit(examples, 0 /* feature */)
+	bestSplit := getBestSplit(examples, weightedLabels(examples), 0 /* feature */)
 	if bestSplit.feature != 0 {
 		t.Fatal(bestSplit)
 	}
@@ -87,7 +97,7 @@ func TestRegressionSplitter(t *testing.T) {
 		shrinkageConfig: &pb.ShrinkageConfig{},
 	}
 
-	tree := rs.GenerateTree(examples)
+	tree := rs.GenerateTree(examples, weightedLabels(examples))
 	t.Logf("Tree: %+v", tree)
 }
 
@@ -138,10 +148,55 @@ func BenchmarkRegressionSplitter(b *testing.B) {
 	glog.Infof("Starting with %v examples", len(examples))
 
 	b.ResetTimer()
-	forest := generator.ConstructForest(examples)
+	forest, err := generator.ConstructForest(examples)
+	if err != nil {
+		glog.Fatal(err)
+	}
 	res, err := json.MarshalIndent(forest, "", "  ")
 	if err != nil {
 		glog.Fatalf("Error: %v", err)
 	}
 	glog.Info(res)
 }
+
+func benchmarkBestSplitExamples() Examples {
+	return constructWeightedExamples(10000, *numFeatures)
+}
+
+func BenchmarkBestSplitSorting(b *testing.B) {
+	examples := benchmarkBestSplitExamples()
+	targets := weightedLabels(examples)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for f := 0; f < *numFeatures; f++ {
+			getBestSplit(examples, targets, f)
+		}
+	}
+}
+
+func BenchmarkBestSplitPresorted(b *testing.B) {
+	examples := benchmarkBestSplitExamples()
+	data := newColumnarExamples(examples, weightedLabels(examples), newWorkerPool(0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for f := 0; f < *numFeatures; f++ {
+			data.bestSplit(data.root(), f)
+		}
+	}
+}
+
+func BenchmarkGenerateTree(b *testing.B) {
+	examples := benchmarkBestSplitExamples()
+	rs := &regressionSplitter{
+		leafWeight:      averageWeightedLabel,
+		featureSelector: naiveFeatureSelector{},
+		splittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(int64(*numLevels)),
+		},
+	}
+	targets := weightedLabels(examples)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs.GenerateTree(examples, targets)
+	}
+}
@@ -0,0 +1,131 @@
+package decisiontrees
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+)
+
+// ShortFeaturesPolicy is how a SafeEvaluator treats a feature vector with
+// fewer features than the forest uses
+type ShortFeaturesPolicy int
+
+const (
+	// RejectShortFeatures returns a FeatureError for short feature vectors
+	RejectShortFeatures ShortFeaturesPolicy = iota
+	// ZeroShortFeatures treats the missing trailing features as zero
+	ZeroShortFeatures
+	// MissingShortFeatures treats the missing trailing features as missing
+	// values, which go right at every split as NaN does
+	MissingShortFeatures
+)
+
+// SafeEvaluator evaluates a forest on untrusted feature vectors, returning
+// an error rather than panicking on input the forest cannot evaluate.
+// The unchecked evaluator from NewRescaledFastForestEvaluator is faster.
+type SafeEvaluator struct {
+	evaluator   Evaluator
+	numFeatures int
+	policy      ShortFeaturesPolicy
+}
+
+// maxFeature returns the largest feature the tree splits on, or -1 if
+// the tree is a leaf
+func maxFeature(t *pb.TreeNode) (int64, error) {
+	if isLeaf(t) {
+		return -1, nil
+	}
+	if t.GetFeature() < 0 {
+		return 0, forestErrorf("negative feature: %v", t.GetFeature())
+	}
+	result := t.GetFeature()
+	for _, child := range []*pb.TreeNode{t.GetLeft(), t.GetRight()} {
+		feature, err := maxFeature(child)
+		if err != nil {
+			return 0, err
+		}
+		if feature > result {
+			result = feature
+		}
+	}
+	return result, nil
+}
+
+// NumFeatures returns the length of the feature vectors the forest
+// expects, one more than the largest feature it splits on, or a
+// ForestError if the forest splits on a negative feature
+func NumFeatures(f *pb.Forest) (int, error) {
+	result := 0
+	for _, t := range f.GetTrees() {
+		feature, err := maxFeature(t)
+		if err != nil {
+			return 0, err
+		}
+		if int(feature)+1 > result {
+			result = int(feature) + 1
+		}
+	}
+	return result, nil
+}
+
+// NewSafeEvaluator returns a rescaled evaluator of the forest that checks
+// each feature vector against the features the forest uses
+func NewSafeEvaluator(f *pb.Forest, policy ShortFeaturesPolicy) (*SafeEvaluator, error) {
+	switch policy {
+	case RejectShortFeatures, ZeroShortFeatures, MissingShortFeatures:
+	default:
+		return nil, fmt.Errorf("unknown short features policy: %v", policy)
+	}
+
+	evaluator, err := NewRescaledFastForestEvaluator(f)
+	if err != nil {
+		return nil, err
+	}
+
+	numFeatures, err := NumFeatures(f)
+	if err != nil {
+		return nil, err
+	}
+	return &SafeEvaluator{evaluator: evaluator, numFeatures: numFeatures, policy: policy}, nil
+}
+
+// NumFeatures returns the length of the feature vectors the forest
+// expects, one more than the largest feature it splits on
+func (s *SafeEvaluator) NumFeatures() int {
+	return s.numFeatures
+}
+
+// Evaluate returns the prediction of the forest, or a FeatureError if the
+// feature vector is too short for the policy or a feature the forest
+// uses is NaN
+func (s *SafeEvaluator) Evaluate(features []float64) (float64, error) {
+	numGiven := len(features)
+	if numGiven > s.numFeatures {
+		numGiven = s.numFeatures
+	}
+	for i, value := range features[:numGiven] {
+		if math.IsNaN(value) {
+			return 0, &FeatureError{Feature: i, Reason: "value is NaN"}
+		}
+	}
+	if len(features) >= s.numFeatures {
+		return s.evaluator.Evaluate(features), nil
+	}
+
+	padding := 0.0
+	switch s.policy {
+	case RejectShortFeatures:
+		return 0, &FeatureError{
+			Feature: len(features),
+			Reason:  fmt.Sprintf("feature vector has %v features, the forest uses %v", len(features), s.numFeatures),
+		}
+	case MissingShortFeatures:
+		padding = math.NaN()
+	}
+	padded := make([]float64, s.numFeatures)
+	copy(padded, features)
+	for i := len(features); i < len(padded); i++ {
+		padded[i] = padding
+	}
+	return s.evaluator.Evaluate(padded), nil
+}
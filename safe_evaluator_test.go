@@ -0,0 +1,93 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"testing"
+)
+
+func safeEvaluatorForest() *pb.Forest {
+	leaf := func(v float64) *pb.TreeNode {
+		return &pb.TreeNode{LeafValue: proto.Float64(v)}
+	}
+	return &pb.Forest{
+		Trees: []*pb.TreeNode{{
+			Feature:    proto.Int64(2),
+			SplitValue: proto.Float64(0.5),
+			Left:       leaf(1.0),
+			Right: &pb.TreeNode{
+				Feature:    proto.Int64(0),
+				SplitValue: proto.Float64(0.5),
+				Left:       leaf(2.0),
+				Right:      leaf(3.0),
+			},
+		}},
+		Rescaling: pb.Rescaling_NONE.Enum(),
+	}
+}
+
+func TestSafeEvaluator(t *testing.T) {
+	nan := math.NaN()
+	cases := []struct {
+		policy   ShortFeaturesPolicy
+		features []float64
+		expected float64
+		// errFeature is the feature of the expected FeatureError, or -1
+		errFeature int
+	}{
+		{RejectShortFeatures, []float64{0, 0, 1}, 2.0, -1},
+		{RejectShortFeatures, []float64{0, 0, 0, nan}, 1.0, -1},
+		{RejectShortFeatures, []float64{0, 0}, 0, 2},
+		{RejectShortFeatures, []float64{nan, 0, 1}, 0, 0},
+		{ZeroShortFeatures, []float64{1}, 1.0, -1},
+		{ZeroShortFeatures, []float64{}, 1.0, -1},
+		{ZeroShortFeatures, []float64{0, nan}, 0, 1},
+		{MissingShortFeatures, []float64{1}, 3.0, -1},
+		{MissingShortFeatures, []float64{0}, 2.0, -1},
+	}
+	for _, c := range cases {
+		s, err := NewSafeEvaluator(safeEvaluatorForest(), c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.NumFeatures() != 3 {
+			t.Fatalf("expected 3 features, got %v", s.NumFeatures())
+		}
+		actual, err := s.Evaluate(c.features)
+		if c.errFeature >= 0 {
+			if e, ok := err.(*FeatureError); !ok || e.Feature != c.errFeature {
+				t.Errorf("%v %v: expected a FeatureError for feature %v, got %v", c.policy, c.features, c.errFeature, err)
+			}
+			continue
+		}
+		if err != nil || actual != c.expected {
+			t.Errorf("%v %v: expected %v, got %v, %v", c.policy, c.features, c.expected, actual, err)
+		}
+	}
+}
+
+func TestSafeEvaluatorRejectsInvalidForests(t *testing.T) {
+	forest := safeEvaluatorForest()
+	forest.Trees[0].Right.Feature = proto.Int64(-1)
+	if _, err := NewSafeEvaluator(forest, RejectShortFeatures); err == nil {
+		t.Error("expected an error for a negative feature")
+	}
+	if _, err := NewSafeEvaluator(safeEvaluatorForest(), ShortFeaturesPolicy(10)); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestNumFeatures(t *testing.T) {
+	if n, err := NumFeatures(safeEvaluatorForest()); err != nil || n != 3 {
+		t.Errorf("expected 3 features, got %v, %v", n, err)
+	}
+	if n, err := NumFeatures(&pb.Forest{Trees: []*pb.TreeNode{{LeafValue: proto.Float64(1.0)}}}); err != nil || n != 0 {
+		t.Errorf("expected no features for a leaf, got %v, %v", n, err)
+	}
+	forest := safeEvaluatorForest()
+	forest.Trees[0].Right.Feature = proto.Int64(-1)
+	if _, err := NumFeatures(forest); err == nil {
+		t.Error("expected an error for a negative feature")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
+	"flag"
+	dt "github.com/ajtulloch/decisiontrees"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	forestPath   = flag.String("forest", "forest.json", "")
+	dataPath     = flag.String("data", "train_data.json", "")
+	useTrainData = flag.Bool("use_train_data", false, "explain the train examples rather than the test examples")
+	interactions = flag.Bool("interactions", false, "output SHAP interaction values rather than contributions")
+)
+
+func parseToProto(file string, protobuf proto.Message) error {
+	f, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(f, protobuf)
+}
+
+func main() {
+	flag.Parse()
+	forest := &pb.Forest{}
+	if err := parseToProto(*forestPath, forest); err != nil {
+		glog.Fatal(err)
+	}
+
+	data := &pb.TrainingData{}
+	if err := parseToProto(*dataPath, data); err != nil {
+		glog.Fatal(err)
+	}
+
+	examples := data.GetTest()
+	if *useTrainData {
+		examples = data.GetTrain()
+	}
+	glog.Infof("Explaining %v examples", len(examples))
+
+	explainer, err := dt.NewShapExplainer(forest)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	rows := make([][]float64, 0, len(examples))
+	for _, ex := range examples {
+		rows = append(rows, ex.GetFeatures())
+	}
+
+	var result interface{}
+	if *interactions {
+		result = explainer.BatchExplainInteractions(rows)
+	} else {
+		result = explainer.BatchExplain(rows)
+	}
+
+	serialized, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		glog.Fatal(err)
+	}
+	os.Stdout.Write(serialized)
+}
@@ -5,5 +5,5 @@ import (
 )
 
 type splitter interface {
-	GenerateTree(examples Examples) *pb.TreeNode
+	GenerateTree(examples Examples, weightedLabels []float64) *pb.TreeNode
 }
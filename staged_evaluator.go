@@ -0,0 +1,81 @@
+package decisiontrees
+
+import (
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+)
+
+// StagedEvaluator evaluates a forest after each of its trees, as if the
+// forest were truncated to its first trees, in a single pass over the
+// forest
+type StagedEvaluator struct {
+	trees []*fastTreeEvaluator
+	// rescales holds the rescaling of the sum of the first i + 1 trees
+	rescales []func(float64) float64
+}
+
+// NewStagedEvaluator returns a StagedEvaluator for the forest, rescaling
+// each stage as NewRescaledFastForestEvaluator would rescale the forest
+// truncated to that stage
+func NewStagedEvaluator(f *pb.Forest) (*StagedEvaluator, error) {
+	e, err := newUnscaledFastForestEvaluator(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StagedEvaluator{
+		trees:    e.trees,
+		rescales: make([]func(float64) float64, 0, len(e.trees)),
+	}
+	for i := range e.trees {
+		rescale, err := prefixRescalingFunc(f, i+1)
+		if err != nil {
+			return nil, err
+		}
+		s.rescales = append(s.rescales, rescale)
+	}
+	return s, nil
+}
+
+// NumStages returns the number of trees in the forest
+func (s *StagedEvaluator) NumStages() int {
+	return len(s.trees)
+}
+
+// Contributions returns the unscaled value of each tree of the forest
+func (s *StagedEvaluator) Contributions(features []float64) []float64 {
+	result := make([]float64, 0, len(s.trees))
+	for _, t := range s.trees {
+		result = append(result, t.Evaluate(features))
+	}
+	return result
+}
+
+// EvaluateStaged returns the rescaled prediction of the first i + 1 trees
+// of the forest at index i
+func (s *StagedEvaluator) EvaluateStaged(features []float64) []float64 {
+	result := make([]float64, 0, len(s.trees))
+	sum := 0.0
+	for i, t := range s.trees {
+		sum += t.Evaluate(features)
+		result = append(result, s.rescales[i](sum))
+	}
+	return result
+}
+
+// evaluateStagedBatch evaluates the rows tree by tree, calling f with the
+// rescaled predictions of every row after each tree.  The predictions are
+// overwritten by the next stage.
+func (s *StagedEvaluator) evaluateStagedBatch(rows [][]float64, f func(stage int, predictions []float64)) {
+	sums := make([]float64, len(rows))
+	predictions := make([]float64, len(rows))
+	for i, t := range s.trees {
+		rescale := s.rescales[i]
+		parallelBlocks(len(rows), evaluationBlockSize, func(begin, end int) {
+			for j := begin; j < end; j++ {
+				sums[j] += t.Evaluate(rows[j])
+				predictions[j] = rescale(sums[j])
+			}
+		})
+		f(i, predictions)
+	}
+}
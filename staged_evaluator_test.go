@@ -0,0 +1,86 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"testing"
+)
+
+func stagedTestForests() []*pb.Forest {
+	forests := make([]*pb.Forest, 0)
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_AVERAGING, pb.Rescaling_LOG_ODDS} {
+		forest := makeForest(20, 3, 5)
+		forest.Rescaling = rescaling.Enum()
+		forests = append(forests, forest)
+	}
+	calibrated := makeForest(20, 3, 5)
+	calibrated.Rescaling = pb.Rescaling_CALIBRATED.Enum()
+	calibrated.Calibrator = &pb.Calibrator{
+		Method:        pb.CalibrationMethod_PLATT.Enum(),
+		BaseRescaling: pb.Rescaling_AVERAGING.Enum(),
+		PlattA:        proto.Float64(-2.0),
+		PlattB:        proto.Float64(0.5),
+	}
+	return append(forests, calibrated)
+}
+
+func TestStagedEvaluationMatchesTruncatedForests(t *testing.T) {
+	for _, forest := range stagedTestForests() {
+		s, err := NewStagedEvaluator(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.NumStages() != len(forest.GetTrees()) {
+			t.Fatalf("expected %v stages, got %v", len(forest.GetTrees()), s.NumStages())
+		}
+
+		fv := randomFeatureVector(5)
+		staged := s.EvaluateStaged(fv)
+		contributions := s.Contributions(fv)
+		for i := range forest.GetTrees() {
+			truncated := &pb.Forest{
+				Trees:      forest.GetTrees()[:i+1],
+				Rescaling:  forest.Rescaling,
+				Calibrator: forest.GetCalibrator(),
+			}
+			evaluator, err := NewRescaledFastForestEvaluator(truncated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := evaluator.Evaluate(fv); staged[i] != expected {
+				t.Errorf("%v stage %v: expected %v, got %v", forest.GetRescaling(), i, expected, staged[i])
+			}
+			if expected := (&treeEvaluator{forest.GetTrees()[i]}).Evaluate(fv); contributions[i] != expected {
+				t.Errorf("tree %v: expected contribution %v, got %v", i, expected, contributions[i])
+			}
+		}
+	}
+}
+
+func TestLearningCurveEvaluatesEveryPrefix(t *testing.T) {
+	examples := constructBenchmarkExamples(200, 5, 0.5)
+	for _, forest := range stagedTestForests() {
+		curve, err := LearningCurve(forest, examples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		epochs := curve.GetEpochResults()
+		if len(epochs) != len(forest.GetTrees()) {
+			t.Fatalf("expected %v epochs, got %v", len(forest.GetTrees()), len(epochs))
+		}
+		for i, epoch := range epochs {
+			evaluator, err := NewRescaledFastForestEvaluator(&pb.Forest{
+				Trees:      forest.GetTrees()[:i+1],
+				Rescaling:  forest.Rescaling,
+				Calibrator: forest.GetCalibrator(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := computeEpochResult(evaluator, examples)
+			if !proto.Equal(&expected, epoch) {
+				t.Errorf("%v epoch %v: expected %v, got %v", forest.GetRescaling(), i, &expected, epoch)
+			}
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package decisiontrees
+
+import (
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"sort"
+)
+
+// FeatureContributions decomposes a single prediction into a bias term
+// (the expected margin over the training distribution) plus one additive
+// contribution per feature.  Bias + sum(Contributions) equals the
+// margin of the forest for the given feature vector.
+type FeatureContributions struct {
+	Bias          float64   `json:"bias"`
+	Contributions []float64 `json:"contributions"`
+}
+
+// FeatureInteractions holds the SHAP interaction values for a single
+// prediction.  Interactions[i][j] for i != j is the interaction effect
+// between features i and j (split equally between [i][j] and [j][i]), and
+// Interactions[i][i] is the main effect of feature i.  Row i sums to the
+// SHAP value of feature i.
+type FeatureInteractions struct {
+	Bias         float64     `json:"bias"`
+	Interactions [][]float64 `json:"interactions"`
+}
+
+type shapNode struct {
+	feature    int
+	splitValue float64
+	left       int
+	right      int
+	value      float64
+	cover      float64
+	leaf       bool
+}
+
+type shapTree struct {
+	nodes         []shapNode
+	expectedValue float64
+}
+
+// ShapExplainer computes exact TreeSHAP feature attributions for a
+// forest, using the NumExamples annotations on each node as the cover
+// counts of the training distribution.
+//
+// Attributions are in margin space - i.e. before the sigmoid for LOG_ODDS
+// forests, and after dividing by the number of trees for AVERAGING
+// forests.
+type ShapExplainer struct {
+	trees    []*shapTree
+	scale    float64
+	features []int
+}
+
+func nodeCover(t *pb.TreeNode) float64 {
+	return float64(t.GetAnnotation().GetNumExamples())
+}
+
+// flattenShapTree appends the subtree rooted at t to s.nodes, returning
+// the index of t.  Nodes without a cover annotation take the sum of their
+// children's covers.
+func flattenShapTree(s *shapTree, t *pb.TreeNode) (int, error) {
+	index := len(s.nodes)
+	s.nodes = append(s.nodes, shapNode{})
+	if isLeaf(t) {
+		s.nodes[index] = shapNode{
+			value: t.GetLeafValue(),
+			cover: nodeCover(t),
+			leaf:  true,
+		}
+		return index, nil
+	}
+
+	left, err := flattenShapTree(s, t.GetLeft())
+	if err != nil {
+		return 0, err
+	}
+	right, err := flattenShapTree(s, t.GetRight())
+	if err != nil {
+		return 0, err
+	}
+
+	childCover := s.nodes[left].cover + s.nodes[right].cover
+	if childCover <= 0 {
+		return 0, forestErrorf("node has no cover annotations on its children: %v", t)
+	}
+
+	cover := nodeCover(t)
+	if cover <= 0 {
+		cover = childCover
+	}
+
+	s.nodes[index] = shapNode{
+		feature:    int(t.GetFeature()),
+		splitValue: t.GetSplitValue(),
+		left:       left,
+		right:      right,
+		cover:      cover,
+	}
+	return index, nil
+}
+
+// zeroFractions returns the fraction of the training distribution at the
+// given internal node that flows down the left and right branches
+func (s *shapTree) zeroFractions(n shapNode) (float64, float64) {
+	left, right := s.nodes[n.left].cover, s.nodes[n.right].cover
+	return left / (left + right), right / (left + right)
+}
+
+func (s *shapTree) computeExpectedValue(index int) float64 {
+	n := s.nodes[index]
+	if n.leaf {
+		return n.value
+	}
+	leftFraction, rightFraction := s.zeroFractions(n)
+	return leftFraction*s.computeExpectedValue(n.left) +
+		rightFraction*s.computeExpectedValue(n.right)
+}
+
+func newShapTree(t *pb.TreeNode) (*shapTree, error) {
+	if err := validateTree(t); err != nil {
+		return nil, err
+	}
+
+	s := &shapTree{}
+	if _, err := flattenShapTree(s, t); err != nil {
+		return nil, err
+	}
+	s.expectedValue = s.computeExpectedValue(0)
+	return s, nil
+}
+
+// NewShapExplainer returns a ShapExplainer for the given forest.  Every
+// internal node must have cover annotations on (at least) its children.
+func NewShapExplainer(f *pb.Forest) (*ShapExplainer, error) {
+	scale, err := marginScale(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShapExplainer{
+		trees: make([]*shapTree, 0, len(f.GetTrees())),
+		scale: scale,
+	}
+
+	features := make(map[int]bool)
+	for _, t := range f.GetTrees() {
+		tree, err := newShapTree(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range tree.nodes {
+			if !n.leaf {
+				features[n.feature] = true
+			}
+		}
+		s.trees = append(s.trees, tree)
+	}
+
+	for feature := range features {
+		s.features = append(s.features, feature)
+	}
+	sort.Ints(s.features)
+	return s, nil
+}
+
+// ExpectedValue returns the expected margin of the forest over the
+// training distribution, which is the bias term of every explanation.
+func (s *ShapExplainer) ExpectedValue() float64 {
+	sum := 0.0
+	for _, t := range s.trees {
+		sum += t.expectedValue
+	}
+	return sum * s.scale
+}
+
+// pathElement tracks a feature on the unique path from the root to the
+// current node, along with the proportion of all subsets that flow
+// through the path.
+type pathElement struct {
+	feature      int
+	zeroFraction float64
+	oneFraction  float64
+	weight       float64
+}
+
+func extendPath(path []pathElement, depth int, zeroFraction, oneFraction float64, feature int) {
+	path[depth] = pathElement{
+		feature:      feature,
+		zeroFraction: zeroFraction,
+		oneFraction:  oneFraction,
+	}
+	if depth == 0 {
+		path[depth].weight = 1.0
+	}
+
+	for i := depth - 1; i >= 0; i-- {
+		path[i+1].weight += oneFraction * path[i].weight * float64(i+1) / float64(depth+1)
+		path[i].weight = zeroFraction * path[i].weight * float64(depth-i) / float64(depth+1)
+	}
+}
+
+func unwindPath(path []pathElement, depth int, index int) {
+	oneFraction, zeroFraction := path[index].oneFraction, path[index].zeroFraction
+	nextOnePortion := path[depth].weight
+
+	for i := depth - 1; i >= 0; i-- {
+		if oneFraction != 0 {
+			tmp := path[i].weight
+			path[i].weight = nextOnePortion * float64(depth+1) / (float64(i+1) * oneFraction)
+			nextOnePortion = tmp - path[i].weight*zeroFraction*float64(depth-i)/float64(depth+1)
+		} else {
+			path[i].weight = path[i].weight * float64(depth+1) / (zeroFraction * float64(depth-i))
+		}
+	}
+
+	for i := index; i < depth; i++ {
+		path[i].feature = path[i+1].feature
+		path[i].zeroFraction = path[i+1].zeroFraction
+		path[i].oneFraction = path[i+1].oneFraction
+	}
+}
+
+// unwoundPathSum returns the total permutation weight of the path if the
+// element at index were unwound, without modifying the path
+func unwoundPathSum(path []pathElement, depth int, index int) float64 {
+	oneFraction, zeroFraction := path[index].oneFraction, path[index].zeroFraction
+	nextOnePortion := path[depth].weight
+	total := 0.0
+
+	for i := depth - 1; i >= 0; i-- {
+		if oneFraction != 0 {
+			tmp := nextOnePortion * float64(depth+1) / (float64(i+1) * oneFraction)
+			total += tmp
+			nextOnePortion = path[i].weight - tmp*zeroFraction*float64(depth-i)/float64(depth+1)
+		} else if zeroFraction != 0 {
+			total += path[i].weight / zeroFraction / (float64(depth-i) / float64(depth+1))
+		}
+	}
+	return total
+}
+
+// shapCondition restricts the attribution to the subsets where
+// conditionFeature is always present (condition > 0) or always absent
+// (condition < 0).  It is used to compute interaction values.
+type shapCondition struct {
+	condition int
+	feature   int
+}
+
+func (s *shapTree) recurse(
+	features []float64,
+	phi []float64,
+	index int,
+	depth int,
+	parentPath []pathElement,
+	parentZeroFraction float64,
+	parentOneFraction float64,
+	parentFeature int,
+	c shapCondition,
+	conditionFraction float64) {
+	if conditionFraction == 0 {
+		return
+	}
+
+	path := make([]pathElement, depth+1)
+	copy(path, parentPath)
+
+	if c.condition == 0 || c.feature != parentFeature {
+		extendPath(path, depth, parentZeroFraction, parentOneFraction, parentFeature)
+	}
+
+	n := s.nodes[index]
+	if n.leaf {
+		for i := 1; i <= depth; i++ {
+			w := unwoundPathSum(path, depth, i)
+			el := path[i]
+			phi[el.feature] += w * (el.oneFraction - el.zeroFraction) * n.value * conditionFraction
+		}
+		return
+	}
+
+	leftFraction, rightFraction := s.zeroFractions(n)
+	hot, cold := n.left, n.right
+	hotZeroFraction, coldZeroFraction := leftFraction, rightFraction
+	if !(features[n.feature] < n.splitValue) {
+		hot, cold = cold, hot
+		hotZeroFraction, coldZeroFraction = coldZeroFraction, hotZeroFraction
+	}
+
+	// If we have already split on this feature, undo that split so the
+	// feature only appears once on the unique path
+	incomingZeroFraction, incomingOneFraction := 1.0, 1.0
+	pathIndex := 0
+	for ; pathIndex <= depth; pathIndex++ {
+		if path[pathIndex].feature == n.feature {
+			break
+		}
+	}
+	if pathIndex != depth+1 {
+		incomingZeroFraction = path[pathIndex].zeroFraction
+		incomingOneFraction = path[pathIndex].oneFraction
+		unwindPath(path, depth, pathIndex)
+		depth--
+	}
+
+	hotConditionFraction, coldConditionFraction := conditionFraction, conditionFraction
+	if c.condition > 0 && n.feature == c.feature {
+		coldConditionFraction = 0
+		depth--
+	} else if c.condition < 0 && n.feature == c.feature {
+		hotConditionFraction *= hotZeroFraction
+		coldConditionFraction *= coldZeroFraction
+		depth--
+	}
+
+	s.recurse(
+		features, phi, hot, depth+1, path,
+		hotZeroFraction*incomingZeroFraction, incomingOneFraction,
+		n.feature, c, hotConditionFraction)
+	s.recurse(
+		features, phi, cold, depth+1, path,
+		coldZeroFraction*incomingZeroFraction, 0,
+		n.feature, c, coldConditionFraction)
+}
+
+func (s *shapTree) shap(features []float64, phi []float64, c shapCondition) {
+	// The root is attached to a dummy path element with feature -1, which
+	// is never attributed
+	s.recurse(features, phi, 0, 0, nil, 1, 1, -1, c, 1)
+}
+
+func (s *ShapExplainer) conditionalContributions(features []float64, c shapCondition) []float64 {
+	phi := make([]float64, len(features))
+	for _, t := range s.trees {
+		t.shap(features, phi, c)
+	}
+	for i := range phi {
+		phi[i] *= s.scale
+	}
+	return phi
+}
+
+// Explain returns the per-feature contributions for a single feature
+// vector.
+func (s *ShapExplainer) Explain(features []float64) FeatureContributions {
+	return FeatureContributions{
+		Bias:          s.ExpectedValue(),
+		Contributions: s.conditionalContributions(features, shapCondition{}),
+	}
+}
+
+// ExplainInteractions returns the SHAP interaction values for a single
+// feature vector.  This is roughly O(number of features used by the
+// forest) times as expensive as Explain.
+func (s *ShapExplainer) ExplainInteractions(features []float64) FeatureInteractions {
+	result := FeatureInteractions{
+		Bias:         s.ExpectedValue(),
+		Interactions: make([][]float64, len(features)),
+	}
+	for i := range result.Interactions {
+		result.Interactions[i] = make([]float64, len(features))
+	}
+
+	diagonal := s.conditionalContributions(features, shapCondition{})
+	for _, i := range s.features {
+		on := s.conditionalContributions(features, shapCondition{condition: 1, feature: i})
+		off := s.conditionalContributions(features, shapCondition{condition: -1, feature: i})
+		for j := range features {
+			if j == i {
+				continue
+			}
+			interaction := (on[j] - off[j]) / 2
+			result.Interactions[i][j] = interaction
+			diagonal[i] -= interaction
+		}
+	}
+
+	for i := range features {
+		result.Interactions[i][i] = diagonal[i]
+	}
+	return result
+}
+
+// BatchExplain returns the per-feature contributions for each of the
+// given feature vectors.
+func (s *ShapExplainer) BatchExplain(rows [][]float64) []FeatureContributions {
+	result := make([]FeatureContributions, len(rows))
+	parallelFor(len(rows), func(i int) {
+		result[i] = s.Explain(rows[i])
+	})
+	return result
+}
+
+// BatchExplainInteractions returns the SHAP interaction values for each
+// of the given feature vectors.
+func (s *ShapExplainer) BatchExplainInteractions(rows [][]float64) []FeatureInteractions {
+	result := make([]FeatureInteractions, len(rows))
+	parallelFor(len(rows), func(i int) {
+		result[i] = s.ExplainInteractions(rows[i])
+	})
+	return result
+}
@@ -0,0 +1,236 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func makeCoveredTree(level int, numFeatures int) *pb.TreeNode {
+	if level == 0 {
+		return &pb.TreeNode{
+			LeafValue: proto.Float64(rand.NormFloat64()),
+			Annotation: &pb.Annotation{
+				NumExamples: proto.Int64(1 + rand.Int63n(100)),
+			},
+		}
+	}
+	t := &pb.TreeNode{
+		Feature:    proto.Int64(rand.Int63n(int64(numFeatures))),
+		SplitValue: proto.Float64(rand.Float64()),
+		Left:       makeCoveredTree(level-1, numFeatures),
+		Right:      makeCoveredTree(level-1, numFeatures),
+	}
+	t.Annotation = &pb.Annotation{
+		NumExamples: proto.Int64(
+			t.GetLeft().GetAnnotation().GetNumExamples() +
+				t.GetRight().GetAnnotation().GetNumExamples()),
+	}
+	return t
+}
+
+func makeCoveredForest(numTrees, numLevels, numFeatures int, rescaling pb.Rescaling) *pb.Forest {
+	f := &pb.Forest{Rescaling: rescaling.Enum()}
+	for i := 0; i < numTrees; i++ {
+		f.Trees = append(f.Trees, makeCoveredTree(numLevels, numFeatures))
+	}
+	return f
+}
+
+// conditionalExpectation computes E[f(x) | x_S] under the cover
+// distribution, following x on features in S and averaging over both
+// branches (weighted by cover) otherwise
+func conditionalExpectation(t *pb.TreeNode, features []float64, subset map[int]bool) float64 {
+	if isLeaf(t) {
+		return t.GetLeafValue()
+	}
+	if subset[int(t.GetFeature())] {
+		if features[t.GetFeature()] < t.GetSplitValue() {
+			return conditionalExpectation(t.GetLeft(), features, subset)
+		}
+		return conditionalExpectation(t.GetRight(), features, subset)
+	}
+	left, right := nodeCover(t.GetLeft()), nodeCover(t.GetRight())
+	return (left*conditionalExpectation(t.GetLeft(), features, subset) +
+		right*conditionalExpectation(t.GetRight(), features, subset)) / (left + right)
+}
+
+func forestConditionalExpectation(f *pb.Forest, features []float64, subset map[int]bool) float64 {
+	sum := 0.0
+	for _, t := range f.GetTrees() {
+		sum += conditionalExpectation(t, features, subset)
+	}
+	if f.GetRescaling() == pb.Rescaling_AVERAGING {
+		sum /= float64(len(f.GetTrees()))
+	}
+	return sum
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
+
+func subsetFromMask(mask int, numFeatures int) (map[int]bool, int) {
+	subset := make(map[int]bool)
+	for i := 0; i < numFeatures; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			subset[i] = true
+		}
+	}
+	return subset, len(subset)
+}
+
+func withFeatures(subset map[int]bool, features ...int) map[int]bool {
+	result := make(map[int]bool)
+	for k := range subset {
+		result[k] = true
+	}
+	for _, f := range features {
+		result[f] = true
+	}
+	return result
+}
+
+// bruteForceShap computes the exact Shapley values by enumerating every
+// subset of features
+func bruteForceShap(f *pb.Forest, features []float64) []float64 {
+	m := len(features)
+	phi := make([]float64, m)
+	for i := 0; i < m; i++ {
+		for mask := 0; mask < 1<<uint(m); mask++ {
+			if mask&(1<<uint(i)) != 0 {
+				continue
+			}
+			subset, size := subsetFromMask(mask, m)
+			weight := factorial(size) * factorial(m-size-1) / factorial(m)
+			phi[i] += weight * (forestConditionalExpectation(f, features, withFeatures(subset, i)) -
+				forestConditionalExpectation(f, features, subset))
+		}
+	}
+	return phi
+}
+
+// bruteForceInteraction computes the Shapley interaction index between
+// features i and j by enumerating every subset of features
+func bruteForceInteraction(f *pb.Forest, features []float64, i, j int) float64 {
+	m := len(features)
+	result := 0.0
+	for mask := 0; mask < 1<<uint(m); mask++ {
+		if mask&(1<<uint(i)) != 0 || mask&(1<<uint(j)) != 0 {
+			continue
+		}
+		subset, size := subsetFromMask(mask, m)
+		weight := factorial(size) * factorial(m-size-2) / (2 * factorial(m-1))
+		result += weight * (forestConditionalExpectation(f, features, withFeatures(subset, i, j)) -
+			forestConditionalExpectation(f, features, withFeatures(subset, i)) -
+			forestConditionalExpectation(f, features, withFeatures(subset, j)) +
+			forestConditionalExpectation(f, features, subset))
+	}
+	return result
+}
+
+func margin(f *pb.Forest, features []float64) float64 {
+	subset, _ := subsetFromMask(1<<uint(len(features))-1, len(features))
+	return forestConditionalExpectation(f, features, subset)
+}
+
+func TestShapMatchesBruteForce(t *testing.T) {
+	numFeatures := 5
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_AVERAGING, pb.Rescaling_LOG_ODDS} {
+		forest := makeCoveredForest(4, 4, numFeatures, rescaling)
+		explainer, err := NewShapExplainer(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 10; i++ {
+			fv := randomFeatureVector(numFeatures)
+			explanation := explainer.Explain(fv)
+			expected := bruteForceShap(forest, fv)
+			for j := range expected {
+				if math.Abs(expected[j]-explanation.Contributions[j]) > 1e-9 {
+					t.Fatalf("%v: feature %v, expected %v, got %v", rescaling, j, expected[j], explanation.Contributions[j])
+				}
+			}
+
+			sum := explanation.Bias
+			for _, c := range explanation.Contributions {
+				sum += c
+			}
+			if math.Abs(sum-margin(forest, fv)) > 1e-9 {
+				t.Fatalf("%v: expected margin %v, contributions sum to %v", rescaling, margin(forest, fv), sum)
+			}
+		}
+	}
+}
+
+func TestShapMatchesEvaluator(t *testing.T) {
+	forest := makeCoveredForest(10, 5, 20, pb.Rescaling_LOG_ODDS)
+	explainer, err := NewShapExplainer(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evaluator, err := newUnscaledFastForestEvaluator(forest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([][]float64, 0, 50)
+	for i := 0; i < 50; i++ {
+		rows = append(rows, randomFeatureVector(20))
+	}
+	for i, explanation := range explainer.BatchExplain(rows) {
+		sum := explanation.Bias
+		for _, c := range explanation.Contributions {
+			sum += c
+		}
+		if math.Abs(sum-evaluator.Evaluate(rows[i])) > 1e-9 {
+			t.Fatalf("Expected %v, got %v", evaluator.Evaluate(rows[i]), sum)
+		}
+	}
+}
+
+func TestShapInteractions(t *testing.T) {
+	numFeatures := 5
+	for _, rescaling := range []pb.Rescaling{pb.Rescaling_NONE, pb.Rescaling_AVERAGING} {
+		forest := makeCoveredForest(3, 4, numFeatures, rescaling)
+		explainer, err := NewShapExplainer(forest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fv := randomFeatureVector(numFeatures)
+		explanation := explainer.Explain(fv)
+		interactions := explainer.BatchExplainInteractions([][]float64{fv})[0]
+		for i := 0; i < numFeatures; i++ {
+			rowSum := 0.0
+			for j := 0; j < numFeatures; j++ {
+				rowSum += interactions.Interactions[i][j]
+				if i == j {
+					continue
+				}
+				expected := bruteForceInteraction(forest, fv, i, j)
+				if math.Abs(expected-interactions.Interactions[i][j]) > 1e-9 {
+					t.Fatalf("%v: interaction (%v, %v), expected %v, got %v",
+						rescaling, i, j, expected, interactions.Interactions[i][j])
+				}
+			}
+			if math.Abs(rowSum-explanation.Contributions[i]) > 1e-9 {
+				t.Fatalf("%v: row %v sums to %v, expected %v", rescaling, i, rowSum, explanation.Contributions[i])
+			}
+		}
+	}
+}
+
+func TestShapRequiresCovers(t *testing.T) {
+	forest := makeForest(2, 2, 5)
+	if _, err := NewShapExplainer(forest); err == nil {
+		t.Fatal("Expected an error for a forest without cover annotations")
+	}
+}
@@ -0,0 +1,171 @@
+package decisiontrees
+
+import (
+	"fmt"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"strings"
+)
+
+// ConfigErrors is every problem found in validating a ForestConfig
+type ConfigErrors []*ConfigError
+
+func (c ConfigErrors) Error() string {
+	messages := make([]string, 0, len(c))
+	for _, e := range c {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+type configValidator struct {
+	errors ConfigErrors
+}
+
+func (v *configValidator) errorf(field string, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ConfigError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) validateSplittingConstraints(c *pb.SplittingConstraints) {
+	if c == nil {
+		v.errorf("splittingConstraints", "is required")
+		return
+	}
+	if c.MaximumLevels != nil && c.GetMaximumLevels() < 0 {
+		v.errorf("splittingConstraints.maximumLevels", "must be non-negative, got %v", c.GetMaximumLevels())
+	}
+	if c.MinimumAverageGain != nil && c.GetMinimumAverageGain() < 0 {
+		v.errorf("splittingConstraints.minimumAverageGain", "must be non-negative, got %v", c.GetMinimumAverageGain())
+	}
+	if c.MinimumSamplesAtLeaf != nil && c.GetMinimumSamplesAtLeaf() < 0 {
+		v.errorf("splittingConstraints.minimumSamplesAtLeaf", "must be non-negative, got %v", c.GetMinimumSamplesAtLeaf())
+	}
+}
+
+func (v *configValidator) validateLossFunctionConfig(c *pb.LossFunctionConfig) {
+	switch c.GetLossFunction() {
+	case pb.LossFunction_LOGIT, pb.LossFunction_LEAST_ABSOLUTE_DEVIATION:
+	case pb.LossFunction_HUBER:
+		if alpha := c.GetHuberAlpha(); alpha < 0 || alpha >= 1 {
+			v.errorf("lossFunctionConfig.huberAlpha", "must be in [0, 1), got %v", alpha)
+		}
+	default:
+		v.errorf("lossFunctionConfig.lossFunction", "unknown loss function %v", c.GetLossFunction())
+	}
+}
+
+func (v *configValidator) validateBoostingConfig(c *pb.ForestConfig) {
+	v.validateLossFunctionConfig(c.GetLossFunctionConfig())
+
+	if c.ShrinkageConfig != nil {
+		if shrinkage := c.GetShrinkageConfig().GetShrinkage(); shrinkage <= 0 || shrinkage > 1 {
+			v.errorf("shrinkageConfig.shrinkage", "must be in (0, 1], got %v", shrinkage)
+		}
+	}
+
+	if c.InfluenceTrimmingConfig != nil {
+		if alpha := c.GetInfluenceTrimmingConfig().GetAlpha(); alpha < 0 || alpha >= 1 {
+			v.errorf("influenceTrimmingConfig.alpha", "must be in [0, 1), got %v", alpha)
+		}
+		if rounds := c.GetInfluenceTrimmingConfig().GetWarmupRounds(); rounds < 0 {
+			v.errorf("influenceTrimmingConfig.warmupRounds", "must be non-negative, got %v", rounds)
+		}
+	}
+
+	if c.StochasticityConfig != nil {
+		if rate := c.GetStochasticityConfig().GetPerRoundSamplingRate(); rate <= 0 || rate > 1 {
+			v.errorf("stochasticityConfig.perRoundSamplingRate", "must be in (0, 1], got %v", rate)
+		}
+	}
+}
+
+func (v *configValidator) validateRandomForestConfig(c *pb.ForestConfig) {
+	s := c.GetStochasticityConfig()
+	if s == nil {
+		v.errorf("stochasticityConfig", "is required for random forests")
+		return
+	}
+	if p := s.GetExampleBoostrapProportion(); p <= 0 {
+		v.errorf("stochasticityConfig.exampleBoostrapProportion", "must be positive, got %v", p)
+	}
+	if n := s.GetFeatureSampleSize(); n <= 0 {
+		v.errorf("stochasticityConfig.featureSampleSize", "must be positive, got %v", n)
+	}
+}
+
+// validateExamples checks the examples against the config, and that each
+// has numFeatures features
+func (v *configValidator) validateExamples(c *pb.ForestConfig, name string, e []*pb.Example, numFeatures int) {
+	for i, ex := range e {
+		if len(ex.GetFeatures()) != numFeatures {
+			v.errorf(
+				fmt.Sprintf("%v[%v].features", name, i),
+				"has %v features, expected %v",
+				len(ex.GetFeatures()),
+				numFeatures)
+			break
+		}
+	}
+
+	if c.GetAlgorithm() != pb.Algorithm_BOOSTING ||
+		c.GetLossFunctionConfig().GetLossFunction() != pb.LossFunction_LOGIT {
+		return
+	}
+	for i, ex := range e {
+		if label := ex.GetLabel(); label != 1 && label != -1 {
+			v.errorf(
+				"lossFunctionConfig.lossFunction",
+				"LOGIT requires labels of -1 or +1, %v[%v] has label %v",
+				name,
+				i,
+				label)
+			return
+		}
+	}
+}
+
+// ValidateForestConfig checks the config for values that cannot be
+// trained, and if the training data is non-nil, that the examples are
+// compatible with the config.  It returns ConfigErrors holding every
+// problem found, or nil if there are none.
+func ValidateForestConfig(config *pb.ForestConfig, trainingData *pb.TrainingData) error {
+	v := &configValidator{}
+	if config == nil {
+		v.errorf("", "config is missing")
+		return v.errors
+	}
+
+	if n := config.GetNumWeakLearners(); n <= 0 {
+		v.errorf("numWeakLearners", "must be positive, got %v", n)
+	}
+	v.validateSplittingConstraints(config.GetSplittingConstraints())
+	if n := config.GetNumThreads(); n < 0 {
+		v.errorf("numThreads", "must be non-negative, got %v", n)
+	}
+
+	switch config.GetAlgorithm() {
+	case pb.Algorithm_BOOSTING:
+		v.validateBoostingConfig(config)
+	case pb.Algorithm_RANDOM_FOREST:
+		v.validateRandomForestConfig(config)
+	default:
+		v.errorf("algorithm", "unknown algorithm %v", config.GetAlgorithm())
+	}
+
+	if trainingData != nil {
+		if len(trainingData.GetTrain()) == 0 {
+			v.errorf("trainingData.train", "has no examples")
+		} else {
+			// Every example must have as many features as the first
+			// training example, as the test set is evaluated by the
+			// trained forest
+			numFeatures := len(trainingData.GetTrain()[0].GetFeatures())
+			v.validateExamples(config, "trainingData.train", trainingData.GetTrain(), numFeatures)
+			v.validateExamples(config, "trainingData.test", trainingData.GetTest(), numFeatures)
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package decisiontrees
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/ajtulloch/decisiontrees/protobufs"
+	"sort"
+	"testing"
+)
+
+func errorFields(err error) []string {
+	fields := make([]string, 0)
+	for _, e := range err.(ConfigErrors) {
+		fields = append(fields, e.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestValidateForestConfig(t *testing.T) {
+	valid := &pb.ForestConfig{
+		NumWeakLearners: proto.Int64(10),
+		SplittingConstraints: &pb.SplittingConstraints{
+			MaximumLevels: proto.Int64(3),
+		},
+		LossFunctionConfig: &pb.LossFunctionConfig{
+			LossFunction: pb.LossFunction_LOGIT.Enum(),
+		},
+		Algorithm: pb.Algorithm_BOOSTING.Enum(),
+	}
+	data := &pb.TrainingData{Train: constructBenchmarkExamples(10, 2, 0)}
+	if err := ValidateForestConfig(valid, data); err != nil {
+		t.Fatalf("Expected a valid config, got %v", err)
+	}
+
+	testCases := []struct {
+		config *pb.ForestConfig
+		data   *pb.TrainingData
+		fields []string
+	}{
+		{
+			config: &pb.ForestConfig{
+				LossFunctionConfig: &pb.LossFunctionConfig{
+					LossFunction: pb.LossFunction_HUBER.Enum(),
+					HuberAlpha:   proto.Float64(1.0),
+				},
+				ShrinkageConfig: &pb.ShrinkageConfig{
+					Shrinkage: proto.Float64(0),
+				},
+				Algorithm: pb.Algorithm_BOOSTING.Enum(),
+			},
+			fields: []string{
+				"lossFunctionConfig.huberAlpha",
+				"numWeakLearners",
+				"shrinkageConfig.shrinkage",
+				"splittingConstraints",
+			},
+		},
+		{
+			config: &pb.ForestConfig{
+				NumWeakLearners:      proto.Int64(10),
+				SplittingConstraints: &pb.SplittingConstraints{},
+				Algorithm:            pb.Algorithm_RANDOM_FOREST.Enum(),
+				NumThreads:           proto.Int64(-1),
+			},
+			fields: []string{"numThreads", "stochasticityConfig"},
+		},
+		{
+			config: valid,
+			data: &pb.TrainingData{
+				Train: []*pb.Example{
+					{Label: proto.Float64(0), Features: []float64{1, 2}},
+					{Label: proto.Float64(1), Features: []float64{1}},
+				},
+			},
+			fields: []string{"lossFunctionConfig.lossFunction", "trainingData.train[1].features"},
+		},
+		{
+			config: valid,
+			data: &pb.TrainingData{
+				Train: constructBenchmarkExamples(10, 2, 0),
+				Test: []*pb.Example{
+					{Label: proto.Float64(1), Features: []float64{1}},
+				},
+			},
+			fields: []string{"trainingData.test[0].features"},
+		},
+		{
+			config: valid,
+			data:   &pb.TrainingData{},
+			fields: []string{"trainingData.train"},
+		},
+	}
+
+	for i, tc := range testCases {
+		err := ValidateForestConfig(tc.config, tc.data)
+		if err == nil {
+			t.Fatalf("Case %v: expected errors for %v", i, tc.fields)
+		}
+		fields := errorFields(err)
+		if len(fields) != len(tc.fields) {
+			t.Fatalf("Case %v: expected errors for %v, got %v", i, tc.fields, err)
+		}
+		for j := range fields {
+			if fields[j] != tc.fields[j] {
+				t.Fatalf("Case %v: expected errors for %v, got %v", i, tc.fields, err)
+			}
+		}
+	}
+}